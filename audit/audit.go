@@ -0,0 +1,213 @@
+// Package audit keeps an append-only log of the changes made to the files
+// and to the bans, recording who made them, from where and what the records looked like
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/bans"
+	"github.com/socialnotes/mirror/fs"
+)
+
+// Bucket maps the big endian id of an entry to the Entry, ids grow with time.
+// Entries are never changed nor removed.
+var Bucket = []byte("audit_log")
+
+// Actions recorded in the log
+const (
+	Upload    = "upload"
+	Confirm   = "confirm"
+	Authorize = "authorize"
+	Revoke    = "revoke"
+	Approve   = "approve"
+	Reject    = "reject"
+	Delete    = "delete"
+	Move      = "move"
+	Hide      = "hide"
+	Restore   = "restore"
+	Metadata  = "metadata"
+	Uploader  = "uploader"
+	Takedown  = "takedown"
+	Counter   = "counter-notice"
+	Reinstate = "reinstate"
+	Ban       = "ban"
+	Unban     = "unban"
+)
+
+// Actions lists the actions recorded in the log
+var Actions = []string{
+	Upload, Confirm, Authorize, Revoke, Approve, Reject, Delete,
+	Move, Hide, Restore, Metadata, Uploader, Takedown, Counter, Reinstate,
+	Ban, Unban,
+}
+
+// An Entry records a change to a file, or to a ban
+type Entry struct {
+	ID   uint64
+	Time time.Time
+	// Action is one of the actions above
+	Action string
+	// Actor is the email of who made the change, or the name of the program for
+	// changes made without a request
+	Actor string
+	// IP is the address of the client who sent the request, if any
+	IP string
+	// Path is the path of the file, empty for bans
+	Path string
+	// To is the new path of a moved file
+	To string `json:",omitempty"`
+	// Details describes what changed when the records do not tell, e.g. the revision or the destination
+	Details string `json:",omitempty"`
+	// Before and After are the records of the file, or of the ban, around the change,
+	// null if it did not exist
+	Before json.RawMessage
+	After  json.RawMessage
+}
+
+// A Filter selects entries, empty fields match every entry
+type Filter struct {
+	Action string
+	// Actor matches the entries whose actor contains it, ignoring case
+	Actor string
+	// Path matches the entries whose path starts with it
+	Path  string
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether e is selected by f
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Actor != "" && !strings.Contains(strings.ToLower(e.Actor), strings.ToLower(f.Actor)):
+		return false
+	case f.Path != "" && !strings.HasPrefix(e.Path, f.Path):
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// Record appends e to the log with a new id, Time defaults to now
+func Record(tx *bolt.Tx, e Entry) error {
+	b, err := tx.CreateBucketIfNotExists(Bucket)
+	if err != nil {
+		return err
+	}
+	if e.ID, err = b.NextSequence(); err != nil {
+		return err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(e.ID), v)
+}
+
+// Change applies fn and records it as e, along with the records of the file
+// at e.Path before fn and at e.To, or e.Path, after it.
+// Nothing is recorded if fn fails.
+func Change(tx *bolt.Tx, e Entry, fn func() error) error {
+	e.Before = fileRecord(tx, e.Path)
+	if err := fn(); err != nil {
+		return err
+	}
+	after := e.Path
+	if e.To != "" {
+		after = e.To
+	}
+	e.After = fileRecord(tx, after)
+	return Record(tx, e)
+}
+
+// ChangeBan applies fn and records it as e, along with the ban with pattern
+// before and after it. Nothing is recorded if fn fails.
+func ChangeBan(tx *bolt.Tx, e Entry, pattern string, fn func() error) error {
+	e.Before = record(tx.Bucket(bans.Bucket), pattern)
+	if err := fn(); err != nil {
+		return err
+	}
+	e.After = record(tx.Bucket(bans.Bucket), pattern)
+	return Record(tx, e)
+}
+
+// fileRecord returns a copy of the record of the file at name, nil if there is none
+func fileRecord(tx *bolt.Tx, name string) json.RawMessage {
+	return record(tx.Bucket(fs.FilesBucket), name)
+}
+
+// record returns a copy of the value of k in b, nil if there is none
+func record(b *bolt.Bucket, k string) json.RawMessage {
+	if b == nil {
+		return nil
+	}
+	v := b.Get([]byte(k))
+	if v == nil {
+		return nil
+	}
+	return append(json.RawMessage{}, v...)
+}
+
+// List returns at most limit entries matching f, newest first,
+// starting from the one before the entry with id before, or from the newest if before is 0
+func List(tx *bolt.Tx, f Filter, before uint64, limit int) ([]Entry, error) {
+	list := make([]Entry, 0)
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return list, nil
+	}
+	c := b.Cursor()
+	k, v := c.Last()
+	if before > 0 {
+		if k, _ = c.Seek(key(before)); k != nil {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Last()
+		}
+	}
+	for ; k != nil && len(list) < limit; k, v = c.Prev() {
+		e := Entry{}
+		if err := json.Unmarshal(v, &e); err != nil {
+			return nil, err
+		}
+		if f.Match(e) {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+// Each calls fn on the entries matching f, oldest first, stopping at the first error
+func Each(tx *bolt.Tx, f Filter, fn func(e Entry) error) error {
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		e := Entry{}
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		if !f.Match(e) {
+			return nil
+		}
+		return fn(e)
+	})
+}
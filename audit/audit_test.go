@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestRecordList(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, e := range []Entry{
+			{Action: Upload, Actor: "a@unitn.it", Path: "/Fisica/a.txt"},
+			{Action: Confirm, Actor: "a@unitn.it", Path: "/Fisica/a.txt"},
+			{Action: Upload, Actor: "b@unitn.it", Path: "/Analisi/b.txt"},
+			{Action: Delete, Actor: "Admin@unitn.it", Path: "/Fisica/a.txt"},
+		} {
+			if err := Record(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		all, err := List(tx, Filter{}, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 4 || all[0].ID != 4 || all[0].Time.IsZero() {
+			t.Errorf("expected the 4 entries newest first, got %+v", all)
+		}
+
+		page, _ := List(tx, Filter{}, 3, 1)
+		if len(page) != 1 || page[0].ID != 2 {
+			t.Errorf("expected entry 2 before 3, got %+v", page)
+		}
+
+		fisica, _ := List(tx, Filter{Path: "/Fisica/", Actor: "a@unitn"}, 0, 10)
+		if len(fisica) != 2 {
+			t.Errorf("expected 2 entries of a@unitn.it in /Fisica/, got %+v", fisica)
+		}
+
+		var ids []uint64
+		Each(tx, Filter{Action: Upload}, func(e Entry) error {
+			ids = append(ids, e.ID)
+			return nil
+		})
+		if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+			t.Errorf("expected uploads 1 and 3 oldest first, got %v", ids)
+		}
+		return nil
+	})
+}
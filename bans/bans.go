@@ -0,0 +1,135 @@
+// Package bans stores the addresses which are not allowed to upload files
+package bans
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Bucket maps the pattern of a ban to the Ban
+var Bucket = []byte("bans")
+
+// ErrBadPattern is returned when a pattern cannot match an address
+var ErrBadPattern = errors.New("the pattern must be an address, * matches any sequence of characters")
+
+// A Ban stops the addresses matching Pattern from uploading files
+type Ban struct {
+	// Pattern is a lower case address, where * matches any sequence of characters
+	// and ? any single character, e.g. *@spam.unitn.it
+	Pattern string
+	Reason  string
+	// CreatedBy is the email of the admin who added the ban
+	CreatedBy string
+	CreatedAt time.Time
+	// Expires is the time the ban ends, zero if it never does
+	Expires time.Time
+}
+
+// Normalize returns pattern in lower case, or ErrBadPattern if it is not valid
+func Normalize(pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if !strings.Contains(pattern, "@") || strings.ContainsAny(pattern, " /[]\\") {
+		return "", ErrBadPattern
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", ErrBadPattern
+	}
+	return pattern, nil
+}
+
+// Active reports whether b applies at time t
+func (b Ban) Active(t time.Time) bool {
+	return b.Expires.IsZero() || t.Before(b.Expires)
+}
+
+// String describes b with its pattern, expiry and reason
+func (b Ban) String() string {
+	s := b.Pattern
+	if b.Expires.IsZero() {
+		s += ", never expires"
+	} else {
+		s += ", expires " + b.Expires.Format("2006-01-02 15:04")
+	}
+	if b.Reason != "" {
+		s += ": " + b.Reason
+	}
+	return s
+}
+
+// Matches reports whether the address email is matched by b, whether it is active or not
+func (b Ban) Matches(email string) bool {
+	ok, _ := path.Match(b.Pattern, strings.ToLower(email))
+	return ok
+}
+
+// Put stores b, replacing the ban with the same pattern
+func Put(tx *bolt.Tx, b Ban) error {
+	bucket, err := tx.CreateBucketIfNotExists(Bucket)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(b.Pattern), v)
+}
+
+// Get returns the ban with pattern, found is false if there is none
+func Get(tx *bolt.Tx, pattern string) (b Ban, found bool, err error) {
+	bucket := tx.Bucket(Bucket)
+	if bucket == nil {
+		return b, false, nil
+	}
+	v := bucket.Get([]byte(pattern))
+	if v == nil {
+		return b, false, nil
+	}
+	return b, true, json.Unmarshal(v, &b)
+}
+
+// Delete removes the ban with pattern, found is false if there is none
+func Delete(tx *bolt.Tx, pattern string) (found bool, err error) {
+	bucket := tx.Bucket(Bucket)
+	if bucket == nil || bucket.Get([]byte(pattern)) == nil {
+		return false, nil
+	}
+	return true, bucket.Delete([]byte(pattern))
+}
+
+// List returns every ban, including the expired ones, sorted by pattern
+func List(tx *bolt.Tx) ([]Ban, error) {
+	list := make([]Ban, 0)
+	bucket := tx.Bucket(Bucket)
+	if bucket == nil {
+		return list, nil
+	}
+	return list, bucket.ForEach(func(k, v []byte) error {
+		b := Ban{}
+		if err := json.Unmarshal(v, &b); err != nil {
+			return err
+		}
+		list = append(list, b)
+		return nil
+	})
+}
+
+// Check returns the active ban matching email, banned is false if there is none
+func Check(tx *bolt.Tx, email string) (b Ban, banned bool, err error) {
+	list, err := List(tx)
+	if err != nil {
+		return b, false, err
+	}
+	now := time.Now()
+	for _, b := range list {
+		if b.Active(now) && b.Matches(email) {
+			return b, true, nil
+		}
+	}
+	return b, false, nil
+}
@@ -0,0 +1,73 @@
+package bans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestNormalize(t *testing.T) {
+	for pattern, want := range map[string]string{
+		" Mario.Rossi@UNITN.it ": "mario.rossi@unitn.it",
+		"*@spam.unitn.it":        "*@spam.unitn.it",
+		"spam.unitn.it":          "",
+		"a b@unitn.it":           "",
+		"[a@unitn.it":            "",
+	} {
+		got, err := Normalize(pattern)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", pattern, got, err, want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range []Ban{
+			{Pattern: "*@spam.unitn.it", Reason: "junk"},
+			{Pattern: "old@unitn.it", Reason: "expired", Expires: time.Now().Add(-time.Hour)},
+			{Pattern: "new@unitn.it", Reason: "temporary", Expires: time.Now().Add(time.Hour)},
+		} {
+			if err := Put(tx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		for email, reason := range map[string]string{
+			"Someone@Spam.unitn.it": "junk",
+			"new@unitn.it":          "temporary",
+			"old@unitn.it":          "",
+			"someone@unitn.it":      "",
+		} {
+			b, banned, err := Check(tx, email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if banned != (reason != "") || b.Reason != reason {
+				t.Errorf("Check(%q) = %q, %v; want %q", email, b.Reason, banned, reason)
+			}
+		}
+		return nil
+	})
+
+	db.Update(func(tx *bolt.Tx) error {
+		if found, err := Delete(tx, "new@unitn.it"); err != nil || !found {
+			t.Errorf("deleting new@unitn.it: %v %v", found, err)
+		}
+		if list, _ := List(tx); len(list) != 2 {
+			t.Errorf("expected 2 bans left, got %+v", list)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,184 @@
+// Package comments stores the discussion threads attached to files
+package comments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/pending"
+)
+
+var (
+	// Bucket maps the path of a file followed by a zero byte and the big endian
+	// id of a comment to the comment, ids grow with time so threads are kept in order
+	Bucket = []byte("comments")
+	// PendingBucket maps tokens to the comments waiting for confirmation
+	PendingBucket = []byte("pending_comments")
+)
+
+// A Comment is a message left by a student about a file.
+// Hidden comments are kept for moderators but not shown.
+type Comment struct {
+	ID        uint64
+	Path      string
+	Email     string
+	Text      string
+	CreatedAt time.Time
+	Hidden    bool
+}
+
+func prefix(path string) []byte {
+	return []byte(path + "\x00")
+}
+
+func key(path string, id uint64) []byte {
+	k := make([]byte, len(path)+9)
+	copy(k, path)
+	binary.BigEndian.PutUint64(k[len(path)+1:], id)
+	return k
+}
+
+// each calls fn on every comment of the file at path, oldest first
+func each(tx *bolt.Tx, path string, fn func(c Comment) error) error {
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return nil
+	}
+	p := prefix(path)
+	c := b.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		cm := Comment{}
+		if err := json.Unmarshal(v, &cm); err != nil {
+			return err
+		}
+		if err := fn(cm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the comments of the file at path, oldest first.
+// Hidden comments are only included if hidden is true.
+func List(tx *bolt.Tx, path string, hidden bool) ([]Comment, error) {
+	list := make([]Comment, 0)
+	err := each(tx, path, func(c Comment) error {
+		if hidden || !c.Hidden {
+			list = append(list, c)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// Count returns the number of visible comments of the file at path
+func Count(tx *bolt.Tx, path string) (int, error) {
+	n := 0
+	err := each(tx, path, func(c Comment) error {
+		if !c.Hidden {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func put(b *bolt.Bucket, c Comment) error {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(c.Path, c.ID), v)
+}
+
+// Add stores c with a new id, which is returned
+func Add(tx *bolt.Tx, c Comment) (uint64, error) {
+	b, err := tx.CreateBucketIfNotExists(Bucket)
+	if err != nil {
+		return 0, err
+	}
+	if c.ID, err = b.NextSequence(); err != nil {
+		return 0, err
+	}
+	return c.ID, put(b, c)
+}
+
+// Get returns the comment id of the file at path, found is false if there is none
+func Get(tx *bolt.Tx, path string, id uint64) (c Comment, found bool, err error) {
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return c, false, nil
+	}
+	v := b.Get(key(path, id))
+	if v == nil {
+		return c, false, nil
+	}
+	return c, true, json.Unmarshal(v, &c)
+}
+
+// SetHidden hides or shows again the comment id of the file at path.
+// Missing comments are ignored.
+func SetHidden(tx *bolt.Tx, path string, id uint64, hidden bool) error {
+	c, found, err := Get(tx, path, id)
+	if err != nil || !found {
+		return err
+	}
+	c.Hidden = hidden
+	return put(tx.Bucket(Bucket), c)
+}
+
+// Remove deletes the comment id of the file at path
+func Remove(tx *bolt.Tx, path string, id uint64) error {
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete(key(path, id))
+}
+
+// Delete removes the whole thread of the file at path
+func Delete(tx *bolt.Tx, path string) error {
+	list, err := List(tx, path, true)
+	if err != nil {
+		return err
+	}
+	for _, c := range list {
+		if err := Remove(tx, path, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move transfers the thread of a file which has been renamed
+func Move(tx *bolt.Tx, from, to string) error {
+	list, err := List(tx, from, true)
+	if err != nil {
+		return err
+	}
+	if err := Delete(tx, from); err != nil {
+		return err
+	}
+	for _, c := range list {
+		c.Path = to
+		if err := put(tx.Bucket(Bucket), c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPending stores a comment until it is confirmed, returning the confirmation token
+func AddPending(tx *bolt.Tx, c Comment) (string, error) {
+	return pending.Put(tx, PendingBucket, c)
+}
+
+// TakePending removes and returns the comment waiting for confirmation with token,
+// found is false if there is none
+func TakePending(tx *bolt.Tx, token string) (c Comment, found bool, err error) {
+	found, err = pending.Take(tx, PendingBucket, token, &c)
+	return c, found, err
+}
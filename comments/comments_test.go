@@ -0,0 +1,56 @@
+package comments
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestThread(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		var ids []uint64
+		for _, c := range []Comment{
+			{Path: "/a.pdf", Text: "first"},
+			{Path: "/a.pdf.old", Text: "other file"},
+			{Path: "/a.pdf", Text: "spam"},
+			{Path: "/a.pdf", Text: "third"},
+			{Path: "/b.pdf", Text: "moved"},
+		} {
+			id, err := Add(tx, c)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := SetHidden(tx, "/a.pdf", ids[2], true); err != nil {
+			return err
+		}
+		return Move(tx, "/b.pdf", "/c.pdf")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		list, err := List(tx, "/a.pdf", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].Text != "first" || list[1].Text != "third" {
+			t.Errorf("unexpected visible comments %+v", list)
+		}
+		if all, _ := List(tx, "/a.pdf", true); len(all) != 3 {
+			t.Errorf("expected 3 comments including the hidden one, got %d", len(all))
+		}
+		for path, want := range map[string]int{"/a.pdf": 2, "/a.pdf.old": 1, "/b.pdf": 0, "/c.pdf": 1} {
+			if n, _ := Count(tx, path); n != want {
+				t.Errorf("expected %d comments on %s, got %d", want, path, n)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+// Package extract obtains the plain text of uploaded documents
+// so that it can be indexed for search
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxDocumentSize is the size of the largest document text is extracted from
+	MaxDocumentSize = 64 << 20
+	// MaxTextSize is the maximum length in bytes of the extracted text
+	MaxTextSize = 1 << 20
+)
+
+var (
+	// ErrUnsupported is returned when no parser exists for the document type
+	ErrUnsupported = errors.New("unsupported document type")
+	// ErrTooLarge is returned for documents bigger than MaxDocumentSize
+	ErrTooLarge = errors.New("document too large")
+)
+
+type parser func(r io.ReaderAt, size int64) (string, error)
+
+var parsers = map[string]parser{
+	".txt":      plainText,
+	".md":       plainText,
+	".markdown": plainText,
+	".pdf":      pdfText,
+	".docx":     docxText,
+	".pptx":     pptxText,
+}
+
+// Supported reports whether text can be extracted from a file with the given name
+func Supported(name string) bool {
+	_, ok := parsers[strings.ToLower(path.Ext(name))]
+	return ok
+}
+
+// Text returns the text contained in the document named name.
+// The parser is chosen based on the file extension.
+func Text(name string, r io.ReaderAt, size int64) (string, error) {
+	p, ok := parsers[strings.ToLower(path.Ext(name))]
+	if !ok {
+		return "", ErrUnsupported
+	}
+	if size > MaxDocumentSize {
+		return "", ErrTooLarge
+	}
+	text, err := p(r, size)
+	if err != nil {
+		return "", err
+	}
+	return clean(text), nil
+}
+
+// clean collapses whitespace, drops control characters and invalid
+// utf-8 sequences and truncates the text to MaxTextSize
+func clean(text string) string {
+	b := new(bytes.Buffer)
+	space := true
+	for _, r := range text {
+		switch {
+		case r == utf8.RuneError:
+			continue
+		case unicode.IsSpace(r):
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		case !unicode.IsPrint(r):
+			continue
+		}
+		if b.Len()+utf8.RuneLen(r) > MaxTextSize {
+			break
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func plainText(r io.ReaderAt, size int64) (string, error) {
+	// reading more than MaxTextSize would be wasted
+	b, err := ioutil.ReadAll(io.NewSectionReader(r, 0, MaxTextSize))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
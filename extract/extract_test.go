@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+)
+
+func pdf(content []byte, compress bool) []byte {
+	filter := ""
+	if compress {
+		b := new(bytes.Buffer)
+		zw := zlib.NewWriter(b)
+		zw.Write(content)
+		zw.Close()
+		content = b.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "%%PDF-1.4\n1 0 obj\n<< /Type /Page >>\nendobj\n")
+	fmt.Fprintf(b, "4 0 obj\n<< /Length %d%s >>\nstream\n", len(content), filter)
+	b.Write(content)
+	fmt.Fprintf(b, "\nendstream\nendobj\n%%%%EOF\n")
+	return b.Bytes()
+}
+
+func TestPDFText(t *testing.T) {
+	content := []byte(`BT /F1 12 Tf 72 712 Td (Esame di \(Analisi\)) Tj T* [(Uni) 20 (versit) -15 (\340) -300 (2019)] TJ ET
+BT <FEFF00630069006100f2> Tj ET`)
+	for _, compress := range []bool{false, true} {
+		data := pdf(content, compress)
+		text, err := Text("appunti.PDF", bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected := "Esame di (Analisi) Università 2019 ciaò"
+		if text != expected {
+			t.Errorf("expected %q, got %q", expected, text)
+		}
+	}
+}
+
+func TestOOXMLText(t *testing.T) {
+	b := new(bytes.Buffer)
+	zw := zip.NewWriter(b)
+	for _, slide := range []struct{ name, text string }{
+		{"ppt/slides/slide10.xml", "ten"},
+		{"ppt/slides/slide2.xml", "two"},
+		{"ppt/slideLayouts/slideLayout1.xml", "layout"},
+	} {
+		w, _ := zw.Create(slide.name)
+		fmt.Fprintf(w, `<p:sld xmlns:a="a" xmlns:p="p"><p:txBody><a:p><a:r><a:t>%s</a:t></a:r></a:p></p:txBody></p:sld>`, slide.text)
+	}
+	zw.Close()
+	text, err := Text("slides.pptx", bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if text != "two ten" {
+		t.Errorf("expected slides in order, got %q", text)
+	}
+
+	if _, err := Text("photo.jpg", bytes.NewReader(nil), 0); err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
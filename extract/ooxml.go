@@ -0,0 +1,105 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xmlText collects the character data of the text runs (<w:t> and <a:t>)
+// of an Office Open XML part, separating paragraphs with newlines
+func xmlText(w *bytes.Buffer, r io.Reader) error {
+	d := xml.NewDecoder(r)
+	inText := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab", "br":
+				w.WriteByte(' ')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				w.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				w.Write(t)
+			}
+		}
+		if w.Len() > MaxTextSize {
+			return nil
+		}
+	}
+}
+
+// ooxmlText extracts the text of the parts of the archive accepted by match,
+// in the order given by less
+func ooxmlText(r io.ReaderAt, size int64, match func(name string) bool, less func(a, b string) bool) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]*zip.File, 0)
+	for _, f := range zr.File {
+		if match(f.Name) {
+			parts = append(parts, f)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return less(parts[i].Name, parts[j].Name) })
+
+	w := new(bytes.Buffer)
+	for _, f := range parts {
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		err = xmlText(w, io.LimitReader(rc, MaxDocumentSize))
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return w.String(), nil
+}
+
+func docxText(r io.ReaderAt, size int64) (string, error) {
+	return ooxmlText(r, size, func(name string) bool {
+		return name == "word/document.xml"
+	}, func(a, b string) bool { return a < b })
+}
+
+// slideNumber returns n for ppt/slides/slide<n>.xml, -1 for other parts
+func slideNumber(name string) int {
+	const prefix, suffix = "ppt/slides/slide", ".xml"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func pptxText(r io.ReaderAt, size int64) (string, error) {
+	return ooxmlText(r, size, func(name string) bool {
+		return slideNumber(name) >= 0
+	}, func(a, b string) bool { return slideNumber(a) < slideNumber(b) })
+}
@@ -0,0 +1,347 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+)
+
+var (
+	errNotPDF = errors.New("not a pdf document")
+
+	// streams whose dictionary contains one of these never hold page content
+	pdfSkipStreams = [][]byte{
+		[]byte("/Subtype/Image"),
+		[]byte("/Type/XRef"),
+		[]byte("/Type/Metadata"),
+		[]byte("/Length1"),
+		[]byte("/Length2"),
+		[]byte("/Length3"),
+		[]byte("/Subtype/Type1C"),
+		[]byte("/Subtype/CIDFontType0C"),
+		[]byte("/Subtype/OpenType"),
+	}
+)
+
+// pdfText extracts the text drawn by the content streams of a PDF document.
+//
+// It is a best effort parser: instead of walking the page tree it decodes every
+// stream which may contain page content and interprets its text showing
+// operators. Fonts are ignored, so strings are decoded as PDFDocEncoding or
+// UTF-16; text drawn with composite fonts lacking a standard encoding is lost.
+func pdfText(r io.ReaderAt, size int64) (string, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", errNotPDF
+	}
+
+	w := new(bytes.Buffer)
+	for pos := 0; pos < len(data) && w.Len() < MaxTextSize; {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		pos = start + len("stream")
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+
+		// the stream dictionary lies between the object header and the keyword
+		dict := data[:start]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		bodyStart := pos
+		if bodyStart < len(data) && data[bodyStart] == '\r' {
+			bodyStart++
+		}
+		if bodyStart < len(data) && data[bodyStart] == '\n' {
+			bodyStart++
+		}
+		end := bytes.Index(data[bodyStart:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		pos = bodyStart + end + len("endstream")
+
+		if content := pdfStream(dict, data[bodyStart:bodyStart+end]); content != nil {
+			pdfShowText(w, content)
+		}
+	}
+	return w.String(), nil
+}
+
+// pdfStream returns the decoded body of a stream, or nil if the stream
+// is encoded with unsupported filters or cannot hold page content
+func pdfStream(dict, body []byte) []byte {
+	norm := bytes.Join(bytes.Fields(dict), nil)
+	for _, skip := range pdfSkipStreams {
+		if pdfHasKey(norm, skip) {
+			return nil
+		}
+	}
+	if !bytes.Contains(norm, []byte("/Filter")) {
+		return body
+	}
+	filters := bytes.Count(norm, []byte("Decode"))
+	if filters != 1 || !bytes.Contains(norm, []byte("/FlateDecode")) {
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	// truncated streams are common, keep whatever could be inflated
+	content, _ := ioutil.ReadAll(io.LimitReader(zr, MaxDocumentSize))
+	return content
+}
+
+// pdfHasKey reports whether the normalized dictionary contains key as
+// a whole token, so that /Length1 does not match /Length12
+func pdfHasKey(dict, key []byte) bool {
+	for i := 0; ; {
+		j := bytes.Index(dict[i:], key)
+		if j < 0 {
+			return false
+		}
+		end := i + j + len(key)
+		if end == len(dict) {
+			return true
+		}
+		if c := dict[end]; !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return true
+		}
+		i = end
+	}
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// pdfShowText interprets the text operators of a content stream
+// writing the strings they show to w
+func pdfShowText(w *bytes.Buffer, content []byte) {
+	var (
+		inText  bool
+		inArray bool
+		operand []byte
+		array   []byte
+	)
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(' || (c == '<' && (i+1 >= len(content) || content[i+1] != '<')):
+			var s []byte
+			if c == '(' {
+				s, i = pdfLiteralString(content, i+1)
+			} else {
+				s, i = pdfHexString(content, i+1)
+			}
+			if inArray {
+				array = append(array, s...)
+			} else {
+				operand = s
+			}
+		case c == '[':
+			inArray = true
+			array = array[:0]
+			i++
+		case c == ']':
+			inArray = false
+			operand = append([]byte(nil), array...)
+			i++
+		case isPDFDelimiter(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			tok := string(content[start:i])
+			if inArray {
+				// large negative adjustments in TJ arrays separate words
+				if len(tok) > 1 && tok[0] == '-' && pdfKerning(tok) > 200 {
+					array = append(array, ' ')
+				}
+				continue
+			}
+			switch tok {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				w.WriteByte('\n')
+			case "Tj", "TJ", "'", "\"":
+				if inText {
+					if tok == "'" || tok == "\"" {
+						w.WriteByte('\n')
+					}
+					w.WriteString(pdfDecodeString(operand))
+				}
+			case "Td", "TD", "T*", "Tm":
+				if inText {
+					w.WriteByte(' ')
+				}
+			case "ID":
+				// skip inline image data
+				end := bytes.Index(content[i:], []byte("EI"))
+				if end < 0 {
+					return
+				}
+				i += end + 2
+			}
+			operand = nil
+		}
+	}
+}
+
+// pdfKerning parses the absolute value of a negative TJ adjustment
+func pdfKerning(tok string) int {
+	n := 0
+	for _, c := range tok[1:] {
+		if c < '0' || c > '9' {
+			break
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+// pdfLiteralString parses a (string) starting after the opening parenthesis,
+// returning its bytes and the position after the closing parenthesis
+func pdfLiteralString(b []byte, i int) ([]byte, int) {
+	s := make([]byte, 0, 32)
+	depth := 1
+	for i < len(b) {
+		c := b[i]
+		i++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, i
+			}
+		case '\\':
+			if i >= len(b) {
+				return s, i
+			}
+			e := b[i]
+			i++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// line continuation
+				if e == '\r' && i < len(b) && b[i] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for k := 0; k < 2 && i < len(b) && b[i] >= '0' && b[i] <= '7'; k++ {
+						n = n*8 + int(b[i]-'0')
+						i++
+					}
+					c = byte(n)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s, i
+}
+
+// pdfHexString parses a <hex string> starting after the opening bracket
+func pdfHexString(b []byte, i int) ([]byte, int) {
+	s := make([]byte, 0, 16)
+	digits := make([]byte, 0, 2)
+	for i < len(b) && b[i] != '>' {
+		c := b[i]
+		i++
+		var v byte
+		switch {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		digits = append(digits, v)
+		if len(digits) == 2 {
+			s = append(s, digits[0]<<4|digits[1])
+			digits = digits[:0]
+		}
+	}
+	if len(digits) == 1 {
+		s = append(s, digits[0]<<4)
+	}
+	return s, i + 1
+}
+
+// pdfDecodeString decodes a string as UTF-16BE if it starts with a byte order
+// mark or looks like it, as PDFDocEncoding (approximated by latin-1) otherwise
+func pdfDecodeString(s []byte) string {
+	utf16be := len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff
+	if utf16be {
+		s = s[2:]
+	} else if len(s) >= 2 && len(s)%2 == 0 {
+		utf16be = true
+		for i := 0; i < len(s); i += 2 {
+			if s[i] != 0 {
+				utf16be = false
+				break
+			}
+		}
+	}
+	if utf16be {
+		u := make([]uint16, 0, len(s)/2)
+		for i := 0; i+1 < len(s); i += 2 {
+			u = append(u, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	r := make([]rune, len(s))
+	for i, c := range s {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
@@ -8,6 +8,13 @@ import (
 var (
 	// FilesBucket is the name of the bucket containing file information
 	FilesBucket = []byte("files")
+	// DirectoriesBucket maps directory paths, ending with a slash, to their information
+	DirectoriesBucket = []byte("directories")
+	// DescriptionsBucket held the directory descriptions before DirectoriesBucket existed,
+	// it is only read to migrate older databases
+	DescriptionsBucket = []byte("descriptions")
+	// EditsBucket maps tokens to the description edits waiting for confirmation
+	EditsBucket = []byte("edits")
 )
 
 // A DBFile is the structure used to serialize file information to boltdb
@@ -25,6 +32,66 @@ type DBFile struct {
 	Token string
 	// Authorized is set to true after the user verified the upload
 	Authorized bool
+	// AuthorizedAt is the time the upload was verified
+	AuthorizedAt time.Time
+	// AwaitingApproval is set on files uploaded while moderation is required,
+	// until a moderator approves them
+	AwaitingApproval bool `json:",omitempty"`
+	// ApprovedBy is the email of the moderator who approved the file, if any
+	ApprovedBy string `json:",omitempty"`
+	// ApprovedAt is the time the file was approved
+	ApprovedAt time.Time
+	// Revision is the number of the current revision, zero for files never updated
+	Revision uint64 `json:",omitempty"`
+	// Flagged is set when the file is hidden because of reports, or by an admin,
+	// until an admin reviews it
+	Flagged bool `json:",omitempty"`
+	// ReviewedAt is the time of the last review by an admin,
+	// only later reports count towards hiding the file again
+	ReviewedAt time.Time
+	// Takedown is set when the file has been taken down on request,
+	// the record is kept to explain why the file is unavailable
+	Takedown *Takedown `json:",omitempty"`
+
+	// Metadata describes the content of the file
+	Metadata
+}
+
+// A Takedown records why a file was taken down and the answer of its uploader
+type Takedown struct {
+	// Reason is shown to visitors asking for the file
+	Reason string
+	// Requester is who asked to take the file down
+	Requester string
+	// Date is the time the file was taken down
+	Date time.Time
+	// Notice is the id of the takedown notice, zero if there was none
+	Notice uint64 `json:",omitempty"`
+
+	// CounterName and CounterStatement are the counter-notice of the uploader, if any
+	CounterName      string `json:",omitempty"`
+	CounterStatement string `json:",omitempty"`
+	// CounterAt is the time the counter-notice was sent, zero if there is none
+	CounterAt time.Time
+}
+
+// DocumentTypes lists the kinds of document a file can be
+var DocumentTypes = []string{"notes", "slides", "exam", "solutions", "exercises", "book", "other"}
+
+// Metadata describes the content of a file, every field is optional
+type Metadata struct {
+	// Course is the code of the course the file belongs to
+	Course string `json:",omitempty"`
+	// Professor is the name of the professor holding the course
+	Professor string `json:",omitempty"`
+	// Year is the academic year, as 2016/17
+	Year string `json:",omitempty"`
+	// Type is one of DocumentTypes
+	Type string `json:",omitempty"`
+	// Language is the language of the document, as an ISO 639-1 code
+	Language string `json:",omitempty"`
+	// Tags are free lowercase labels
+	Tags []string `json:",omitempty"`
 }
 
 // FromFileInfo returns an instance of DBFile constructed from a os.FileInfo
@@ -36,3 +103,110 @@ func FromFileInfo(fi os.FileInfo) DBFile {
 		ModTime: fi.ModTime(),
 	}
 }
+
+// Published reports whether the file can be shown and served to visitors
+func (f DBFile) Published() bool {
+	return f.Authorized && !f.AwaitingApproval && !f.Flagged && f.Takedown == nil
+}
+
+// PublishedAt returns the time the file was published, which is the approval
+// by a moderator if it came after the confirmation of the uploader.
+// Records created before AuthorizedAt existed fall back to the modification time.
+func (f DBFile) PublishedAt() time.Time {
+	switch {
+	case f.ApprovedAt.After(f.AuthorizedAt):
+		return f.ApprovedAt
+	case f.AuthorizedAt.IsZero():
+		return f.ModTime
+	}
+	return f.AuthorizedAt
+}
+
+// A DBDirectory is the structure used to serialize directory information to boltdb
+type DBDirectory struct {
+	// CreatedAt is the time the directory was created
+	CreatedAt time.Time
+	// Creator is the email of the person who created the directory
+	Creator string
+	// UploadOpen is true if visitors can upload files to the directory
+	UploadOpen bool
+
+	// Description is shown above the listing of the directory, in markdown
+	Description string
+	// Maintainers are the emails allowed to edit the description
+	// of the directory and of its subdirectories
+	Maintainers []string
+	// DescriptionEmail is the email of the last person who edited the description
+	DescriptionEmail string
+	// DescriptionUpdatedAt is the time of the last edit of the description
+	DescriptionUpdatedAt time.Time
+}
+
+// Moderation actions on comments
+const (
+	CommentHide   = "hide"
+	CommentShow   = "show"
+	CommentDelete = "delete"
+)
+
+// Review actions on reported files
+const (
+	ReviewHide    = "hide"
+	ReviewRestore = "restore"
+)
+
+// Admin actions on takedown notices and on the files they took down
+const (
+	TakedownApply   = "apply"
+	TakedownReject  = "reject"
+	TakedownRestore = "restore"
+)
+
+// The kinds of DBEdit, by what they change
+const (
+	EditDescription = "description"
+	EditMetadata    = "metadata"
+	EditComment     = "comment"
+	EditReview      = "review"
+	EditNotice      = "notice"
+	EditRestore     = "restore"
+)
+
+// A DBEdit is a change to the description of a directory, to the metadata of a file,
+// to one of its comments or to its visibility, waiting for the editor to confirm it by email
+type DBEdit struct {
+	// Kind is one of EditDescription, EditMetadata, EditComment, EditReview,
+	// EditNotice and EditRestore, the fields below which it does not use are empty
+	Kind string
+	// Path is the path of the directory, ending with a slash, or of the file
+	Path string
+	// Metadata replaces the metadata of the file at Path, it is nil for directory edits
+	Metadata *Metadata
+	// Text is the new description
+	Text string
+	// Comment is the id of the comment of the file at Path moderated with CommentAction,
+	// one of CommentHide, CommentShow and CommentDelete
+	Comment       uint64
+	CommentAction string
+	// ReviewAction hides or restores the file at Path, it is one of ReviewHide and ReviewRestore
+	ReviewAction string
+	// TakedownAction applies or rejects the notice Notice, or restores the file at Path
+	// taken down; it is one of TakedownApply, TakedownReject and TakedownRestore
+	TakedownAction string
+	Notice         uint64
+	// Maintainers replaces the maintainers of the directory if SetMaintainers is true
+	Maintainers    []string
+	SetMaintainers bool
+	// Email is the email of the editor
+	Email string
+	// CreatedAt is the time the edit was submitted
+	CreatedAt time.Time
+}
+
+// Dir returns the directory whose editors can apply the edit
+func (e DBEdit) Dir() string {
+	if e.Kind != EditDescription {
+		return FileDir(e.Path)
+	}
+	return e.Path
+}
@@ -1 +1,79 @@
 package fs
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestParentDir(t *testing.T) {
+	for dir, parent := range map[string]string{"/": "/", "/a/": "/", "/a/b/": "/a/"} {
+		if p := ParentDir(dir); p != parent {
+			t.Errorf("expected %s to be the parent of %s, got %s", parent, dir, p)
+		}
+	}
+	if d := FileDir("/a/b.txt"); d != "/a/" {
+		t.Errorf("expected /a/ to contain /a/b.txt, got %s", d)
+	}
+}
+
+func TestMigrateDirectories(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	old := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := db.Update(func(tx *bolt.Tx) error {
+		files, err := tx.CreateBucket(FilesBucket)
+		if err != nil {
+			return err
+		}
+		for path, mtime := range map[string]time.Time{
+			"/Fisica/a.txt":     old.Add(time.Hour),
+			"/Fisica/sub/b.txt": old,
+			"/Analisi/c.txt":    old.Add(2 * time.Hour),
+		} {
+			v, _ := json.Marshal(DBFile{Name: filepath.Base(path), ModTime: mtime})
+			if err := files.Put([]byte(path), v); err != nil {
+				return err
+			}
+		}
+		descs, err := tx.CreateBucket(DescriptionsBucket)
+		if err != nil {
+			return err
+		}
+		v, _ := json.Marshal(map[string]interface{}{"Text": "# Fisica", "Maintainers": []string{"prof@unitn.it"}})
+		return descs.Put([]byte("/Fisica/"), v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(MigrateDirectories); err != nil {
+		t.Fatal(err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(DescriptionsBucket) != nil {
+			t.Error("the descriptions bucket was not removed")
+		}
+		for _, dir := range []string{"/", "/Fisica/", "/Fisica/sub/", "/Analisi/"} {
+			if _, found, err := GetDirectory(tx, dir); !found || err != nil {
+				t.Errorf("directory %s not created: %v", dir, err)
+			}
+		}
+		dbd, _, _ := GetDirectory(tx, "/Fisica/")
+		if !dbd.CreatedAt.Equal(old) || !dbd.UploadOpen {
+			t.Errorf("unexpected directory record %+v", dbd)
+		}
+		if dbd.Description != "# Fisica" || len(dbd.Maintainers) != 1 {
+			t.Errorf("description not migrated: %+v", dbd)
+		}
+		if _, found, _ := GetDirectory(tx, "/Fis/"); found {
+			t.Error("unexpected directory /Fis/")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package fs
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ParentDir returns the parent of the directory dir, both ending with a slash.
+// The parent of the root is the root itself.
+func ParentDir(dir string) string {
+	if dir == "/" {
+		return "/"
+	}
+	return FileDir(strings.TrimSuffix(dir, "/"))
+}
+
+// FileDir returns the directory containing the file at name, ending with a slash
+func FileDir(name string) string {
+	dir := path.Dir(name)
+	if dir != "/" {
+		dir += "/"
+	}
+	return dir
+}
+
+// GetDirectory returns the directory stored at dir,
+// found is false if there is no such directory
+func GetDirectory(tx *bolt.Tx, dir string) (dbd DBDirectory, found bool, err error) {
+	b := tx.Bucket(DirectoriesBucket)
+	if b == nil {
+		return dbd, false, nil
+	}
+	v := b.Get([]byte(dir))
+	if v == nil {
+		return dbd, false, nil
+	}
+	return dbd, true, json.Unmarshal(v, &dbd)
+}
+
+// PutDirectory stores dbd at dir
+func PutDirectory(tx *bolt.Tx, dir string, dbd DBDirectory) error {
+	b, err := tx.CreateBucketIfNotExists(DirectoriesBucket)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(dbd)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(dir), v)
+}
+
+// MakeDirectories creates the directory dir along with its missing parents,
+// initializing all of them with dbd. Existing directories are left untouched.
+func MakeDirectories(tx *bolt.Tx, dir string, dbd DBDirectory) error {
+	for {
+		_, found, err := GetDirectory(tx, dir)
+		if err != nil {
+			return err
+		}
+		if !found {
+			if err := PutDirectory(tx, dir, dbd); err != nil {
+				return err
+			}
+		}
+		if dir == "/" {
+			return nil
+		}
+		dir = ParentDir(dir)
+	}
+}
+
+// MigrateDirectories creates the directories bucket of databases created by older versions,
+// where directories only existed as prefixes of the paths of the files.
+// Every directory gets the creation time of the oldest file it contains and is open to uploads.
+// Descriptions are moved from DescriptionsBucket.
+func MigrateDirectories(tx *bolt.Tx) error {
+	dirs := make(map[string]DBDirectory)
+	// add records dir and its parents, which are at least as old as t
+	add := func(dir string, t time.Time) {
+		for ; ; dir = ParentDir(dir) {
+			dbd, ok := dirs[dir]
+			if !ok || t.Before(dbd.CreatedAt) {
+				dbd.CreatedAt = t
+				dbd.UploadOpen = true
+				dirs[dir] = dbd
+			}
+			if dir == "/" {
+				return
+			}
+		}
+	}
+	add("/", time.Now())
+	err := tx.Bucket(FilesBucket).ForEach(func(k, v []byte) error {
+		dbf := DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		add(FileDir(string(k)), dbf.ModTime)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if b := tx.Bucket(DescriptionsBucket); b != nil {
+		err := b.ForEach(func(k, v []byte) error {
+			desc := struct {
+				Text        string
+				Maintainers []string
+				Email       string
+				UpdatedAt   time.Time
+			}{}
+			if err := json.Unmarshal(v, &desc); err != nil {
+				return err
+			}
+			if _, ok := dirs[string(k)]; !ok {
+				// the directory does not contain files anymore
+				add(string(k), desc.UpdatedAt)
+			}
+			dbd := dirs[string(k)]
+			dbd.Description = desc.Text
+			dbd.Maintainers = desc.Maintainers
+			dbd.DescriptionEmail = desc.Email
+			dbd.DescriptionUpdatedAt = desc.UpdatedAt
+			dirs[string(k)] = dbd
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(DescriptionsBucket); err != nil {
+			return err
+		}
+	}
+
+	for dir, dbd := range dirs {
+		if err := PutDirectory(tx, dir, dbd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
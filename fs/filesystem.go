@@ -57,3 +57,44 @@ func (d Dir) cleanPath(name string) (string, error) {
 
 	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), nil
 }
+
+// MkdirAll creates the directory name along with any missing parent
+func (d Dir) MkdirAll(name string) error {
+	path, err := d.cleanPath(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+// Rename moves the file at from to to, replacing it if it exists
+func (d Dir) Rename(from, to string) error {
+	fromPath, err := d.cleanPath(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := d.cleanPath(to)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fromPath, toPath)
+}
+
+// Remove deletes the file or empty directory at name
+func (d Dir) Remove(name string) error {
+	path, err := d.cleanPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// RemoveAll deletes name along with everything it contains,
+// it returns nil if name does not exist
+func (d Dir) RemoveAll(name string) error {
+	path, err := d.cleanPath(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
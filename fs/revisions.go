@@ -0,0 +1,256 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// RevisionsDir is the directory, hidden from listings, where the content
+// of older and pending revisions is stored
+const RevisionsDir = "/.revisions"
+
+// RevisionsBucket maps the path of a file followed by a zero byte and the big endian
+// number of a revision to its DBRevision. It holds the revisions replaced by a newer one
+// and the ones waiting for confirmation, the current revision is the file itself.
+var RevisionsBucket = []byte("revisions")
+
+// PendingRevisionsBucket indexes the revisions waiting for confirmation: it maps their token,
+// a zero byte and the path of their file to the big endian number of the revision
+var PendingRevisionsBucket = []byte("pending_revisions")
+
+// A DBRevision is a version of the content of a file
+type DBRevision struct {
+	// Number counts the revisions of the file, starting from 1
+	Number uint64
+	// Size is the size in bytes of the revision
+	Size int64
+	// ModTime is the time the revision was uploaded
+	ModTime time.Time
+
+	// Email is the email of the person who uploaded the revision
+	Email string
+	// Token is used to verify the upload
+	Token string
+	// Authorized is set to true after the uploader verified the revision
+	Authorized bool
+	// AuthorizedAt is the time the revision was verified
+	AuthorizedAt time.Time
+	// AwaitingApproval is set on revisions uploaded while moderation is required,
+	// until a moderator approves them
+	AwaitingApproval bool `json:",omitempty"`
+}
+
+// Published reports whether the revision can be shown to visitors
+func (r DBRevision) Published() bool {
+	return r.Authorized && !r.AwaitingApproval
+}
+
+// RevisionPath returns where the content of revision n of the file at name is stored
+func RevisionPath(name string, n uint64) string {
+	return path.Join(RevisionsDir, name, strconv.FormatUint(n, 10))
+}
+
+func revisionKey(name string, n uint64) []byte {
+	k := make([]byte, len(name)+9)
+	copy(k, name)
+	binary.BigEndian.PutUint64(k[len(name)+1:], n)
+	return k
+}
+
+func pendingKey(token, name string) []byte {
+	return []byte(token + "\x00" + name)
+}
+
+// indexPending adds r to the PendingRevisionsBucket if it is not authorized yet,
+// and removes it otherwise
+func indexPending(tx *bolt.Tx, name string, r DBRevision) error {
+	b, err := tx.CreateBucketIfNotExists(PendingRevisionsBucket)
+	if err != nil {
+		return err
+	}
+	if r.Authorized {
+		return b.Delete(pendingKey(r.Token, name))
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, r.Number)
+	return b.Put(pendingKey(r.Token, name), v)
+}
+
+// PendingRevisions returns the revisions uploaded with token and not authorized yet, by path
+func PendingRevisions(tx *bolt.Tx, token string) (map[string]DBRevision, error) {
+	revs := make(map[string]DBRevision)
+	b := tx.Bucket(PendingRevisionsBucket)
+	if b == nil {
+		return revs, nil
+	}
+	prefix := pendingKey(token, "")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		name := string(k[len(prefix):])
+		r, found, err := GetRevision(tx, name, binary.BigEndian.Uint64(v))
+		if err != nil {
+			return nil, err
+		}
+		if found && !r.Authorized {
+			revs[name] = r
+		}
+	}
+	return revs, nil
+}
+
+// IndexPendingRevisions builds the PendingRevisionsBucket of databases created by older versions
+func IndexPendingRevisions(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(PendingRevisionsBucket); err != nil {
+		return err
+	}
+	b := tx.Bucket(RevisionsBucket)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		r := DBRevision{}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		if r.Authorized {
+			return nil
+		}
+		// keys are the path, a zero byte and the revision number
+		return indexPending(tx, string(k[:len(k)-9]), r)
+	})
+}
+
+// Revisions returns the stored revisions of the file at name, oldest first
+func Revisions(tx *bolt.Tx, name string) ([]DBRevision, error) {
+	revs := make([]DBRevision, 0)
+	b := tx.Bucket(RevisionsBucket)
+	if b == nil {
+		return revs, nil
+	}
+	prefix := []byte(name + "\x00")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		r := DBRevision{}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	return revs, nil
+}
+
+// GetRevision returns the revision n of the file at name, found is false if it is not stored
+func GetRevision(tx *bolt.Tx, name string, n uint64) (r DBRevision, found bool, err error) {
+	b := tx.Bucket(RevisionsBucket)
+	if b == nil {
+		return r, false, nil
+	}
+	v := b.Get(revisionKey(name, n))
+	if v == nil {
+		return r, false, nil
+	}
+	return r, true, json.Unmarshal(v, &r)
+}
+
+// PutRevision stores r as revision r.Number of the file at name
+func PutRevision(tx *bolt.Tx, name string, r DBRevision) error {
+	b, err := tx.CreateBucketIfNotExists(RevisionsBucket)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(revisionKey(name, r.Number), v); err != nil {
+		return err
+	}
+	return indexPending(tx, name, r)
+}
+
+// DeleteRevision removes the record of revision n of the file at name
+func DeleteRevision(tx *bolt.Tx, name string, n uint64) error {
+	r, found, err := GetRevision(tx, name, n)
+	if err != nil || !found {
+		return err
+	}
+	if b := tx.Bucket(PendingRevisionsBucket); b != nil && !r.Authorized {
+		if err := b.Delete(pendingKey(r.Token, name)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(RevisionsBucket).Delete(revisionKey(name, n))
+}
+
+// NextRevision returns the number of the next revision of dbf, stored at name
+func NextRevision(tx *bolt.Tx, name string, dbf DBFile) (uint64, error) {
+	revs, err := Revisions(tx, name)
+	if err != nil {
+		return 0, err
+	}
+	n := dbf.CurrentRevision()
+	for _, r := range revs {
+		if r.Number > n {
+			n = r.Number
+		}
+	}
+	return n + 1, nil
+}
+
+// AsRevision returns the current content of dbf as a revision
+func (f DBFile) AsRevision() DBRevision {
+	return DBRevision{
+		Number:       f.CurrentRevision(),
+		Size:         f.Size,
+		ModTime:      f.ModTime,
+		Email:        f.Email,
+		Token:        f.Token,
+		Authorized:   f.Authorized,
+		AuthorizedAt: f.AuthorizedAt,
+	}
+}
+
+// CurrentRevision returns the number of the revision served as the file
+func (f DBFile) CurrentRevision() uint64 {
+	if f.Revision == 0 {
+		return 1
+	}
+	return f.Revision
+}
+
+// DeleteRevisions removes the records of every revision of the file at name
+func DeleteRevisions(tx *bolt.Tx, name string) error {
+	revs, err := Revisions(tx, name)
+	if err != nil {
+		return err
+	}
+	for _, r := range revs {
+		if err := DeleteRevision(tx, name, r.Number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveRevisions transfers the records of the revisions of a file which has been renamed
+func MoveRevisions(tx *bolt.Tx, from, to string) error {
+	revs, err := Revisions(tx, from)
+	if err != nil {
+		return err
+	}
+	if err := DeleteRevisions(tx, from); err != nil {
+		return err
+	}
+	for _, r := range revs {
+		if err := PutRevision(tx, to, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestPendingRevisions(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	pending := func(tx *bolt.Tx, token string) map[string]DBRevision {
+		revs, err := PendingRevisions(tx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return revs
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		for _, r := range []struct {
+			name string
+			r    DBRevision
+		}{
+			{"/a/x.txt", DBRevision{Number: 1, Token: "t1", Authorized: true}},
+			{"/a/x.txt", DBRevision{Number: 2, Token: "t2"}},
+			{"/a/y.txt", DBRevision{Number: 3, Token: "t2"}},
+			{"/a/z.txt", DBRevision{Number: 2, Token: "t22"}},
+		} {
+			if err := PutRevision(tx, r.name, r.r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if revs := pending(tx, "t1"); len(revs) != 0 {
+			t.Errorf("expected authorized revisions not to be pending, got %v", revs)
+		}
+		if revs := pending(tx, "t2"); len(revs) != 2 || revs["/a/x.txt"].Number != 2 || revs["/a/y.txt"].Number != 3 {
+			t.Errorf("expected two revisions for t2, got %v", revs)
+		}
+
+		if err := MoveRevisions(tx, "/a/x.txt", "/b/x.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if revs := pending(tx, "t2"); len(revs) != 2 || revs["/b/x.txt"].Number != 2 {
+			t.Errorf("expected the index to follow the file, got %v", revs)
+		}
+		r := DBRevision{Number: 3, Token: "t2", Authorized: true}
+		if err := PutRevision(tx, "/a/y.txt", r); err != nil {
+			t.Fatal(err)
+		}
+		if err := DeleteRevisions(tx, "/b/x.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if revs := pending(tx, "t2"); len(revs) != 0 {
+			t.Errorf("expected no revisions left for t2, got %v", revs)
+		}
+
+		// databases of older versions have no index
+		if err := tx.DeleteBucket(PendingRevisionsBucket); err != nil {
+			t.Fatal(err)
+		}
+		if err := IndexPendingRevisions(tx); err != nil {
+			t.Fatal(err)
+		}
+		if revs := pending(tx, "t22"); len(revs) != 1 || revs["/a/z.txt"].Number != 2 {
+			t.Errorf("expected the index to be rebuilt, got %v", revs)
+		}
+		return nil
+	})
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/recent"
+	"github.com/socialnotes/mirror/search"
 )
 
 var (
@@ -18,24 +20,61 @@ var (
 	dbFile  = flag.String("db-file", "db.bolt", "bolt database file")
 
 	verbose = flag.Bool("verbose", true, "be verbose during indexing")
+
+	fullText = flag.Bool("full-text", false, "extract and index the text of documents")
+	backfill = flag.Bool("backfill", false, "keep the existing database and only index the text of documents not indexed yet")
+	force    = flag.Bool("force", false, "replace an existing database, losing everything but the files: audit log, bans, takedown notices, comments, ratings, reports and download counts")
 )
 
-func walker(b *bolt.Bucket, prefix, email string) filepath.WalkFunc {
+// indexText extracts the text of the published documents missing from the content index
+func indexText(db *bolt.DB, dir fs.Dir) error {
+	var paths []string
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		paths, err = search.MissingContent(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i, path := range paths {
+		if *verbose {
+			log.Printf("[info] extracting text (%d/%d) %s\n", i+1, len(paths), path)
+		}
+		if err := search.IndexContent(db, dir, path); err != nil {
+			log.Printf("[err] indexing content of %s: %s\n", path, err)
+		}
+	}
+	return nil
+}
+
+func walker(tx *bolt.Tx, prefix, email string) filepath.WalkFunc {
+	b := tx.Bucket(fs.FilesBucket)
 	return func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Printf("[err] indexing file %s: %s\n", path, err)
 			return nil
 		}
+		if info.IsDir() && strings.TrimPrefix(path, prefix) == fs.RevisionsDir {
+			// older revisions are not files of their own
+			return filepath.SkipDir
+		}
 		if info.IsDir() {
-			return nil
+			dir := strings.TrimSuffix(strings.TrimPrefix(path, prefix), "/") + "/"
+			return fs.PutDirectory(tx, dir, fs.DBDirectory{
+				CreatedAt:  info.ModTime(),
+				Creator:    email,
+				UploadOpen: true,
+			})
 		}
 		dbf := fs.DBFile{
 			Name:    info.Name(),
 			Size:    info.Size(),
 			ModTime: info.ModTime(),
 
-			Email:      email,
-			Authorized: true,
+			Email:        email,
+			Authorized:   true,
+			AuthorizedAt: info.ModTime(),
 		}
 		value, err := json.Marshal(dbf)
 		if err != nil {
@@ -49,7 +88,29 @@ func walker(b *bolt.Bucket, prefix, email string) filepath.WalkFunc {
 
 func main() {
 	flag.Parse()
-	err := os.Remove(*dbFile)
+	prefix, err := filepath.Abs(filepath.Clean(*baseDir))
+	if err != nil {
+		log.Fatalf("[crit] obtaining absolute path for baseDir %s: %s\n", *baseDir, err)
+	}
+
+	if *backfill {
+		db, err := bolt.Open(*dbFile, 0600, nil)
+		if err != nil {
+			log.Fatalf("[crit] opening database file %s: %s\n", *dbFile, err)
+		}
+		defer db.Close()
+		if err := indexText(db, fs.Dir(prefix)); err != nil {
+			log.Fatalf("[crit] while indexing text: %s\n", err)
+		}
+		return
+	}
+
+	// the database holds much more than the files found on disk,
+	// and taken down files would be published again
+	if _, err := os.Stat(*dbFile); err == nil && !*force {
+		log.Fatalf("[crit] %s exists, rebuilding it loses everything but the files: pass -force to replace it, or -backfill to only index the text of documents\n", *dbFile)
+	}
+	err = os.Remove(*dbFile)
 	if err != nil && !os.IsNotExist(err) {
 		log.Fatalf("[crit] removing %s: %s\n", *dbFile, err)
 	}
@@ -58,17 +119,15 @@ func main() {
 		log.Fatalf("[crit] opening database file %s: %s\n", *dbFile, err)
 	}
 	defer db.Close()
-	prefix, err := filepath.Abs(filepath.Clean(*baseDir))
-	if err != nil {
-		log.Fatalf("[crit] obtaining absolute path for baseDir %s: %s\n", *baseDir, err)
-	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucket(fs.FilesBucket)
-		if err != nil {
+		if _, err := tx.CreateBucket(fs.FilesBucket); err != nil {
 			return err
 		}
-		walkFn := walker(bucket, prefix, *email)
+		if _, err := tx.CreateBucket(fs.DirectoriesBucket); err != nil {
+			return err
+		}
+		walkFn := walker(tx, prefix, *email)
 		if *verbose {
 			wf := walkFn
 			walkFn = func(path string, info os.FileInfo, err error) error {
@@ -78,9 +137,27 @@ func main() {
 				return wf(path, info, err)
 			}
 		}
-		return filepath.Walk(prefix, walkFn)
+		if err := filepath.Walk(prefix, walkFn); err != nil {
+			return err
+		}
+		if *verbose {
+			log.Println("[info] building file name index")
+		}
+		if err := search.RebuildNames(tx); err != nil {
+			return err
+		}
+		if *verbose {
+			log.Println("[info] building time index")
+		}
+		return recent.Rebuild(tx)
 	})
 	if err != nil {
 		log.Fatalf("[crit] while building index: %s\n", err)
 	}
+
+	if *fullText {
+		if err := indexText(db, fs.Dir(prefix)); err != nil {
+			log.Fatalf("[crit] while indexing text: %s\n", err)
+		}
+	}
 }
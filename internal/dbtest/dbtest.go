@@ -0,0 +1,30 @@
+// Package dbtest opens throwaway databases for the tests of the other packages
+package dbtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Open creates an empty database in a temporary directory,
+// the returned function closes it and removes the directory
+func Open(t testing.TB) (*bolt.DB, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "db.bolt"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -9,26 +9,51 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/mail"
+	"sync"
 	"text/template"
+	"time"
 )
 
 const (
 	mailgunApiDomain = "https://api.mailgun.net/v3"
+	// maxFailures is the number of failed emails remembered
+	maxFailures = 100
 )
 
-var (
-	emailTemplate = template.Must(template.New("email").Parse(`
+// confirmTemplate is the text of every email asking to visit a link
+var confirmTemplate = template.Must(template.New("confirm").Parse(`
   Hi {{ .Email }},
-  You uploaded {{ .Filename }} on {{ .Domain }}.
-  To confirm the upload please visit the following link
-  https://{{ .Domain }}/confirm/{{ .Token }}
-
-  If you didn't upload files on {{ .Domain }} please ignore this email.
-
+  {{ .Done }}
+  To {{ .Purpose }} please visit the following link
+  https://{{ .Domain }}{{ .Link }}
+{{ with .Unless }}
+  If you didn't {{ . }} on {{ $.Domain }} please ignore this email.
+{{ end }}
   Best regards,
   The team at {{ .Domain }}
   `))
-)
+
+// confirmation fills confirmTemplate
+type confirmation struct {
+	Domain string
+	Email  string
+	// Done tells the recipient why they got the email
+	Done string
+	// Purpose is what visiting Link does
+	Purpose string
+	// Link is the escaped path of the page to visit
+	Link string
+	// Unless completes "If you didn't ...", the sentence is left out if empty
+	Unless string
+}
+
+// A Failure is an email which could not be sent
+type Failure struct {
+	To      string
+	Subject string
+	Err     string
+	At      time.Time
+}
 
 type M struct {
 	domain  string
@@ -38,6 +63,9 @@ type M struct {
 
 	endpoint string
 	c        *http.Client
+
+	mu       sync.Mutex
+	failures []Failure
 }
 
 func New(domain, sender, apiKey string) (*M, error) {
@@ -60,29 +88,153 @@ func New(domain, sender, apiKey string) (*M, error) {
 	}, nil
 }
 
+// SetEndpoint makes m send its emails to the mailgun compatible api at endpoint,
+// such as a local server in tests
+func (m *M) SetEndpoint(endpoint string) {
+	m.endpoint = endpoint
+}
+
 func (m *M) ConfirmUpload(to, filename, token string) error {
+	return m.confirm(to, "confirm upload of "+filename, confirmation{
+		Done:    "You uploaded " + filename + " on " + m.domain + ".",
+		Purpose: "confirm the upload",
+		Link:    "/confirm/" + token,
+		Unless:  "upload files",
+	})
+}
+
+// ConfirmEdit asks to confirm an edit of what, link is the escaped path
+// of the page confirming the edit
+func (m *M) ConfirmEdit(to, what, link string) error {
+	return m.confirm(to, "confirm your edit of "+what, confirmation{
+		Done:    "You edited " + what + " on " + m.domain + ".",
+		Purpose: "publish your changes",
+		Link:    link,
+		Unless:  "edit anything",
+	})
+}
+
+// ConfirmVote asks to confirm the rating of filename, link is the escaped path
+// of the page confirming the vote
+func (m *M) ConfirmVote(to, filename, link string) error {
+	return m.confirm(to, "confirm your rating of "+filename, confirmation{
+		Done:    "You rated " + filename + " on " + m.domain + ".",
+		Purpose: "count your vote",
+		Link:    link,
+		Unless:  "rate files",
+	})
+}
+
+// ConfirmComment asks to confirm a comment on filename, link is the escaped path
+// of the page publishing the comment
+func (m *M) ConfirmComment(to, filename, link string) error {
+	return m.confirm(to, "confirm your comment on "+filename, confirmation{
+		Done:    "You commented on " + filename + " at " + m.domain + ".",
+		Purpose: "publish your comment",
+		Link:    link,
+		Unless:  "comment on files",
+	})
+}
+
+// ConfirmReport asks to confirm the report of filename, link is the escaped path
+// of the page confirming the report
+func (m *M) ConfirmReport(to, filename, link string) error {
+	return m.confirm(to, "confirm your report of "+filename, confirmation{
+		Done:    "You reported " + filename + " on " + m.domain + ".",
+		Purpose: "send your report to the admins",
+		Link:    link,
+		Unless:  "report files",
+	})
+}
+
+// ConfirmNotice asks to confirm a takedown notice or counter-notice described by what,
+// link is the escaped path of the page confirming it
+func (m *M) ConfirmNotice(to, what, link string) error {
+	return m.confirm(to, "confirm your "+what, confirmation{
+		Done:    "You sent a " + what + " to " + m.domain + ".",
+		Purpose: "confirm it",
+		Link:    link,
+		Unless:  "send anything",
+	})
+}
+
+// NotifyTakedown tells the uploader of filename that it has been taken down after a
+// copyright notice about work, link is the escaped path of the counter-notice form
+func (m *M) NotifyTakedown(to, filename, work, link string) error {
+	return m.confirm(to, filename+" has been taken down", confirmation{
+		Done:    "Your file " + filename + " on " + m.domain + " has been taken down after a copyright notice about " + work + ".",
+		Purpose: "send a counter-notice if it was taken down by mistake",
+		Link:    link,
+	})
+}
+
+// ConfirmLogin sends the link signing in to the admin area,
+// link is the escaped path of the page starting the session
+func (m *M) ConfirmLogin(to, link string) error {
+	return m.confirm(to, "sign in to "+m.domain, confirmation{
+		Done:    "Someone asked to sign in to the admin area of " + m.domain + " with your address, the link expires in one hour.",
+		Purpose: "sign in",
+		Link:    link,
+		Unless:  "try to sign in",
+	})
+}
+
+// NotifyModeration tells a moderator that there are waiting new uploads to approve,
+// link is the escaped path of the moderation queue
+func (m *M) NotifyModeration(to string, waiting int, link string) error {
+	return m.confirm(to, fmt.Sprintf("%d uploads wait for approval", waiting), confirmation{
+		Done:    fmt.Sprintf("%d more uploads on %s wait for your approval.", waiting, m.domain),
+		Purpose: "review them",
+		Link:    link,
+	})
+}
+
+// confirm sends c to the address to
+func (m *M) confirm(to, subject string, c confirmation) error {
+	c.Domain, c.Email = m.domain, to
+	return m.send(to, subject, confirmTemplate, c)
+}
+
+// Failures returns the last emails which could not be sent, newest first
+func (m *M) Failures() []Failure {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Failure, len(m.failures))
+	for i, f := range m.failures {
+		list[len(list)-1-i] = f
+	}
+	return list
+}
+
+// send sends an email with the text produced by executing t with data,
+// remembering it among the failures if it cannot be sent
+func (m *M) send(to, subject string, t *template.Template, data interface{}) error {
+	err := m.deliver(to, subject, t, data)
+	if err != nil {
+		m.mu.Lock()
+		if len(m.failures) == maxFailures {
+			m.failures = m.failures[1:]
+		}
+		m.failures = append(m.failures, Failure{To: to, Subject: subject, Err: err.Error(), At: time.Now()})
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// deliver sends an email through the mailgun api
+func (m *M) deliver(to, subject string, t *template.Template, data interface{}) error {
 	var (
 		b  = new(bytes.Buffer)
 		mw = multipart.NewWriter(b)
 	)
 	mw.WriteField("from", m.from)
 	mw.WriteField("to", to)
-	mw.WriteField("subject", "confirm upload of "+filename)
+	mw.WriteField("subject", subject)
 	fw, err := mw.CreateFormField("text")
 	if err != nil {
 		return err
 	}
-	err = emailTemplate.Execute(fw, struct {
-		Domain   string
-		Email    string
-		Filename string
-		Token    string
-	}{
-		Domain:   m.domain,
-		Email:    to,
-		Filename: filename,
-		Token:    token,
-	})
+	err = t.Execute(fw, data)
 	if err != nil {
 		return err
 	}
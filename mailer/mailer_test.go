@@ -14,10 +14,6 @@ const (
 	apiKey = "test-api-key"
 )
 
-func SetMailgunEndpoint(m *M, endpoint string) {
-	m.endpoint = endpoint
-}
-
 func TestNew(t *testing.T) {
 	_, err := New(domain, sender, "")
 	if err == nil {
@@ -52,12 +48,15 @@ func TestConfirmUpload(t *testing.T) {
 	defer s.Close()
 
 	m, _ := New(domain, sender, apiKey)
-	SetMailgunEndpoint(m, s.URL)
+	m.SetEndpoint(s.URL)
 	statuses <- http.StatusBadRequest
 	if err := m.ConfirmUpload("test2@example.com", "testfile", "testtoken"); err == nil {
 		t.Error("expected ConfirmUpload to return error")
 	}
 	<-reqCh // discard request, we don't need to look at it
+	if f := m.Failures(); len(f) != 1 || f[0].To != "test2@example.com" || f[0].Subject != "confirm upload of testfile" {
+		t.Errorf("expected the failed email to be remembered, got %v", f)
+	}
 
 	statuses <- http.StatusOK
 	err := m.ConfirmUpload("test2@example.com", "testfile", "testtoken")
@@ -87,3 +86,62 @@ func TestConfirmUpload(t *testing.T) {
 		t.Error("email does not contain confirmation link")
 	}
 }
+
+func TestConfirmEdit(t *testing.T) {
+	reqCh := make(chan *http.Request, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseMultipartForm(1 * 1 << 10) // 1kb
+		reqCh <- req
+	}))
+	defer s.Close()
+
+	m, _ := New(domain, sender, apiKey)
+	m.SetEndpoint(s.URL)
+	if err := m.ConfirmEdit("test2@example.com", "the description of /Analisi/", "/describe/Analisi/?token=testtoken"); err != nil {
+		t.Fatalf("expected ConfirmEdit not to return errors, got %s", err)
+	}
+	req := <-reqCh
+	expected(t, "subject", "confirm your edit of the description of /Analisi/", req.FormValue("subject"))
+	link := fmt.Sprintf("https://%s/describe/Analisi/?token=testtoken", domain)
+	if text := req.FormValue("text"); !strings.Contains(text, link) {
+		t.Errorf("email does not contain the link %s:\n%s", link, text)
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	reqCh := make(chan *http.Request, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseMultipartForm(1 * 1 << 10) // 1kb
+		reqCh <- req
+	}))
+	defer s.Close()
+
+	m, _ := New(domain, sender, apiKey)
+	m.SetEndpoint(s.URL)
+	for _, c := range []struct {
+		send    func() error
+		subject string
+		ignore  bool
+	}{
+		{func() error { return m.ConfirmVote("a@example.com", "a.pdf", "/l") }, "confirm your rating of a.pdf", true},
+		{func() error { return m.ConfirmComment("a@example.com", "a.pdf", "/l") }, "confirm your comment on a.pdf", true},
+		{func() error { return m.ConfirmReport("a@example.com", "a.pdf", "/l") }, "confirm your report of a.pdf", true},
+		{func() error { return m.ConfirmNotice("a@example.com", "takedown notice", "/l") }, "confirm your takedown notice", true},
+		{func() error { return m.ConfirmLogin("a@example.com", "/l") }, "sign in to " + domain, true},
+		{func() error { return m.NotifyModeration("a@example.com", 3, "/l") }, "3 uploads wait for approval", false},
+		{func() error { return m.NotifyTakedown("a@example.com", "a.pdf", "a book", "/l") }, "a.pdf has been taken down", false},
+	} {
+		if err := c.send(); err != nil {
+			t.Fatalf("expected %q not to return errors, got %s", c.subject, err)
+		}
+		req := <-reqCh
+		expected(t, "subject", c.subject, req.FormValue("subject"))
+		text := req.FormValue("text")
+		if !strings.Contains(text, "https://"+domain+"/l\n") {
+			t.Errorf("email %q does not contain the link:\n%s", c.subject, text)
+		}
+		if strings.Contains(text, "please ignore this email") != c.ignore {
+			t.Errorf("email %q has the wrong closing:\n%s", c.subject, text)
+		}
+	}
+}
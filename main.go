@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/socialnotes/mirror/fs"
 	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/pow"
+	"github.com/socialnotes/mirror/ratelimit"
+	"github.com/socialnotes/mirror/search"
+	"github.com/socialnotes/mirror/stats"
+	"github.com/socialnotes/mirror/thumbs"
 	"github.com/socialnotes/mirror/views"
 )
 
 var (
 	addr = flag.String("addr", ":8080", "bind to <address:port>")
 
+	siteURL     = flag.String("site-url", "https://socialnotes.eu", "public url of the website, used for absolute links")
 	baseDir     = flag.String("base-dir", ".", "directory where files will be hosted, must be an absolute path")
 	dbFile      = flag.String("db-file", "db.bolt", "bolt database file")
 	templateDir = flag.String("template-dir", "templates/", "directory containing templates")
@@ -21,8 +34,54 @@ var (
 	mailgunDomain = flag.String("mailgun-domain", "socialnotes.eu", "mailgun domain to send emails from")
 	mailgunSender = flag.String("mailgun-sender", "SocialNotes <files@socialnotes.eu>", "name of the email address that will be used to send emails")
 	mailgunAPIKey = flag.String("mailgun-api-key", "", "mailgun api key")
+
+	zipMaxSize  = flag.Int64("zip-max-size", 2<<30, "maximum size in bytes of a directory downloaded as zip")
+	zipMaxFiles = flag.Int("zip-max-files", 5000, "maximum number of files in a directory downloaded as zip")
+
+	thumbDir     = flag.String("thumb-dir", "", "directory where thumbnails are cached, defaults to <base-dir>.thumbs")
+	thumbWorkers = flag.Int("thumb-workers", 2, "number of thumbnails generated concurrently")
+
+	extractQueue = flag.Int("extract-queue", 1000, "number of documents waiting for text extraction")
+
+	reportThreshold = flag.Int("report-threshold", 3, "number of distinct reports from unitn addresses which hide a file until an admin reviews it, 0 never hides files")
+
+	admins = flag.String("admins", "", "comma separated emails of the admins, who can edit every directory description and file metadata and sign in to /admin/")
+
+	moderation = flag.Bool("moderation", false, "publish the confirmed uploads only after a moderator or an admin approves them")
+	moderators = flag.String("moderators", "", "comma separated emails of the moderators, who approve the uploads from /admin/moderation")
+
+	trustedProxies = flag.String("trusted-proxies", "", "comma separated addresses or networks of the reverse proxies whose X-Forwarded-For header is trusted, e.g. 127.0.0.1,10.0.0.0/8")
+	limitIP        = flag.String("limit-ip", "10/1h", "requests sending an email allowed to each client address, as <requests>/<duration>, 0 disables the limit")
+	limitEmail     = flag.String("limit-email", "5/1h", "requests sending an email allowed for each email address, as <requests>/<duration>, 0 disables the limit")
+	limitGlobal    = flag.String("limit-global", "200/1h", "requests sending an email allowed overall, as <requests>/<duration>, 0 disables the limit")
+
+	powDifficulty = flag.Int("pow-difficulty", 16, "leading zero bits of the proof-of-work challenges solved by the upload form, 0 disables them")
+	powScale      = flag.Int("pow-scale", 30, "uploads in ten minutes after which the challenges get harder, doubling the work each time the rate doubles; 0 keeps them constant")
+
+	statsFlush      = flag.Duration("stats-flush", time.Minute, "interval between writes of download counts to the database")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "time given to the requests in flight to complete on shutdown")
 )
 
+// splitList splits a comma separated list, dropping empty elements
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// parseLimit reads the rate limit of flag name
+func parseLimit(name, s string) ratelimit.Limit {
+	l, err := ratelimit.Parse(s)
+	if err != nil {
+		log.Fatalf("[crit] parsing %s: %s\n", name, err)
+	}
+	return l
+}
+
 func main() {
 	flag.Parse()
 	ts, err := views.NewTemplates(*templateDir, "*.html")
@@ -38,20 +97,139 @@ func main() {
 	if err != nil {
 		log.Fatalf("[crit] checking database %s: %s\n", *dbFile, err)
 	}
+	err = views.PrepareDatabase(db)
+	if err != nil {
+		log.Fatalf("[crit] preparing database %s: %s\n", *dbFile, err)
+	}
 
 	m, err := mailer.New(*mailgunDomain, *mailgunSender, *mailgunAPIKey)
 	if err != nil {
 		log.Fatalf("[crit] initializing mailer: %s\n", err)
 	}
 
+	proxies, err := views.ParseNetworks(*trustedProxies)
+	if err != nil {
+		log.Fatalf("[crit] parsing trusted-proxies: %s\n", err)
+	}
+	var issuer *pow.Issuer
+	if *powDifficulty > 0 {
+		if issuer, err = pow.NewIssuer(*powDifficulty, *powScale); err != nil {
+			log.Fatalf("[crit] initializing proof-of-work challenges: %s\n", err)
+		}
+	}
+	// the forms send a confirmation email to the address typed in them,
+	// they share the limits so that switching form does not get around them
+	limits := views.NewRateLimits(
+		parseLimit("limit-ip", *limitIP),
+		parseLimit("limit-email", *limitEmail),
+		parseLimit("limit-global", *limitGlobal),
+	)
+
+	if *thumbDir == "" {
+		abs, err := filepath.Abs(*baseDir)
+		if err != nil {
+			log.Fatalf("[crit] obtaining absolute path for base-dir %s: %s\n", *baseDir, err)
+		}
+		*thumbDir = abs + ".thumbs"
+	}
+	tc, err := thumbs.New(db, *thumbDir, *thumbWorkers)
+	if err != nil {
+		log.Fatalf("[crit] initializing thumbnail cache in %s: %s\n", *thumbDir, err)
+	}
+
 	fs := fs.Dir(*baseDir)
-	sh := views.ToHandler(views.NewServerHandler(fs, ts, db), ts)
-	uh := views.ToHandler(views.NewUploadHandler(fs, ts, db, m, "/upload"), ts)
-	ch := views.ToHandler(views.NewConfirmHandler(ts, db, "/confirm"), ts)
+	ex := search.NewExtractor(db, fs, *extractQueue)
+	go ex.Run()
+	counter := stats.NewCounter(db)
+	go counter.Run(*statsFlush)
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := views.PurgeExpired(db); err != nil {
+				log.Printf("[err] removing expired confirmations: %s\n", err)
+			}
+		}
+	}()
+
+	sh := views.ToHandler(views.NewServerHandler(fs, ts, db, counter), ts)
+	// the challenge is verified first, so that unsolved uploads do not use up the limits
+	uh := views.RequireWork(views.Limit(views.ToHandler(views.NewUploadHandler(fs, ts, db, m, *moderation, "/upload"), ts), ts, limits), ts, issuer)
+	// admins approve uploads too, they are notified only if there are no moderators
+	notified := splitList(*moderators)
+	if len(notified) == 0 {
+		notified = splitList(*admins)
+	}
+	ch := views.Limit(views.ToHandler(views.NewConfirmHandler(fs, ts, db, m, ex, notified, "/confirm"), ts), ts, limits)
 	tos := views.ToHandler(views.NewStaticPageHandler(ts, "tos.html"), ts)
+	zh := views.ToHandler(views.NewArchiveHandler(fs, ts, db, "/zip", *zipMaxSize, *zipMaxFiles), ts)
+	ph := views.ToHandler(views.NewPreviewHandler(fs, ts, db, "/preview"), ts)
+	hh := views.ToHandler(views.NewHistoryHandler(fs, ts, db, "/history"), ts)
+	davh := views.ToHandler(views.NewDAVHandler(fs, db, "/dav"), ts)
+	tbh := views.ToHandler(views.NewThumbHandler(fs, db, tc, "/thumb"), ts)
+	fh := views.ToHandler(views.NewFeedHandler(db, *siteURL, "/feed"), ts)
+	dh := views.Limit(views.ToHandler(views.NewDescribeHandler(ts, db, m, splitList(*admins), "/describe"), ts), ts, limits)
+	mh := views.Limit(views.ToHandler(views.NewMetadataHandler(ts, db, m, splitList(*admins), "/metadata"), ts), ts, limits)
+	cmh := views.Limit(views.ToHandler(views.NewCommentsHandler(ts, db, m, splitList(*admins), "/comments"), ts), ts, limits)
+	rph := views.Limit(views.ToHandler(views.NewReportHandler(ts, db, m, splitList(*admins), *reportThreshold, "/report"), ts), ts, limits)
+	adh := views.ToHandler(views.NewAdminHandler(fs, ts, db, m, ex, splitList(*admins), splitList(*moderators), "/admin"), ts)
+	tdh := views.Limit(views.ToHandler(views.NewTakedownHandler(ts, db, m, splitList(*admins), "/takedown"), ts), ts, limits)
+	cnh := views.Limit(views.ToHandler(views.NewCounterNoticeHandler(ts, db, m, splitList(*admins), "/counter-notice"), ts), ts, limits)
+	rth := views.Limit(views.ToHandler(views.NewRateHandler(ts, db, m, "/rate"), ts), ts, limits)
+	toph := views.ToHandler(views.NewTopHandler(ts, db), ts)
+	rh := views.ToHandler(views.NewRecentHandler(ts, db), ts)
+	srh := views.ToHandler(views.NewSearchHandler(ts, db), ts)
+	chh := views.ToJSONHandler(views.NewChallengeHandler(issuer))
+	th := views.ToJSONHandler(views.NewTreeHandler(db, "/api/v1/tree"))
+	rah := views.ToJSONHandler(views.NewRecentAPIHandler(db, "/api/v1/recent"))
 	http.Handle("/", sh)
 	http.Handle("/tos.html", tos)
 	http.Handle("/upload/", uh)
 	http.Handle("/confirm/", ch)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	http.Handle("/challenge", chh)
+	http.Handle("/search/", srh)
+	http.Handle("/describe/", dh)
+	http.Handle("/metadata/", mh)
+	http.Handle("/comments/", cmh)
+	http.Handle("/report/", rph)
+	http.Handle("/admin/", adh)
+	// the sign in is the only form of the admin area open to anyone
+	http.Handle("/admin/login", views.Limit(adh, ts, limits))
+	http.Handle("/takedown/", tdh)
+	http.Handle("/counter-notice/", cnh)
+	http.Handle("/rate/", rth)
+	http.Handle("/top/", toph)
+	http.Handle("/recent/", rh)
+	http.Handle("/feed/", fh)
+	http.Handle("/zip/", zh)
+	http.Handle("/preview/", ph)
+	http.Handle("/thumb/", tbh)
+	http.Handle("/history/", hh)
+	http.Handle("/dav/", davh)
+	http.Handle("/api/v1/tree/", th)
+	http.Handle("/api/v1/recent/", rah)
+
+	srv := &http.Server{Addr: *addr, Handler: views.RealIP(http.DefaultServeMux, proxies)}
+	idle := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Printf("[info] shutting down\n")
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("[err] waiting for the requests in flight: %s\n", err)
+		}
+		close(idle)
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	// nothing writes to the database anymore once the requests and the extractor are done,
+	// the pending download counts are saved before it is closed by the deferred db.Close
+	<-idle
+	ex.Stop()
+	if err := counter.Flush(); err != nil {
+		log.Printf("[err] flushing download counts: %s\n", err)
+	}
 }
@@ -0,0 +1,273 @@
+// Package markdown renders a safe subset of Markdown to HTML.
+//
+// Raw HTML in the source is always escaped and links are restricted to
+// http, https and mailto URLs, so the output can be embedded in pages
+// without further sanitization.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingRe   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	ruleRe      = regexp.MustCompile(`^ {0,3}((-[ ]*){3,}|(\*[ ]*){3,}|(_[ ]*){3,})$`)
+	unorderedRe = regexp.MustCompile(`^ {0,3}[-*+]\s+(.*)$`)
+	orderedRe   = regexp.MustCompile(`^ {0,3}\d{1,9}[.)]\s+(.*)$`)
+	quoteRe     = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	fenceRe     = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+)
+
+// Render converts src to HTML
+func Render(src string) template.HTML {
+	src = strings.Replace(src, "\r\n", "\n", -1)
+	b := new(bytes.Buffer)
+	renderBlocks(b, strings.Split(src, "\n"))
+	return template.HTML(b.String())
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+}
+
+// startsBlock reports whether line interrupts a paragraph
+func startsBlock(line string) bool {
+	return headingRe.MatchString(line) || ruleRe.MatchString(line) ||
+		unorderedRe.MatchString(line) || orderedRe.MatchString(line) ||
+		quoteRe.MatchString(line) || fenceRe.MatchString(line)
+}
+
+func renderBlocks(b *bytes.Buffer, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case fenceRe.MatchString(line):
+			fence := fenceRe.FindStringSubmatch(line)[1]
+			i++
+			code := make([]string, 0)
+			for ; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+
+		case isIndented(line):
+			code := make([]string, 0)
+			for ; i < len(lines) && (isIndented(lines[i]) || isBlank(lines[i])); i++ {
+				l := strings.TrimPrefix(lines[i], "\t")
+				if len(l) == len(lines[i]) {
+					l = strings.TrimPrefix(l, "    ")
+				}
+				code = append(code, l)
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.TrimRight(strings.Join(code, "\n"), "\n")))
+			b.WriteString("</code></pre>\n")
+
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			level := string('0' + byte(len(m[1])))
+			b.WriteString("<h" + level + ">")
+			renderInline(b, m[2])
+			b.WriteString("</h" + level + ">\n")
+			i++
+
+		case ruleRe.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case quoteRe.MatchString(line):
+			quoted := make([]string, 0)
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteRe.FindStringSubmatch(lines[i])[1])
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+
+		case unorderedRe.MatchString(line), orderedRe.MatchString(line):
+			re, tag := unorderedRe, "ul"
+			if orderedRe.MatchString(line) {
+				re, tag = orderedRe, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for i < len(lines) && re.MatchString(lines[i]) {
+				item := []string{re.FindStringSubmatch(lines[i])[1]}
+				// continuation lines belong to the item
+				for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+					item = append(item, strings.TrimSpace(lines[i]))
+				}
+				b.WriteString("<li>")
+				renderInline(b, strings.Join(item, "\n"))
+				b.WriteString("</li>\n")
+				// a single blank line between items does not end the list
+				if i+1 < len(lines) && isBlank(lines[i]) && re.MatchString(lines[i+1]) {
+					i++
+				}
+			}
+			b.WriteString("</" + tag + ">\n")
+
+		default:
+			para := []string{strings.TrimSpace(line)}
+			for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			b.WriteString("<p>")
+			renderInline(b, strings.Join(para, "\n"))
+			b.WriteString("</p>\n")
+		}
+	}
+}
+
+// safeURL returns the escaped url if its scheme is allowed, "#" otherwise
+func safeURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "#"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return html.EscapeString(u.String())
+	}
+	return "#"
+}
+
+// link parses "[text](url)" at the start of s, returning the text,
+// the url and the length of the link, or ok=false if s does not start with a link
+func link(s string) (text, href string, n int, ok bool) {
+	if !strings.HasPrefix(s, "[") {
+		return "", "", 0, false
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(s) || s[i+1] != '(' {
+				return "", "", 0, false
+			}
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				return "", "", 0, false
+			}
+			href = s[i+2 : i+2+end]
+			// drop an optional title
+			if sp := strings.IndexAny(href, " \t"); sp >= 0 {
+				href = href[:sp]
+			}
+			return s[1:i], href, i + 3 + end, true
+		case '\n':
+			return "", "", 0, false
+		}
+	}
+	return "", "", 0, false
+}
+
+func renderInline(b *bytes.Buffer, s string) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!>", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			ticks := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			delim := s[i : i+ticks]
+			if end := strings.Index(s[i+ticks:], delim); end >= 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(strings.TrimSpace(s[i+ticks : i+ticks+end])))
+				b.WriteString("</code>")
+				i += 2*ticks + end
+				continue
+			}
+			b.WriteString(delim)
+			i += ticks
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			// images are shown as links so that pages never load external content
+			if text, href, n, ok := link(s[i+1:]); ok {
+				b.WriteString(`<a href="` + safeURL(href) + `" rel="nofollow noopener">`)
+				b.WriteString(html.EscapeString(text))
+				b.WriteString("</a>")
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if text, href, n, ok := link(s[i:]); ok {
+				b.WriteString(`<a href="` + safeURL(href) + `" rel="nofollow noopener">`)
+				renderInline(b, text)
+				b.WriteString("</a>")
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(s[i:], '>'); end > 0 {
+				inner := s[i+1 : i+end]
+				if strings.HasPrefix(inner, "http://") || strings.HasPrefix(inner, "https://") {
+					b.WriteString(`<a href="` + safeURL(inner) + `" rel="nofollow noopener">`)
+					b.WriteString(html.EscapeString(inner))
+					b.WriteString("</a>")
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == '*' || c == '_' && (i == 0 || !isAlnum(s[i-1])):
+			delim := string(c)
+			tag := "em"
+			if strings.HasPrefix(s[i:], delim+delim) {
+				delim += delim
+				tag = "strong"
+			}
+			rest := s[i+len(delim):]
+			end := strings.Index(rest, delim)
+			if end > 0 && rest[0] != ' ' && rest[end-1] != ' ' &&
+				(c == '*' || i+len(delim)+end+len(delim) >= len(s) || !isAlnum(s[i+len(delim)+end+len(delim)])) {
+				b.WriteString("<" + tag + ">")
+				renderInline(b, rest[:end])
+				b.WriteString("</" + tag + ">")
+				i += 2*len(delim) + end
+				continue
+			}
+			b.WriteString(delim)
+			i += len(delim)
+			continue
+
+		case c == '\n':
+			// two trailing spaces make a hard line break
+			if i >= 2 && s[i-2:i] == "  " {
+				b.WriteString("<br>")
+			}
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
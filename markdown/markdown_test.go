@@ -0,0 +1,20 @@
+package markdown
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	for _, c := range []struct{ src, expected string }{
+		{"# Analisi 1\n", "<h1>Analisi 1</h1>\n"},
+		{"Prof. **Rossi**, *a.a.* 2019/20\nsecond line", "<p>Prof. <strong>Rossi</strong>, <em>a.a.</em> 2019/20\nsecond line</p>\n"},
+		{"- esami\n- appunti_vecchi\n\n1. uno", "<ul>\n<li>esami</li>\n<li>appunti_vecchi</li>\n</ul>\n<ol>\n<li>uno</li>\n</ol>\n"},
+		{"```\n<b>x</b>\n```", "<pre><code>&lt;b&gt;x&lt;/b&gt;</code></pre>\n"},
+		{"> quote `a<b`", "<blockquote>\n<p>quote <code>a&lt;b</code></p>\n</blockquote>\n"},
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"[site](https://socialnotes.eu) [x](javascript:alert(1))", `<p><a href="https://socialnotes.eu" rel="nofollow noopener">site</a> <a href="#" rel="nofollow noopener">x</a>)</p>` + "\n"},
+		{`[a"b](http://x/"onmouseover=")`, `<p><a href="http://x/%22onmouseover=%22" rel="nofollow noopener">a&#34;b</a></p>` + "\n"},
+	} {
+		if got := string(Render(c.src)); got != c.expected {
+			t.Errorf("rendering %q: expected %q, got %q", c.src, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Command mirrorctl inspects and changes the files of a mirror database,
+// it must run while the server is stopped
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/bans"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/thumbs"
+	"github.com/socialnotes/mirror/views"
+)
+
+var (
+	baseDir  = flag.String("base-dir", ".", "directory where files are hosted, needed by delete and move")
+	dbFile   = flag.String("db-file", "db.bolt", "bolt database file")
+	timeout  = flag.Duration("timeout", time.Second, "time to wait for the database to be unlocked")
+	thumbDir = flag.String("thumb-dir", "", "directory where thumbnails are cached, defaults to <base-dir>.thumbs")
+)
+
+const usage = `Usage: mirrorctl [flags] <command> [arguments]
+
+Commands:
+  list [prefix]           list the files whose path starts with prefix
+  grep <regexp>           list the files whose path or uploader email match regexp
+  authorize <path>...     publish files as if their uploader confirmed them
+  revoke <path>...        unpublish files until they are authorized again
+  delete <path>...        remove files from disk and from the database
+  move <from> <to>        move or rename a file, the directory of to must exist
+  email <path> <email>    change the uploader of a file
+  dump <path>             print the record of a file as JSON
+  bans                    list the banned addresses
+  ban <pattern> <reason> [until]
+                          stop the addresses matching pattern from uploading files, until
+                          the end of the day until in the form 2006-01-02 if given
+  unban <pattern>         remove a ban
+  purge <pattern> <hide|delete>
+                          hide or delete the files uploaded by the addresses matching pattern
+
+Flags:
+`
+
+// command runs a subcommand on db with the given arguments
+type command struct {
+	// min and max bound the number of arguments, max is -1 if there is no limit
+	min, max int
+	readOnly bool
+	run      func(db *bolt.DB, args []string) error
+}
+
+var commands = map[string]command{
+	"list":      {min: 0, max: 1, readOnly: true, run: list},
+	"grep":      {min: 1, max: 1, readOnly: true, run: grep},
+	"authorize": {min: 1, max: -1, run: authorize},
+	"revoke":    {min: 1, max: -1, run: revoke},
+	"delete":    {min: 1, max: -1, run: remove},
+	"move":      {min: 2, max: 2, run: move},
+	"email":     {min: 2, max: 2, run: email},
+	"dump":      {min: 1, max: 1, readOnly: true, run: dump},
+	"bans":      {min: 0, max: 0, readOnly: true, run: listBans},
+	"ban":       {min: 2, max: 3, run: ban},
+	"unban":     {min: 1, max: 1, run: unban},
+	"purge":     {min: 2, max: 2, run: purge},
+}
+
+// state describes whether dbf is shown to visitors
+func state(dbf fs.DBFile) string {
+	switch {
+	case !dbf.Authorized:
+		return "pending"
+	case dbf.AwaitingApproval:
+		return "awaiting-approval"
+	case dbf.Takedown != nil:
+		return "taken-down"
+	case dbf.Flagged:
+		return "hidden"
+	}
+	return "published"
+}
+
+// printFiles lists the files accepted by match, one per line
+func printFiles(db *bolt.DB, prefix string, match func(path string, dbf fs.DBFile) bool) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(fs.FilesBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			dbf := fs.DBFile{}
+			if err := json.Unmarshal(v, &dbf); err != nil {
+				return fmt.Errorf("decoding %s: %s", k, err)
+			}
+			if !match(string(k), dbf) {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", state(dbf), dbf.Size, dbf.ModTime.Format("2006-01-02"), dbf.Email, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+func list(db *bolt.DB, args []string) error {
+	prefix := "/"
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	return printFiles(db, prefix, func(string, fs.DBFile) bool { return true })
+}
+
+func grep(db *bolt.DB, args []string) error {
+	re, err := regexp.Compile(args[0])
+	if err != nil {
+		return err
+	}
+	return printFiles(db, "", func(path string, dbf fs.DBFile) bool {
+		return re.MatchString(path) || re.MatchString(dbf.Email)
+	})
+}
+
+// actor identifies the changes made by mirrorctl in the audit log
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return "mirrorctl (" + u.Username + ")"
+	}
+	return "mirrorctl"
+}
+
+// eachPath applies fn to every path in its own transaction, recording action
+// in the audit log and stopping at the first error
+func eachPath(db *bolt.DB, paths []string, verb, action string, fn func(tx *bolt.Tx, files *views.Files, name string) error) error {
+	for _, p := range paths {
+		name := path.Clean("/" + p)
+		e := audit.Entry{Action: action, Actor: actor(), Path: name}
+		err := views.UpdateFiles(db, fs.Dir(*baseDir), func(tx *bolt.Tx, files *views.Files) error {
+			return audit.Change(tx, e, func() error { return fn(tx, files, name) })
+		})
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", verb, name, err)
+		}
+		log.Printf("[info] %s %s\n", verb, name)
+	}
+	return nil
+}
+
+func authorize(db *bolt.DB, args []string) error {
+	// the text of the documents is extracted by indexer -backfill
+	return eachPath(db, args, "authorized", audit.Authorize, func(tx *bolt.Tx, _ *views.Files, name string) error {
+		_, err := views.AuthorizeFile(tx, name)
+		return err
+	})
+}
+
+func revoke(db *bolt.DB, args []string) error {
+	return eachPath(db, args, "revoked", audit.Revoke, func(tx *bolt.Tx, _ *views.Files, name string) error {
+		_, err := views.RevokeFile(tx, name)
+		return err
+	})
+}
+
+func remove(db *bolt.DB, args []string) error {
+	return eachPath(db, args, "deleted", audit.Delete, func(tx *bolt.Tx, files *views.Files, name string) error {
+		return views.DeleteFile(tx, files, name)
+	})
+}
+
+func move(db *bolt.DB, args []string) error {
+	from, to := path.Clean("/"+args[0]), path.Clean("/"+args[1])
+	e := audit.Entry{Action: audit.Move, Actor: actor(), Path: from, To: to}
+	err := views.UpdateFiles(db, fs.Dir(*baseDir), func(tx *bolt.Tx, files *views.Files) error {
+		return audit.Change(tx, e, func() error {
+			return views.MoveFile(tx, files, from, to)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("moving %s to %s: %s", from, to, err)
+	}
+	log.Printf("[info] moved %s to %s\n", from, to)
+	return nil
+}
+
+func email(db *bolt.DB, args []string) error {
+	ma, err := mail.ParseAddress(args[1])
+	if err != nil {
+		return fmt.Errorf("%s is not a valid email: %s", args[1], err)
+	}
+	address := strings.ToLower(ma.Address)
+	return eachPath(db, args[:1], "changed the uploader to "+address+" of", audit.Uploader, func(tx *bolt.Tx, _ *views.Files, name string) error {
+		_, err := views.SetUploader(tx, name, address)
+		return err
+	})
+}
+
+func dump(db *bolt.DB, args []string) error {
+	name := path.Clean("/" + args[0])
+	return db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+		if v == nil {
+			return fmt.Errorf("%s: no such file", name)
+		}
+		b := new(bytes.Buffer)
+		if err := json.Indent(b, v, "", "  "); err != nil {
+			return err
+		}
+		b.WriteByte('\n')
+		_, err := b.WriteTo(os.Stdout)
+		return err
+	})
+}
+
+func listBans(db *bolt.DB, args []string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		list, err := bans.List(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, b := range list {
+			expires := "never"
+			if !b.Expires.IsZero() {
+				expires = b.Expires.Format("2006-01-02 15:04")
+				if !b.Active(now) {
+					expires += " (expired)"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", b.Pattern, expires, b.CreatedBy, b.Reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+func ban(db *bolt.DB, args []string) error {
+	pattern, err := bans.Normalize(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+	b := bans.Ban{Pattern: pattern, Reason: args[1], CreatedBy: actor(), CreatedAt: time.Now()}
+	if len(args) > 2 {
+		t, err := time.ParseInLocation("2006-01-02", args[2], time.Local)
+		if err != nil {
+			return fmt.Errorf("%s is not a date in the form 2006-01-02", args[2])
+		}
+		b.Expires = t.AddDate(0, 0, 1)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		e := audit.Entry{Action: audit.Ban, Actor: actor(), Details: b.String()}
+		return audit.ChangeBan(tx, e, pattern, func() error { return bans.Put(tx, b) })
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("[info] banned %s\n", pattern)
+	return nil
+}
+
+func unban(db *bolt.DB, args []string) error {
+	pattern, err := bans.Normalize(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+	found := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, ok, err := bans.Get(tx, pattern)
+		if err != nil || !ok {
+			return err
+		}
+		e := audit.Entry{Action: audit.Unban, Actor: actor(), Details: b.String()}
+		return audit.ChangeBan(tx, e, pattern, func() error {
+			found, err = bans.Delete(tx, pattern)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s is not banned", pattern)
+	}
+	log.Printf("[info] removed the ban of %s\n", pattern)
+	return nil
+}
+
+func purge(db *bolt.DB, args []string) error {
+	pattern, err := bans.Normalize(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+	action := args[1]
+	if action != audit.Hide && action != audit.Delete {
+		return fmt.Errorf("%s is neither hide nor delete", action)
+	}
+	var paths []string
+	err = views.UpdateFiles(db, fs.Dir(*baseDir), func(tx *bolt.Tx, files *views.Files) error {
+		var err error
+		paths, err = views.PurgeUploads(tx, files, bans.Ban{Pattern: pattern}, action, audit.Entry{Actor: actor()})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		log.Printf("[info] %s %s\n", map[string]string{audit.Hide: "hid", audit.Delete: "deleted"}[action], p)
+	}
+	return nil
+}
+
+// openDatabase opens and checks the database in file, waiting at most timeout for the lock
+func openDatabase(file string, readOnly bool) (*bolt.DB, error) {
+	// bolt creates missing files, which would hide a mistyped path
+	if _, err := os.Stat(file); err != nil {
+		return nil, fmt.Errorf("opening database file %s: %s", file, err)
+	}
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: *timeout, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("database file %s is locked by another process, stop the server before running mirrorctl", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening database file %s: %s", file, err)
+	}
+	if err := views.CheckDatabase(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("checking database %s: %s", file, err)
+	}
+	return db, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	args := flag.Args()[1:]
+	if !ok || len(args) < cmd.min || (cmd.max >= 0 && len(args) > cmd.max) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDatabase(*dbFile, cmd.readOnly)
+	if err != nil {
+		log.Fatalf("[crit] %s\n", err)
+	}
+	defer db.Close()
+	if !cmd.readOnly {
+		// the thumbnails of the files deleted or moved are removed with them
+		if *thumbDir == "" {
+			abs, err := filepath.Abs(*baseDir)
+			if err != nil {
+				log.Fatalf("[crit] obtaining absolute path for base-dir %s: %s\n", *baseDir, err)
+			}
+			*thumbDir = abs + ".thumbs"
+		}
+		if _, err := thumbs.New(db, *thumbDir, 1); err != nil {
+			log.Fatalf("[crit] initializing thumbnail cache in %s: %s\n", *thumbDir, err)
+		}
+	}
+
+	if err := cmd.run(db, args); err != nil {
+		db.Close()
+		log.Fatalf("[crit] %s\n", err)
+	}
+}
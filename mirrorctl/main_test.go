@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/bans"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/internal/dbtest"
+	"github.com/socialnotes/mirror/views"
+)
+
+// setup creates the published files /A/x.txt and /A/y.txt, uploaded by a@unitn.it,
+// and the empty directory /B/, in a temporary base directory
+func setup(t *testing.T) (*bolt.DB, func()) {
+	db, closeDB := dbtest.Open(t)
+	dir, err := ioutil.TempDir("", "mirrorctl")
+	if err != nil {
+		closeDB()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		closeDB()
+		os.RemoveAll(dir)
+	}
+	*baseDir = dir
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		files, err := tx.CreateBucketIfNotExists(fs.FilesBucket)
+		if err != nil {
+			return err
+		}
+		for _, d := range []string{"/", "/A/", "/B/"} {
+			if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+				return err
+			}
+			if err := fs.PutDirectory(tx, d, fs.DBDirectory{}); err != nil {
+				return err
+			}
+		}
+		for _, p := range []string{"/A/x.txt", "/A/y.txt"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, p), []byte("hi"), 0644); err != nil {
+				return err
+			}
+			v, _ := json.Marshal(fs.DBFile{Name: filepath.Base(p), Size: 2, ModTime: time.Now(), Email: "a@unitn.it", Authorized: true})
+			if err := files.Put([]byte(p), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = views.PrepareDatabase(db)
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return db, cleanup
+}
+
+func TestCommands(t *testing.T) {
+	db, cleanup := setup(t)
+	defer cleanup()
+	file := func(name string) (dbf fs.DBFile, found bool) {
+		db.View(func(tx *bolt.Tx) error {
+			v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+			found = v != nil
+			return json.Unmarshal(v, &dbf)
+		})
+		return dbf, found
+	}
+	onDisk := func(name string) bool {
+		_, err := os.Stat(filepath.Join(*baseDir, name))
+		return err == nil
+	}
+	banned := func() []string {
+		var patterns []string
+		db.View(func(tx *bolt.Tx) error {
+			list, err := bans.List(tx)
+			for _, b := range list {
+				patterns = append(patterns, b.Pattern)
+			}
+			return err
+		})
+		return patterns
+	}
+	logged := func(action, details string) bool {
+		var entries []audit.Entry
+		db.View(func(tx *bolt.Tx) error {
+			var err error
+			entries, err = audit.List(tx, audit.Filter{Action: action}, 0, 10)
+			return err
+		})
+		return len(entries) == 1 && entries[0].Actor == actor() && entries[0].Details == details
+	}
+
+	for _, c := range []struct {
+		name  string
+		args  []string
+		ok    bool
+		check func() bool
+	}{
+		{"list", nil, true, nil},
+		{"grep", []string{`y\.t`}, true, nil},
+		{"grep", []string{`(`}, false, nil},
+		{"revoke", []string{"/A/x.txt"}, true, func() bool {
+			dbf, _ := file("/A/x.txt")
+			return !dbf.Authorized
+		}},
+		{"authorize", []string{"A/x.txt"}, true, func() bool {
+			dbf, _ := file("/A/x.txt")
+			return dbf.Authorized
+		}},
+		{"authorize", []string{"/A/nope.txt"}, false, nil},
+		{"move", []string{"/A/y.txt", "/C/z.txt"}, false, func() bool { return onDisk("/A/y.txt") }},
+		{"move", []string{"/A/y.txt", "/A/x.txt"}, false, func() bool { return onDisk("/A/y.txt") }},
+		{"move", []string{"/A/y.txt", "/B/z.txt"}, true, func() bool {
+			dbf, found := file("/B/z.txt")
+			_, old := file("/A/y.txt")
+			return found && !old && dbf.Name == "z.txt" && onDisk("/B/z.txt") && !onDisk("/A/y.txt")
+		}},
+		{"email", []string{"/B/z.txt", "Foo@Unitn.IT"}, true, func() bool {
+			dbf, _ := file("/B/z.txt")
+			return dbf.Email == "foo@unitn.it"
+		}},
+		{"email", []string{"/B/z.txt", "nope"}, false, nil},
+		{"dump", []string{"/B/z.txt"}, true, nil},
+		{"dump", []string{"/B/nope.txt"}, false, nil},
+		{"delete", []string{"/A/x.txt"}, true, func() bool {
+			_, found := file("/A/x.txt")
+			return !found && !onDisk("/A/x.txt")
+		}},
+		{"delete", []string{"/A/x.txt"}, false, nil},
+		{"ban", []string{"*@Unitn.it", "junk", "2999-01-01"}, true, func() bool {
+			return strings.Join(banned(), " ") == "*@unitn.it" && logged(audit.Ban, "*@unitn.it, expires 2999-01-02 00:00: junk")
+		}},
+		{"ban", []string{"nope", "junk"}, false, nil},
+		{"ban", []string{"b@unitn.it", "junk", "tomorrow"}, false, nil},
+		{"bans", nil, true, nil},
+		{"purge", []string{"foo@unitn.it", "burn"}, false, nil},
+		{"purge", []string{"foo@unitn.it", "hide"}, true, func() bool {
+			dbf, _ := file("/B/z.txt")
+			return dbf.Flagged && onDisk("/B/z.txt")
+		}},
+		{"purge", []string{"foo@*", "delete"}, true, func() bool {
+			_, found := file("/B/z.txt")
+			return !found && !onDisk("/B/z.txt")
+		}},
+		{"unban", []string{"*@unitn.it"}, true, func() bool {
+			return len(banned()) == 0 && logged(audit.Unban, "*@unitn.it, expires 2999-01-02 00:00: junk")
+		}},
+		{"unban", []string{"*@unitn.it"}, false, nil},
+	} {
+		err := commands[c.name].run(db, c.args)
+		if (err == nil) != c.ok {
+			t.Errorf("%s %v: expected success to be %t, got %v", c.name, c.args, c.ok, err)
+		}
+		if c.check != nil && !c.check() {
+			t.Errorf("%s %v: the database or the disk is not as expected", c.name, c.args)
+		}
+	}
+}
+
+func TestOpenDatabase(t *testing.T) {
+	db, cleanup := setup(t)
+	defer cleanup()
+	*timeout = 10 * time.Millisecond
+
+	// bolt forgets the path of a closed database
+	path := db.Path()
+	if _, err := openDatabase(path+".missing", true); err == nil {
+		t.Error("expected a missing database not to be created")
+	}
+	// the server keeps the database locked while it runs
+	if _, err := openDatabase(path, false); err == nil || !strings.Contains(err.Error(), "locked by another process") {
+		t.Errorf("expected the database to be locked, got %v", err)
+	}
+
+	db.Close()
+	ro, err := openDatabase(path, true)
+	if err != nil {
+		t.Fatalf("expected the database to open once unlocked, got %s", err)
+	}
+	defer ro.Close()
+	if err := commands["list"].run(ro, nil); err != nil {
+		t.Errorf("expected read only commands to work, got %s", err)
+	}
+	if err := commands["revoke"].run(ro, []string{"/A/x.txt"}); err == nil {
+		t.Error("expected changes to fail on a read only database")
+	}
+}
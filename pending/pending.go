@@ -0,0 +1,71 @@
+// Package pending stores the actions waiting for their author
+// to confirm them through a link sent by email
+package pending
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+)
+
+// Put stores v in bucket under a new token, which is returned
+func Put(tx *bolt.Tx, bucket []byte, v interface{}) (string, error) {
+	b, err := tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	token := uuid.Must(uuid.NewV4()).String()
+	return token, b.Put([]byte(token), data)
+}
+
+// Take removes the value stored in bucket under token and decodes it into v,
+// found is false if there is none
+func Take(tx *bolt.Tx, bucket []byte, token string, v interface{}) (found bool, err error) {
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return false, nil
+	}
+	data := b.Get([]byte(token))
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, err
+	}
+	return true, b.Delete([]byte(token))
+}
+
+// Purge removes the values stored in bucket before t, it returns how many were removed.
+// Values must be JSON objects with a CreatedAt time, the ones without it are removed.
+func Purge(tx *bolt.Tx, bucket []byte, t time.Time) (int, error) {
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return 0, nil
+	}
+	var expired [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var created struct{ CreatedAt time.Time }
+		if err := json.Unmarshal(v, &created); err != nil {
+			return err
+		}
+		if created.CreatedAt.Before(t) {
+			expired = append(expired, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
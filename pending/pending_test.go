@@ -0,0 +1,58 @@
+package pending
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+type entry struct {
+	Email     string
+	CreatedAt time.Time
+}
+
+func TestPutTakePurge(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	bucket := []byte("pending_test")
+	now := time.Now()
+	var fresh, old string
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if fresh, err = Put(tx, bucket, entry{Email: "a@unitn.it", CreatedAt: now}); err != nil {
+			return err
+		}
+		old, err = Put(tx, bucket, entry{Email: "b@unitn.it", CreatedAt: now.Add(-72 * time.Hour)})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if n, err := Purge(tx, []byte("missing"), now); n != 0 || err != nil {
+			t.Errorf("expected nothing to purge in a missing bucket, got %d %v", n, err)
+		}
+		n, err := Purge(tx, bucket, now.Add(-48*time.Hour))
+		if n != 1 || err != nil {
+			t.Errorf("expected 1 expired entry, got %d %v", n, err)
+		}
+		var e entry
+		if found, err := Take(tx, bucket, old, &e); found || err != nil {
+			t.Errorf("expected the expired entry to be removed, got %v %v", found, err)
+		}
+		if found, err := Take(tx, bucket, fresh, &e); !found || err != nil || e.Email != "a@unitn.it" {
+			t.Errorf("expected the fresh entry, got %v %v %+v", found, err, e)
+		}
+		if found, _ := Take(tx, bucket, fresh, &e); found {
+			t.Error("expected the entry to be taken once")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package pow issues proof-of-work challenges, which cost a browser a fraction of a second
+// to solve but make sending many requests expensive.
+//
+// A challenge is a token signed by the server, holding a random nonce, the difficulty and
+// the expiry. It is solved by a string s such that the SHA-256 hash of token + ":" + s
+// starts with difficulty zero bits.
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Expiry is the time a challenge can be solved in
+	Expiry = time.Hour
+	// maxExtra bounds the bits added to the difficulty when the rate grows
+	maxExtra = 8
+	// window is the number of minutes the rate is measured over
+	window = 10
+)
+
+var (
+	// ErrInvalid is returned for tokens which have not been issued by the Issuer
+	ErrInvalid = errors.New("invalid challenge")
+	// ErrExpired is returned for tokens older than Expiry
+	ErrExpired = errors.New("expired challenge")
+	// ErrUnsolved is returned when the solution does not solve the challenge
+	ErrUnsolved = errors.New("wrong solution")
+	// ErrReused is returned for challenges which have already been solved
+	ErrReused = errors.New("challenge already used")
+)
+
+// A Challenge is sent to the browser to be solved
+type Challenge struct {
+	Token      string `json:"token"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// An Issuer creates and verifies challenges, the difficulty grows by one bit
+// each time the number of solutions in the last minutes doubles. It is safe for concurrent use.
+type Issuer struct {
+	secret []byte
+	base   int
+	scale  int
+	now    func() time.Time
+
+	mu sync.Mutex
+	// used maps the solved tokens to their expiry
+	used map[string]time.Time
+	// counts holds the solutions received in each of the last minutes
+	counts [window]int
+	minute int64
+}
+
+// NewIssuer returns an Issuer of challenges with base difficulty bits, harder once more than
+// scale solutions are received in ten minutes; scale 0 keeps the difficulty constant.
+// Challenges are signed with a random key, so they are not valid after a restart.
+func NewIssuer(base, scale int) (*Issuer, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &Issuer{
+		secret: secret,
+		base:   base,
+		scale:  scale,
+		now:    time.Now,
+		used:   make(map[string]time.Time),
+	}, nil
+}
+
+// advance drops the counts older than the window, is.mu must be held
+func (is *Issuer) advance(now time.Time) {
+	m := now.Unix() / 60
+	if m-is.minute >= window {
+		is.counts = [window]int{}
+		is.minute = m
+		return
+	}
+	for is.minute < m {
+		is.minute++
+		is.counts[is.minute%window] = 0
+	}
+}
+
+// Difficulty returns the difficulty of the challenges issued now
+func (is *Issuer) Difficulty() int {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.advance(is.now())
+	if is.scale <= 0 {
+		return is.base
+	}
+	n := 0
+	for _, c := range is.counts {
+		n += c
+	}
+	extra := bits.Len(uint(n / is.scale))
+	if extra > maxExtra {
+		extra = maxExtra
+	}
+	return is.base + extra
+}
+
+func (is *Issuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, is.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a new challenge
+func (is *Issuer) Issue() (Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, err
+	}
+	d := is.Difficulty()
+	payload := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString(nonce),
+		strconv.Itoa(d),
+		strconv.FormatInt(is.now().Add(Expiry).Unix(), 10),
+	}, ".")
+	return Challenge{Token: payload + "." + is.sign(payload), Difficulty: d}, nil
+}
+
+// Verify checks that solution solves the challenge token, which can be used only once
+func (is *Issuer) Verify(token, solution string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return ErrInvalid
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(is.sign(payload)), []byte(parts[3])) {
+		return ErrInvalid
+	}
+	d, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ErrInvalid
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	now := is.now()
+	expires := time.Unix(exp, 0)
+	if now.After(expires) {
+		return ErrExpired
+	}
+	if !Solves(token, solution, d) {
+		return ErrUnsolved
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for t, e := range is.used {
+		if now.After(e) {
+			delete(is.used, t)
+		}
+	}
+	if _, found := is.used[token]; found {
+		return ErrReused
+	}
+	is.used[token] = expires
+	is.advance(now)
+	is.counts[is.minute%window]++
+	return nil
+}
+
+// Solves reports whether solution solves the challenge token with difficulty bits
+func Solves(token, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(token + ":" + solution))
+	for _, b := range sum {
+		if difficulty <= 0 {
+			return true
+		}
+		if z := bits.LeadingZeros8(b); z < 8 {
+			return z >= difficulty
+		}
+		difficulty -= 8
+	}
+	return difficulty <= 0
+}
+
+// Solve finds a solution of the challenge c, as browsers do
+func Solve(c Challenge) string {
+	for i := 0; ; i++ {
+		s := strconv.Itoa(i)
+		if Solves(c.Token, s, c.Difficulty) {
+			return s
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package pow
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	is, err := NewIssuer(8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := is.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Difficulty != 8 {
+		t.Errorf("expected difficulty 8, got %d", c.Difficulty)
+	}
+	s := Solve(c)
+	if !Solves(c.Token, s, 8) {
+		t.Fatalf("%s does not solve %s", s, c.Token)
+	}
+
+	wrong := "x"
+	for Solves(c.Token, wrong, 8) {
+		wrong += "x"
+	}
+	// the signature with its last digit changed
+	tampered := c.Token[:len(c.Token)-1] + "0"
+	if strings.HasSuffix(c.Token, "0") {
+		tampered = c.Token[:len(c.Token)-1] + "1"
+	}
+	other, _ := NewIssuer(8, 0)
+	for _, tc := range []struct {
+		issuer          *Issuer
+		token, solution string
+		want            error
+	}{
+		{is, c.Token, wrong, ErrUnsolved},
+		{is, tampered, s, ErrInvalid},
+		{is, "a.b.c", s, ErrInvalid},
+		{other, c.Token, s, ErrInvalid},
+		{is, c.Token, s, nil},
+		{is, c.Token, s, ErrReused},
+	} {
+		if err := tc.issuer.Verify(tc.token, tc.solution); err != tc.want {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tc.token, tc.solution, err, tc.want)
+		}
+	}
+
+	c, _ = is.Issue()
+	is.now = func() time.Time { return time.Now().Add(2 * Expiry) }
+	if err := is.Verify(c.Token, Solve(c)); err != ErrExpired {
+		t.Errorf("expected an expired challenge, got %v", err)
+	}
+}
+
+func TestDifficulty(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	is, _ := NewIssuer(1, 2)
+	is.now = func() time.Time { return now }
+
+	for i, want := range []int{1, 1, 2, 2, 3, 3, 3, 3, 4} {
+		if d := is.Difficulty(); d != want {
+			t.Errorf("after %d solutions expected difficulty %d, got %d", i, want, d)
+		}
+		c, _ := is.Issue()
+		if err := is.Verify(c.Token, Solve(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(window * time.Minute)
+	if d := is.Difficulty(); d != 1 {
+		t.Errorf("expected the difficulty to go back to 1, got %d", d)
+	}
+}
@@ -0,0 +1,123 @@
+// Package ratelimit bounds the rate of events with a token bucket for each key
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets which are full again are forgotten
+const sweepInterval = time.Minute
+
+// A Limit allows Burst events at once, refilled at Burst events every Per.
+// The zero Limit allows every event.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// Parse reads a limit in the form <burst>/<duration>, e.g. 10/1h,
+// 0 or an empty string disable the limit
+func Parse(s string) (Limit, error) {
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return Limit{}, fmt.Errorf("%s: the limit must be in the form <events>/<duration>", s)
+	}
+	burst, err := strconv.Atoi(s[:i])
+	if err != nil || burst < 0 {
+		return Limit{}, fmt.Errorf("%s: the number of events must be a positive integer", s)
+	}
+	per, err := time.ParseDuration(s[i+1:])
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("%s: the duration must be positive, e.g. 1h or 30m", s)
+	}
+	return Limit{Burst: burst, Per: per}, nil
+}
+
+func (l Limit) String() string {
+	if l.Burst == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// interval is the time needed to refill one token
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Burst)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A Limiter keeps a token bucket for each key, it is safe for concurrent use
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// New returns a Limiter applying l to every key
+func New(l Limit) *Limiter {
+	return &Limiter{
+		limit:   l,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key. If there is none ok is false
+// and wait is the time after which the next token is available.
+func (rl *Limiter) Allow(key string) (ok bool, wait time.Duration) {
+	if rl.limit.Burst == 0 {
+		return true, 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+	b, found := rl.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(rl.limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = rl.refill(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	missing := time.Duration((1 - b.tokens) * float64(rl.limit.interval()))
+	return false, missing.Round(time.Millisecond)
+}
+
+// refill returns the tokens in b at time now
+func (rl *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(rl.limit.interval())
+	return math.Min(tokens, float64(rl.limit.Burst))
+}
+
+// sweep forgets the buckets which are full, they behave as new ones
+func (rl *Limiter) sweep(now time.Time) {
+	if now.Sub(rl.swept) < sweepInterval {
+		return
+	}
+	rl.swept = now
+	for k, b := range rl.buckets {
+		if rl.refill(b, now) >= float64(rl.limit.Burst) {
+			delete(rl.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]Limit{
+		"":       {},
+		"0":      {},
+		"10/1h":  {Burst: 10, Per: time.Hour},
+		"3/30m":  {Burst: 3, Per: 30 * time.Minute},
+		"10":     {Burst: -1},
+		"x/1h":   {Burst: -1},
+		"10/-1h": {Burst: -1},
+	} {
+		l, err := Parse(s)
+		if want.Burst < 0 {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, expected an error", s, l)
+			}
+			continue
+		}
+		if err != nil || l != want {
+			t.Errorf("Parse(%q) = %v, %v; want %v", s, l, err, want)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	rl := New(Limit{Burst: 2, Per: time.Hour})
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("a"); !ok {
+			t.Fatalf("event %d refused", i)
+		}
+	}
+	ok, wait := rl.Allow("a")
+	if ok || wait != 30*time.Minute {
+		t.Errorf("expected to wait 30m, got %v %s", ok, wait)
+	}
+	if ok, _ := rl.Allow("b"); !ok {
+		t.Error("keys must not share their bucket")
+	}
+
+	now = now.Add(20 * time.Minute)
+	if ok, wait := rl.Allow("a"); ok || wait != 10*time.Minute {
+		t.Errorf("expected to wait 10m, got %v %s", ok, wait)
+	}
+	now = now.Add(10 * time.Minute)
+	if ok, _ := rl.Allow("a"); !ok {
+		t.Error("the bucket has not been refilled")
+	}
+
+	now = now.Add(2 * time.Hour)
+	rl.Allow("c")
+	if _, found := rl.buckets["b"]; found {
+		t.Error("full buckets must be forgotten")
+	}
+
+	if ok, _ := New(Limit{}).Allow("a"); !ok {
+		t.Error("the zero limit must allow every event")
+	}
+}
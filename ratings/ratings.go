@@ -0,0 +1,178 @@
+// Package ratings stores the ratings given to files by verified users,
+// one per user and file
+package ratings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/pending"
+)
+
+const (
+	// MinRating and MaxRating bound the ratings users can give
+	MinRating = 1
+	MaxRating = 5
+
+	// priorVotes and priorRating are the imaginary votes added to every file
+	// when ranking, so that a single enthusiastic vote does not win over many good ones
+	priorVotes  = 2
+	priorRating = 3
+)
+
+var (
+	// VotesBucket maps the path of a file followed by a zero byte and an email
+	// to the rating given by that email, stored as a single byte
+	VotesBucket = []byte("votes")
+	// ScoresBucket maps file paths to the number of votes and their sum,
+	// stored as two big endian uint64
+	ScoresBucket = []byte("scores")
+	// PendingBucket maps tokens to the votes waiting for confirmation
+	PendingBucket = []byte("pending_votes")
+)
+
+// A Score summarizes the ratings of a file
+type Score struct {
+	Count uint64
+	Sum   uint64
+}
+
+// Average returns the mean rating, zero if there are no votes
+func (s Score) Average() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// Rank returns the value files are sorted by: the average rating
+// pulled towards the middle of the scale when there are few votes
+func (s Score) Rank() float64 {
+	return float64(s.Sum+priorVotes*priorRating) / float64(s.Count+priorVotes)
+}
+
+// A Pending vote waits for the voter to confirm the email address
+type Pending struct {
+	Path      string
+	Email     string
+	Rating    int
+	CreatedAt time.Time
+}
+
+func voteKey(path, email string) []byte {
+	return []byte(path + "\x00" + email)
+}
+
+func getScore(b *bolt.Bucket, path string) Score {
+	v := b.Get([]byte(path))
+	if len(v) != 16 {
+		return Score{}
+	}
+	return Score{
+		Count: binary.BigEndian.Uint64(v[:8]),
+		Sum:   binary.BigEndian.Uint64(v[8:]),
+	}
+}
+
+func putScore(b *bolt.Bucket, path string, s Score) error {
+	if s.Count == 0 {
+		return b.Delete([]byte(path))
+	}
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v[:8], s.Count)
+	binary.BigEndian.PutUint64(v[8:], s.Sum)
+	return b.Put([]byte(path), v)
+}
+
+// Get returns the score of the file at path
+func Get(tx *bolt.Tx, path string) Score {
+	b := tx.Bucket(ScoresBucket)
+	if b == nil {
+		return Score{}
+	}
+	return getScore(b, path)
+}
+
+// Vote records the rating given by email to the file at path,
+// replacing the previous vote of the same email
+func Vote(tx *bolt.Tx, path, email string, rating int) error {
+	votes, err := tx.CreateBucketIfNotExists(VotesBucket)
+	if err != nil {
+		return err
+	}
+	scores, err := tx.CreateBucketIfNotExists(ScoresBucket)
+	if err != nil {
+		return err
+	}
+	s := getScore(scores, path)
+	if old := votes.Get(voteKey(path, email)); len(old) == 1 {
+		s.Count--
+		s.Sum -= uint64(old[0])
+	}
+	if err := votes.Put(voteKey(path, email), []byte{byte(rating)}); err != nil {
+		return err
+	}
+	s.Count++
+	s.Sum += uint64(rating)
+	return putScore(scores, path, s)
+}
+
+// votesOf returns the emails which voted the file at path along with their rating
+func votesOf(tx *bolt.Tx, path string) map[string]byte {
+	votes := make(map[string]byte)
+	b := tx.Bucket(VotesBucket)
+	if b == nil {
+		return votes
+	}
+	prefix := []byte(path + "\x00")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if len(v) == 1 {
+			votes[string(k[len(prefix):])] = v[0]
+		}
+	}
+	return votes
+}
+
+// Delete removes the votes given to the file at path
+func Delete(tx *bolt.Tx, path string) error {
+	if b := tx.Bucket(VotesBucket); b != nil {
+		for email := range votesOf(tx, path) {
+			if err := b.Delete(voteKey(path, email)); err != nil {
+				return err
+			}
+		}
+	}
+	if b := tx.Bucket(ScoresBucket); b != nil {
+		return b.Delete([]byte(path))
+	}
+	return nil
+}
+
+// Move transfers the votes of a file which has been renamed
+func Move(tx *bolt.Tx, from, to string) error {
+	votes := votesOf(tx, from)
+	if err := Delete(tx, from); err != nil {
+		return err
+	}
+	for email, rating := range votes {
+		if err := Vote(tx, to, email, int(rating)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPending stores a vote until it is confirmed, returning the confirmation token
+func AddPending(tx *bolt.Tx, p Pending) (string, error) {
+	return pending.Put(tx, PendingBucket, p)
+}
+
+// TakePending removes and returns the vote waiting for confirmation with token,
+// found is false if there is none
+func TakePending(tx *bolt.Tx, token string) (p Pending, found bool, err error) {
+	found, err = pending.Take(tx, PendingBucket, token, &p)
+	return p, found, err
+}
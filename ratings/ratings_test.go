@@ -0,0 +1,54 @@
+package ratings
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestVote(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		votes := []struct {
+			path, email string
+			rating      int
+		}{
+			{"/a.pdf", "x@unitn.it", 5},
+			{"/a.pdf", "y@unitn.it", 2},
+			// changes the previous vote
+			{"/a.pdf", "x@unitn.it", 4},
+			{"/a.pdf.old", "x@unitn.it", 1},
+			{"/b.pdf", "x@unitn.it", 3},
+		}
+		for _, v := range votes {
+			if err := Vote(tx, v.path, v.email, v.rating); err != nil {
+				return err
+			}
+		}
+		return Move(tx, "/b.pdf", "/c.pdf")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		for path, want := range map[string]Score{
+			"/a.pdf":     {Count: 2, Sum: 6},
+			"/a.pdf.old": {Count: 1, Sum: 1},
+			"/b.pdf":     {},
+			"/c.pdf":     {Count: 1, Sum: 3},
+		} {
+			if got := Get(tx, path); got != want {
+				t.Errorf("score of %s: expected %+v, got %+v", path, want, got)
+			}
+		}
+		return nil
+	})
+
+	if (Score{Count: 1, Sum: 5}).Rank() >= (Score{Count: 10, Sum: 45}).Rank() {
+		t.Error("a single vote should rank below many slightly lower ones")
+	}
+}
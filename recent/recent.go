@@ -0,0 +1,113 @@
+// Package recent keeps a time index of the published files,
+// so that the newest ones can be listed without walking the whole tree
+package recent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+var (
+	// Bucket contains a key for every published file, made of the time
+	// the file was published, as big endian unix nanoseconds, followed by its path.
+	// Values are unused.
+	Bucket = []byte("recent")
+)
+
+// An Entry is a file in the time index
+type Entry struct {
+	Path string
+	Time time.Time
+}
+
+func key(path string, t time.Time) []byte {
+	k := make([]byte, 8, 8+len(path))
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return append(k, path...)
+}
+
+func parseKey(k []byte) (Entry, bool) {
+	if len(k) <= 8 {
+		return Entry{}, false
+	}
+	return Entry{
+		Path: string(k[8:]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(k[:8]))),
+	}, true
+}
+
+// Exists reports whether the time index has been built
+func Exists(tx *bolt.Tx) bool {
+	return tx.Bucket(Bucket) != nil
+}
+
+// Add indexes the file stored at path if it is published
+func Add(tx *bolt.Tx, path string, dbf fs.DBFile) error {
+	if !dbf.Published() {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists(Bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(path, dbf.PublishedAt()), []byte{})
+}
+
+// Remove drops the file stored at path from the index,
+// dbf must be the record the file was indexed with
+func Remove(tx *bolt.Tx, path string, dbf fs.DBFile) error {
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete(key(path, dbf.PublishedAt()))
+}
+
+// Rebuild recreates the time index from the files bucket
+func Rebuild(tx *bolt.Tx) error {
+	if Exists(tx) {
+		if err := tx.DeleteBucket(Bucket); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.CreateBucket(Bucket); err != nil {
+		return err
+	}
+	return tx.Bucket(fs.FilesBucket).ForEach(func(k, v []byte) error {
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		return Add(tx, string(k), dbf)
+	})
+}
+
+// Newest returns at most n files accepted by filter, newest first,
+// skipping the first skip of them. more reports whether older files follow.
+func Newest(tx *bolt.Tx, skip, n int, filter func(path string) bool) (entries []Entry, more bool) {
+	entries = make([]Entry, 0, n)
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return entries, false
+	}
+	c := b.Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		e, ok := parseKey(k)
+		if !ok || !filter(e.Path) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if len(entries) == n {
+			return entries, true
+		}
+		entries = append(entries, e)
+	}
+	return entries, false
+}
@@ -0,0 +1,55 @@
+package recent
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestNewest(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 10; i++ {
+			dbf := fs.DBFile{
+				Name:         fmt.Sprintf("file%d", i),
+				Authorized:   i != 5,
+				AuthorizedAt: start.Add(time.Duration(i) * time.Hour),
+			}
+			if err := Add(tx, "/dir/"+dbf.Name, dbf); err != nil {
+				return err
+			}
+		}
+		return Remove(tx, "/dir/file9", fs.DBFile{Authorized: true, AuthorizedAt: start.Add(9 * time.Hour)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		all := func(string) bool { return true }
+		entries, more := Newest(tx, 0, 3, all)
+		if !more || len(entries) != 3 || entries[0].Path != "/dir/file8" || entries[2].Path != "/dir/file6" {
+			t.Errorf("unexpected first page %v, more %v", entries, more)
+		}
+		if !entries[0].Time.Equal(start.Add(8 * time.Hour)) {
+			t.Errorf("unexpected time %s", entries[0].Time)
+		}
+		entries, more = Newest(tx, 6, 3, all)
+		if more || len(entries) != 2 || entries[0].Path != "/dir/file1" {
+			t.Errorf("unexpected last page %v, more %v", entries, more)
+		}
+		entries, _ = Newest(tx, 0, 3, func(path string) bool { return !strings.HasSuffix(path, "8") })
+		if entries[0].Path != "/dir/file7" || entries[2].Path != "/dir/file4" {
+			t.Errorf("unexpected filtered entries %v", entries)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,168 @@
+// Package reports stores the reports of problematic files sent by visitors
+package reports
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/pending"
+)
+
+var (
+	// Bucket maps the path of a file followed by a zero byte and the email
+	// of the reporter to the Report, every email can report a file once
+	Bucket = []byte("reports")
+	// PendingBucket maps tokens to the reports waiting for confirmation
+	PendingBucket = []byte("pending_reports")
+)
+
+// A Reason explains why a file is reported
+type Reason struct {
+	Name  string
+	Label string
+}
+
+// Reasons lists the reasons a file can be reported for
+var Reasons = []Reason{
+	{"copyright", "It infringes my copyright"},
+	{"wrong-course", "It belongs to another course"},
+	{"broken", "It is broken or unreadable"},
+	{"offensive", "It is offensive or not course material"},
+}
+
+// IsReason reports whether name is one of Reasons
+func IsReason(name string) bool {
+	for _, r := range Reasons {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// A Report is the complaint of a visitor about a file
+type Report struct {
+	Path      string
+	Email     string
+	Reason    string
+	Details   string
+	CreatedAt time.Time
+}
+
+func key(path, email string) []byte {
+	return []byte(path + "\x00" + email)
+}
+
+// Add stores r, replacing the previous report of the same email
+func Add(tx *bolt.Tx, r Report) error {
+	b, err := tx.CreateBucketIfNotExists(Bucket)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(r.Path, r.Email), v)
+}
+
+// List returns the reports of the file at path, oldest first
+func List(tx *bolt.Tx, path string) ([]Report, error) {
+	list := make([]Report, 0)
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return list, nil
+	}
+	prefix := []byte(path + "\x00")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		r := Report{}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Reported returns the paths of the files which received reports, in order
+func Reported(tx *bolt.Tx) []string {
+	paths := make([]string, 0)
+	b := tx.Bucket(Bucket)
+	if b == nil {
+		return paths
+	}
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; {
+		path := k[:bytes.IndexByte(k, 0)]
+		paths = append(paths, string(path))
+		// the byte after the zero separator skips every report of path
+		k, _ = c.Seek(append(append([]byte{}, path...), 1))
+	}
+	return paths
+}
+
+// CountSince returns the number of distinct reporters of the file at path after t,
+// only the emails accepted by trusted are counted unless it is nil
+func CountSince(tx *bolt.Tx, path string, t time.Time, trusted func(email string) bool) (int, error) {
+	list, err := List(tx, path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range list {
+		if r.CreatedAt.After(t) && (trusted == nil || trusted(r.Email)) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Delete removes the reports of the file at path
+func Delete(tx *bolt.Tx, path string) error {
+	list, err := List(tx, path)
+	if err != nil || len(list) == 0 {
+		return err
+	}
+	b := tx.Bucket(Bucket)
+	for _, r := range list {
+		if err := b.Delete(key(path, r.Email)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move transfers the reports of a file which has been renamed
+func Move(tx *bolt.Tx, from, to string) error {
+	list, err := List(tx, from)
+	if err != nil {
+		return err
+	}
+	if err := Delete(tx, from); err != nil {
+		return err
+	}
+	for _, r := range list {
+		r.Path = to
+		if err := Add(tx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPending stores a report until it is confirmed, returning the confirmation token
+func AddPending(tx *bolt.Tx, r Report) (string, error) {
+	return pending.Put(tx, PendingBucket, r)
+}
+
+// TakePending removes and returns the report waiting for confirmation with token,
+// found is false if there is none
+func TakePending(tx *bolt.Tx, token string) (r Report, found bool, err error) {
+	found, err = pending.Take(tx, PendingBucket, token, &r)
+	return r, found, err
+}
@@ -0,0 +1,57 @@
+package reports
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestCountSince(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i, email := range []string{"a@unitn.it", "b@unitn.it", "a@unitn.it", "c@unitn.it"} {
+			r := Report{
+				Path:      "/a.pdf",
+				Email:     email,
+				Reason:    "broken",
+				CreatedAt: start.Add(time.Duration(i) * time.Hour),
+			}
+			if err := Add(tx, r); err != nil {
+				return err
+			}
+		}
+		return Add(tx, Report{Path: "/a.pdf.old", Email: "d@unitn.it", CreatedAt: start})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		if paths := Reported(tx); len(paths) != 2 || paths[0] != "/a.pdf" || paths[1] != "/a.pdf.old" {
+			t.Errorf("expected the reported paths /a.pdf and /a.pdf.old, got %v", paths)
+		}
+		for _, c := range []struct {
+			since time.Time
+			want  int
+		}{
+			{time.Time{}, 3},
+			// the second report of a@unitn.it replaced the first one
+			{start.Add(90 * time.Minute), 2},
+			{start.Add(3 * time.Hour), 0},
+		} {
+			if n, err := CountSince(tx, "/a.pdf", c.since, nil); err != nil || n != c.want {
+				t.Errorf("reports since %s: expected %d, got %d (%v)", c.since, c.want, n, err)
+			}
+		}
+		notB := func(email string) bool { return email != "b@unitn.it" }
+		if n, err := CountSince(tx, "/a.pdf", time.Time{}, notB); err != nil || n != 2 {
+			t.Errorf("expected 2 trusted reports, got %d (%v)", n, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,233 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/extract"
+	"github.com/socialnotes/mirror/fs"
+)
+
+var (
+	// Content indexes the text extracted from published documents
+	Content = &Index{name: []byte("content_index")}
+
+	// TextBucket maps the path of documents to their extracted text,
+	// which is used to build search snippets. Documents whose text could not
+	// be extracted have an empty value, so that they are not processed again.
+	TextBucket = []byte("text")
+)
+
+// ContentTerms returns the terms of a text, weighted by their frequency
+func ContentTerms(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, term := range Tokenize(text) {
+		counts[term]++
+	}
+	terms := make(map[string]int, len(counts))
+	for term, n := range counts {
+		// a term repeated often is relevant, but should not outweigh file names
+		if n > 20 {
+			n = 20
+		}
+		terms[term] = 1 + n/10
+	}
+	return terms
+}
+
+// Text returns the stored text of the document at path
+func Text(tx *bolt.Tx, path string) string {
+	b := tx.Bucket(TextBucket)
+	if b == nil {
+		return ""
+	}
+	return string(b.Get([]byte(path)))
+}
+
+// DeleteContent removes the text of the document at path from the index
+func DeleteContent(tx *bolt.Tx, path string) error {
+	if b := tx.Bucket(TextBucket); b != nil {
+		if err := b.Delete([]byte(path)); err != nil {
+			return err
+		}
+	}
+	return Content.Delete(tx, path)
+}
+
+// MoveContent transfers the text of a document which has been renamed,
+// so that it is not extracted again
+func MoveContent(tx *bolt.Tx, from, to string) error {
+	b := tx.Bucket(TextBucket)
+	if b == nil {
+		return nil
+	}
+	// empty values may be returned as nil by Get, look for the key instead
+	k, v := b.Cursor().Seek([]byte(from))
+	if !bytes.Equal(k, []byte(from)) {
+		return nil
+	}
+	text := string(v)
+	if err := DeleteContent(tx, from); err != nil {
+		return err
+	}
+	if err := b.Put([]byte(to), []byte(text)); err != nil {
+		return err
+	}
+	return Content.Put(tx, to, ContentTerms(text))
+}
+
+// extractText is the parser of documents, replaced in tests
+var extractText = extract.Text
+
+// safeText returns the text of the document named name, turning a panic of the parser,
+// which reads untrusted uploads, into an error. The document is then stored without text,
+// so that it is not extracted again.
+func safeText(name string, r io.ReaderAt, size int64) (text string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			text, err = "", fmt.Errorf("parser panic: %v", p)
+		}
+	}()
+	return extractText(name, r, size)
+}
+
+// IndexContent extracts the text of the document at path and adds it to
+// the Content index. Documents which are not published or whose type is not
+// supported are ignored. The database is not locked during the extraction.
+func IndexContent(db *bolt.DB, dir fs.Dir, path string) error {
+	dbf := fs.DBFile{}
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(fs.FilesBucket).Get([]byte(path))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &dbf)
+	})
+	if err != nil {
+		return err
+	}
+	if !dbf.Published() || !extract.Supported(dbf.Name) {
+		return nil
+	}
+
+	f, err := dir.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	text, extractErr := safeText(dbf.Name, f, fi.Size())
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		// the file may have been removed in the meantime
+		if tx.Bucket(fs.FilesBucket).Get([]byte(path)) == nil {
+			return nil
+		}
+		b, err := tx.CreateBucketIfNotExists(TextBucket)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(path), []byte(text)); err != nil {
+			return err
+		}
+		return Content.Put(tx, path, ContentTerms(text))
+	})
+	if err != nil {
+		return err
+	}
+	if extractErr != nil {
+		return fmt.Errorf("extracting text: %s", extractErr)
+	}
+	return nil
+}
+
+// MissingContent returns the published documents whose text has not been extracted yet
+func MissingContent(tx *bolt.Tx) ([]string, error) {
+	paths := make([]string, 0)
+	files := tx.Bucket(fs.FilesBucket)
+	if files == nil {
+		return nil, errors.New("no bucket named files")
+	}
+	var c *bolt.Cursor
+	if b := tx.Bucket(TextBucket); b != nil {
+		c = b.Cursor()
+	}
+	return paths, files.ForEach(func(k, v []byte) error {
+		// empty values may be returned as nil by Get, look for the key instead
+		if c != nil {
+			if tk, _ := c.Seek(k); bytes.Equal(tk, k) {
+				return nil
+			}
+		}
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		if dbf.Published() && extract.Supported(dbf.Name) {
+			paths = append(paths, string(k))
+		}
+		return nil
+	})
+}
+
+// An Extractor indexes the content of documents in the background
+type Extractor struct {
+	db  *bolt.DB
+	dir fs.Dir
+
+	queue   chan string
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewExtractor returns an Extractor able to hold size pending documents
+func NewExtractor(db *bolt.DB, dir fs.Dir, size int) *Extractor {
+	return &Extractor{
+		db:  db,
+		dir: dir,
+
+		queue:   make(chan string, size),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Enqueue schedules the document at path for indexing. It never blocks:
+// if the queue is full the document is left for the indexer to backfill.
+func (e *Extractor) Enqueue(path string) {
+	select {
+	case e.queue <- path:
+	default:
+		log.Printf("[warn] extraction queue full, skipping %s\n", path)
+	}
+}
+
+// Run processes the queued documents until Stop is called
+func (e *Extractor) Run() {
+	defer close(e.stopped)
+	for {
+		select {
+		case <-e.stop:
+			return
+		case path := <-e.queue:
+			if err := IndexContent(e.db, e.dir, path); err != nil {
+				log.Printf("[err] indexing content of %s: %s\n", path, err)
+			}
+		}
+	}
+}
+
+// Stop waits for Run to finish the document it is indexing and makes it return,
+// the documents still queued are left for the indexer to backfill
+func (e *Extractor) Stop() {
+	close(e.stop)
+	<-e.stopped
+}
@@ -0,0 +1,260 @@
+// Package search implements inverted indexes stored in boltdb buckets
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+var (
+	termsBucket = []byte("terms")
+	docsBucket  = []byte("docs")
+
+	// Names indexes the path segments and the name of published files
+	Names = &Index{name: []byte("names_index")}
+)
+
+const (
+	// weight of a term found in the file name
+	nameWeight = 3
+	// weight of a term found in a directory name
+	dirWeight = 1
+)
+
+// An Index maps terms to the paths of the files containing them.
+//
+// The index is a bucket with two nested buckets:
+// terms contains a key "<term>\x00<path>" for every term of a document,
+// with the weight of the term as value; docs maps every path to the list of
+// its terms, so that a document can be removed without knowing its content.
+type Index struct {
+	name []byte
+}
+
+// A Result is a path matching a query, along with its score
+type Result struct {
+	Path  string
+	Score int
+}
+
+// Create creates the index buckets if they do not exist yet
+func (ix *Index) Create(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists(ix.name)
+	if err != nil {
+		return err
+	}
+	if _, err := b.CreateBucketIfNotExists(termsBucket); err != nil {
+		return err
+	}
+	_, err = b.CreateBucketIfNotExists(docsBucket)
+	return err
+}
+
+// Exists reports whether the index buckets have been created
+func (ix *Index) Exists(tx *bolt.Tx) bool {
+	return tx.Bucket(ix.name) != nil
+}
+
+// Clear removes every document from the index
+func (ix *Index) Clear(tx *bolt.Tx) error {
+	if ix.Exists(tx) {
+		if err := tx.DeleteBucket(ix.name); err != nil {
+			return err
+		}
+	}
+	return ix.Create(tx)
+}
+
+func (ix *Index) buckets(tx *bolt.Tx) (terms, docs *bolt.Bucket, err error) {
+	if err := ix.Create(tx); err != nil {
+		return nil, nil, err
+	}
+	b := tx.Bucket(ix.name)
+	return b.Bucket(termsBucket), b.Bucket(docsBucket), nil
+}
+
+func termKey(term, path string) []byte {
+	return []byte(term + "\x00" + path)
+}
+
+// Put indexes path with the given terms and weights, replacing
+// the terms previously associated with it
+func (ix *Index) Put(tx *bolt.Tx, path string, terms map[string]int) error {
+	if err := ix.Delete(tx, path); err != nil {
+		return err
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	tb, db, err := ix.buckets(tx)
+	if err != nil {
+		return err
+	}
+	list := make([]string, 0, len(terms))
+	for term, weight := range terms {
+		if weight > 255 {
+			weight = 255
+		}
+		if err := tb.Put(termKey(term, path), []byte{byte(weight)}); err != nil {
+			return err
+		}
+		list = append(list, term)
+	}
+	v, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(path), v)
+}
+
+// Delete removes path from the index, it is not an error if path is not indexed
+func (ix *Index) Delete(tx *bolt.Tx, path string) error {
+	if !ix.Exists(tx) {
+		return nil
+	}
+	tb, db, err := ix.buckets(tx)
+	if err != nil {
+		return err
+	}
+	v := db.Get([]byte(path))
+	if v == nil {
+		return nil
+	}
+	list := []string{}
+	if err := json.Unmarshal(v, &list); err != nil {
+		return err
+	}
+	for _, term := range list {
+		if err := tb.Delete(termKey(term, path)); err != nil {
+			return err
+		}
+	}
+	return db.Delete([]byte(path))
+}
+
+// match returns the score of every path matching term inside scope.
+// Exact matches weigh twice as much as prefix matches; terms of a single
+// character only match exactly.
+func (ix *Index) match(tx *bolt.Tx, term, scope string) map[string]int {
+	scores := make(map[string]int)
+	b := tx.Bucket(ix.name)
+	if b == nil {
+		return scores
+	}
+	prefix := []byte(term)
+	if len(term) < 2 {
+		prefix = append(prefix, 0)
+	}
+	c := b.Bucket(termsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		sep := bytes.IndexByte(k, 0)
+		if sep < 0 || len(v) == 0 {
+			continue
+		}
+		path := string(k[sep+1:])
+		if !strings.HasPrefix(path, scope) {
+			continue
+		}
+		score := int(v[0])
+		if sep == len(term) {
+			score *= 2
+		}
+		if score > scores[path] {
+			scores[path] = score
+		}
+	}
+	return scores
+}
+
+// Search returns the paths under scope matching every term in query,
+// in any of the indexes, ordered by decreasing score.
+// The score of a term is the sum of its scores in the single indexes.
+func Search(tx *bolt.Tx, query []string, scope string, indexes ...*Index) []Result {
+	results := make([]Result, 0)
+	if len(query) == 0 {
+		return results
+	}
+
+	var total map[string]int
+	for _, term := range query {
+		scores := make(map[string]int)
+		for _, ix := range indexes {
+			for path, score := range ix.match(tx, term, scope) {
+				scores[path] += score
+			}
+		}
+		if total == nil {
+			total = scores
+			continue
+		}
+		for path, score := range total {
+			if s, ok := scores[path]; ok {
+				total[path] = score + s
+			} else {
+				delete(total, path)
+			}
+		}
+	}
+
+	for path, score := range total {
+		results = append(results, Result{Path: path, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	return results
+}
+
+// PathTerms returns the terms of a file path, weighting the ones
+// in the file name more than the ones in the directory names
+func PathTerms(path string) map[string]int {
+	terms := make(map[string]int)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		weight := dirWeight
+		if i == len(segments)-1 {
+			weight = nameWeight
+		}
+		for _, term := range Tokenize(segment) {
+			if weight > terms[term] {
+				terms[term] = weight
+			}
+		}
+	}
+	return terms
+}
+
+// IndexNames adds a file to the Names index if it is published
+// and removes it otherwise
+func IndexNames(tx *bolt.Tx, path string, dbf fs.DBFile) error {
+	if !dbf.Published() {
+		return Names.Delete(tx, path)
+	}
+	return Names.Put(tx, path, PathTerms(path))
+}
+
+// RebuildNames recreates the Names index from the files bucket
+func RebuildNames(tx *bolt.Tx) error {
+	if err := Names.Clear(tx); err != nil {
+		return err
+	}
+	bucket := tx.Bucket(fs.FilesBucket)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(k, v []byte) error {
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		return IndexNames(tx, string(k), dbf)
+	})
+}
@@ -0,0 +1,151 @@
+package search
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("Esame_2019-Analisi Università.PDF")
+	expected := []string{"esame", "2019", "analisi", "universita", "pdf"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, p := range []string{
+			"/Analisi1/esame_2019.pdf",
+			"/Analisi1/appunti.pdf",
+			"/Fisica/analisi dati 2019.pdf",
+			"/Fisica/esame 2018.pdf",
+		} {
+			if err := Names.Put(tx, p, PathTerms(p)); err != nil {
+				return err
+			}
+		}
+		return Names.Delete(tx, "/Analisi1/appunti.pdf")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		res := Search(tx, Tokenize("esame 2019 analisi"), "/", Names)
+		if len(res) != 1 || res[0].Path != "/Analisi1/esame_2019.pdf" {
+			t.Errorf("unexpected results %v", res)
+		}
+		res = Search(tx, Tokenize("analisi 2019"), "/", Names)
+		if len(res) != 2 || res[0].Path != "/Fisica/analisi dati 2019.pdf" {
+			t.Errorf("name matches should rank first: %v", res)
+		}
+		res = Search(tx, Tokenize("appunti"), "/", Names)
+		if len(res) != 0 {
+			t.Errorf("deleted file still indexed: %v", res)
+		}
+		res = Search(tx, Tokenize("esame"), "/Fisica/", Names)
+		if len(res) != 1 || res[0].Path != "/Fisica/esame 2018.pdf" {
+			t.Errorf("scope not honored: %v", res)
+		}
+		return nil
+	})
+}
+
+func TestSnippet(t *testing.T) {
+	text := "Corso di Analisi Matematica. Esame del 12 giugno 2019: esercizi sulle serie e sugli integrali impropri."
+	fragments := Snippet(text, Tokenize("esame 2019"), 40)
+	got := ""
+	for _, f := range fragments {
+		if f.Match {
+			got += "[" + f.Text + "]"
+		} else {
+			got += f.Text
+		}
+	}
+	expected := "…Matematica. [Esame] del 12 giugno [2019]…"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if Snippet(text, Tokenize("fisica"), 40) != nil {
+		t.Error("expected no snippet for a text not matching the query")
+	}
+}
+
+func TestIndexContentPanic(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+	dir, err := ioutil.TempDir("", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "crafted.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(fs.FilesBucket)
+		if err != nil {
+			return err
+		}
+		v, _ := json.Marshal(fs.DBFile{Name: "crafted.pdf", Authorized: true})
+		return b.Put([]byte("/crafted.pdf"), v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f func(string, io.ReaderAt, int64) (string, error)) { extractText = f }(extractText)
+	extractText = func(string, io.ReaderAt, int64) (string, error) { panic("index out of range") }
+
+	if err := IndexContent(db, fs.Dir(dir), "/crafted.pdf"); err == nil || !strings.Contains(err.Error(), "index out of range") {
+		t.Errorf("expected the panic to be returned as an error, got %v", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if missing, _ := MissingContent(tx); len(missing) != 0 {
+			t.Errorf("expected the document not to be extracted again, got %v", missing)
+		}
+		return nil
+	})
+}
+
+func TestExtractorStop(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(fs.FilesBucket)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex := NewExtractor(db, fs.Dir(""), 1)
+	done := make(chan struct{})
+	go func() {
+		ex.Run()
+		close(done)
+	}()
+	ex.Enqueue("/missing.pdf")
+	ex.Stop()
+	select {
+	case <-done:
+	default:
+		t.Error("expected Run to return once Stop returned")
+	}
+	// documents enqueued while shutting down are dropped
+	ex.Enqueue("/late.pdf")
+	ex.Enqueue("/later.pdf")
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// A Fragment is a piece of a snippet, Match is set if the text matches a query term
+type Fragment struct {
+	Text  string
+	Match bool
+}
+
+// word is a term of a text, along with its position
+type word struct {
+	start, end int
+	term       string
+}
+
+// words splits text in words, recording their byte offsets
+func words(text string) []word {
+	ws := make([]word, 0)
+	start := -1
+	for i, r := range text {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			ws = append(ws, word{start, i, fold(strings.ToLower(text[start:i]))})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ws = append(ws, word{start, len(text), fold(strings.ToLower(text[start:]))})
+	}
+	return ws
+}
+
+// matches reports whether term matches one of the query terms,
+// with the same rules used by Search
+func matches(term string, query []string) bool {
+	for _, q := range query {
+		if term == q || len(q) > 1 && strings.HasPrefix(term, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snippet returns about width bytes of text around the first term matching the query,
+// split in fragments so that matches can be highlighted.
+// It returns nil if no term in text matches the query.
+func Snippet(text string, query []string, width int) []Fragment {
+	ws := words(text)
+	first := -1
+	for i, w := range ws {
+		if matches(w.term, query) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+
+	// start at a word boundary about a third of the width before the match
+	start, end := ws[first].start, len(text)
+	for i := first; i >= 0 && ws[first].start-ws[i].start <= width/3; i-- {
+		start = ws[i].start
+	}
+	for i := first; i < len(ws); i++ {
+		if ws[i].end-start > width {
+			break
+		}
+		end = ws[i].end
+	}
+	for end-start > width && !utf8.RuneStart(text[start+width]) {
+		// a single huge word, cut it at a rune boundary
+		width--
+	}
+	if end-start > width {
+		end = start + width
+	}
+
+	fragments := make([]Fragment, 0)
+	if start > 0 {
+		fragments = append(fragments, Fragment{Text: "…"})
+	}
+	pos := start
+	for _, w := range ws {
+		if w.start < start || w.end > end {
+			continue
+		}
+		if !matches(w.term, query) {
+			continue
+		}
+		fragments = append(fragments,
+			Fragment{Text: text[pos:w.start]},
+			Fragment{Text: text[w.start:w.end], Match: true},
+		)
+		pos = w.end
+	}
+	fragments = append(fragments, Fragment{Text: text[pos:end]})
+	if end < len(text) {
+		fragments = append(fragments, Fragment{Text: "…"})
+	}
+	return fragments
+}
@@ -0,0 +1,45 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// folding maps accented latin letters to their base letter,
+// so that "università" and "universita" are the same term
+var folding = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ä': 'a', 'ã': 'a', 'å': 'a',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'ö': 'o', 'õ': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+	'ç': 'c', 'ñ': 'n',
+}
+
+// Tokenize splits s into lowercase terms made of letters and digits,
+// removing accents from latin letters
+func Tokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !isWordRune(r)
+	})
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		terms = append(terms, fold(f))
+	}
+	return terms
+}
+
+// fold removes the accents from the letters of a lowercase word
+func fold(word string) string {
+	return strings.Map(func(r rune) rune {
+		if f, ok := folding[r]; ok {
+			return f
+		}
+		return r
+	}, word)
+}
+
+// isWordRune reports whether r can be part of a term
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
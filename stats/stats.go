@@ -0,0 +1,229 @@
+// Package stats keeps track of how many times files are downloaded
+package stats
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// DownloadsBucket maps file paths to their download count,
+	// stored as a big endian uint64
+	DownloadsBucket = []byte("downloads")
+)
+
+// An Entry is the download count of a file
+type Entry struct {
+	Path      string
+	Downloads uint64
+}
+
+// A Counter counts downloads in memory and periodically adds them to the database,
+// so that serving a file never waits for the database write lock
+type Counter struct {
+	db *bolt.DB
+
+	mu      sync.Mutex
+	pending map[string]uint64
+}
+
+// counters are the Counters of each database, so that Move and Delete can
+// also update the counts not flushed yet
+var counters = struct {
+	sync.Mutex
+	m map[*bolt.DB]*Counter
+}{m: make(map[*bolt.DB]*Counter)}
+
+// NewCounter returns a Counter storing its counts in db,
+// it replaces the previous Counter of db
+func NewCounter(db *bolt.DB) *Counter {
+	c := &Counter{
+		db:      db,
+		pending: make(map[string]uint64),
+	}
+	counters.Lock()
+	counters.m[db] = c
+	counters.Unlock()
+	return c
+}
+
+// take removes and returns the pending count of the file at path
+// from the Counter of the database of tx
+func take(tx *bolt.Tx, path string) uint64 {
+	counters.Lock()
+	c := counters.m[tx.DB()]
+	counters.Unlock()
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.pending[path]
+	delete(c.pending, path)
+	return n
+}
+
+// Hit counts a download of the file at path
+func (c *Counter) Hit(path string) {
+	c.mu.Lock()
+	c.pending[path]++
+	c.mu.Unlock()
+}
+
+// Flush writes the pending counts to the database
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	empty := len(c.pending) == 0
+	c.mu.Unlock()
+	if empty {
+		return nil
+	}
+
+	var pending map[string]uint64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		// the counts are taken in the transaction, so that a file
+		// cannot be moved or deleted while they are written
+		c.mu.Lock()
+		pending = c.pending
+		c.pending = make(map[string]uint64)
+		c.mu.Unlock()
+		b, err := tx.CreateBucketIfNotExists(DownloadsBucket)
+		if err != nil {
+			return err
+		}
+		for path, n := range pending {
+			if err := put(b, path, get(b, path)+n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// keep the counts for the next attempt
+		c.mu.Lock()
+		for path, n := range pending {
+			c.pending[path] += n
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Run flushes the pending counts every interval, it never returns
+func (c *Counter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := c.Flush(); err != nil {
+			log.Printf("[err] flushing download counts: %s\n", err)
+		}
+	}
+}
+
+// Downloads returns the download count of the file at path,
+// including the downloads not flushed yet
+func (c *Counter) Downloads(tx *bolt.Tx, path string) uint64 {
+	c.mu.Lock()
+	n := c.pending[path]
+	c.mu.Unlock()
+	return n + Downloads(tx, path)
+}
+
+func get(b *bolt.Bucket, path string) uint64 {
+	v := b.Get([]byte(path))
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func put(b *bolt.Bucket, path string, n uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, n)
+	return b.Put([]byte(path), v)
+}
+
+// Downloads returns the stored download count of the file at path
+func Downloads(tx *bolt.Tx, path string) uint64 {
+	b := tx.Bucket(DownloadsBucket)
+	if b == nil {
+		return 0
+	}
+	return get(b, path)
+}
+
+// Move transfers the download count of a file which has been renamed,
+// including the downloads not flushed yet. These are lost if tx is rolled back.
+func Move(tx *bolt.Tx, from, to string) error {
+	b, err := tx.CreateBucketIfNotExists(DownloadsBucket)
+	if err != nil {
+		return err
+	}
+	n := get(b, from) + take(tx, from)
+	if n == 0 {
+		return nil
+	}
+	if err := b.Delete([]byte(from)); err != nil {
+		return err
+	}
+	return put(b, to, n)
+}
+
+// Delete removes the download count of a file,
+// including the downloads not flushed yet
+func Delete(tx *bolt.Tx, path string) error {
+	take(tx, path)
+	b := tx.Bucket(DownloadsBucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(path))
+}
+
+// entries is a min-heap of entries ordered by download count
+type entries []Entry
+
+func (e entries) Len() int            { return len(e) }
+func (e entries) Less(i, j int) bool  { return e[i].Downloads < e[j].Downloads }
+func (e entries) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *entries) Push(x interface{}) { *e = append(*e, x.(Entry)) }
+func (e *entries) Pop() interface{} {
+	old := *e
+	x := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return x
+}
+
+// Top returns the n most downloaded files accepted by filter, most downloaded first
+func Top(tx *bolt.Tx, n int, filter func(path string) bool) []Entry {
+	top := make(entries, 0, n+1)
+	b := tx.Bucket(DownloadsBucket)
+	if b == nil {
+		return top
+	}
+	b.ForEach(func(k, v []byte) error {
+		if len(v) != 8 {
+			return nil
+		}
+		e := Entry{Path: string(k), Downloads: binary.BigEndian.Uint64(v)}
+		if len(top) == n && e.Downloads <= top[0].Downloads {
+			return nil
+		}
+		if !filter(e.Path) {
+			return nil
+		}
+		heap.Push(&top, e)
+		if len(top) > n {
+			heap.Pop(&top)
+		}
+		return nil
+	})
+	sorted := make([]Entry, len(top))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(&top).(Entry)
+	}
+	return sorted
+}
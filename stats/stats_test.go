@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestCounter(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	c := NewCounter(db)
+	for i := 0; i < 10; i++ {
+		for j := 0; j <= i; j++ {
+			c.Hit(fmt.Sprintf("/file%d", i))
+		}
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if n := c.Downloads(tx, "/file3"); n != 4 {
+			t.Errorf("expected 4 pending downloads, got %d", n)
+		}
+		return nil
+	})
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	c.Hit("/file0")
+	c.Flush()
+
+	db.View(func(tx *bolt.Tx) error {
+		if n := Downloads(tx, "/file0"); n != 2 {
+			t.Errorf("expected 2 downloads after two flushes, got %d", n)
+		}
+		top := Top(tx, 3, func(path string) bool { return !strings.HasSuffix(path, "8") })
+		if len(top) != 3 || top[0].Path != "/file9" || top[1].Path != "/file7" || top[2].Path != "/file6" {
+			t.Errorf("unexpected top files %v", top)
+		}
+		return nil
+	})
+}
+
+func TestCounterMoveDelete(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	c := NewCounter(db)
+	c.Hit("/a")
+	c.Hit("/b")
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	c.Hit("/a")
+	c.Hit("/b")
+	c.Hit("/b")
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := Delete(tx, "/a"); err != nil {
+			return err
+		}
+		return Move(tx, "/b", "/c")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		for path, want := range map[string]uint64{"/a": 0, "/b": 0, "/c": 3} {
+			if n := c.Downloads(tx, path); n != want {
+				t.Errorf("expected %d downloads of %s, got %d", want, path, n)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,157 @@
+// Package takedown stores the copyright notices asking to take files down
+// and the counter-notices of their uploaders
+package takedown
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/pending"
+)
+
+var (
+	// NoticesBucket maps the big endian id of a notice to the Notice
+	NoticesBucket = []byte("takedown_notices")
+	// PendingNoticesBucket maps tokens to the notices waiting for the requester to confirm them
+	PendingNoticesBucket = []byte("pending_takedown_notices")
+	// PendingCounterBucket maps tokens to the counter-notices waiting for the uploader to confirm them
+	PendingCounterBucket = []byte("pending_counter_notices")
+)
+
+// States of a notice
+const (
+	// Received notices wait for an admin to review them
+	Received = "received"
+	// Applied notices took their files down
+	Applied = "applied"
+	// Rejected notices were not considered valid
+	Rejected = "rejected"
+)
+
+// A Notice asks to take down files infringing a copyright,
+// its fields are the ones required by the terms of service
+type Notice struct {
+	ID uint64
+
+	// Name is the name of the copyright owner, or of the person acting on their behalf,
+	// typed as electronic signature
+	Name string
+	// Address, Phone and Email are the contact information of the requester
+	Address string
+	Phone   string
+	Email   string
+	// Work identifies the copyrighted work claimed to be infringed
+	Work string
+	// Paths identify the infringing files
+	Paths []string
+	// GoodFaith and Accurate record the statements required from the requester
+	GoodFaith bool
+	Accurate  bool
+
+	CreatedAt time.Time
+	// Status is one of Received, Applied and Rejected
+	Status string
+	// ReviewedBy is the email of the admin who applied or rejected the notice
+	ReviewedBy string
+	ReviewedAt time.Time
+}
+
+// A CounterNotice is the answer of the uploader of a file taken down
+type CounterNotice struct {
+	Path string
+	// Name is the name of the uploader, typed as electronic signature
+	Name  string
+	Email string
+	// Statement explains why the file was taken down by mistake
+	Statement string
+	CreatedAt time.Time
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// Add stores n with a new id, which is returned
+func Add(tx *bolt.Tx, n Notice) (uint64, error) {
+	b, err := tx.CreateBucketIfNotExists(NoticesBucket)
+	if err != nil {
+		return 0, err
+	}
+	if n.ID, err = b.NextSequence(); err != nil {
+		return 0, err
+	}
+	return n.ID, Put(tx, n)
+}
+
+// Put stores n, replacing the notice with the same id
+func Put(tx *bolt.Tx, n Notice) error {
+	b, err := tx.CreateBucketIfNotExists(NoticesBucket)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(n.ID), v)
+}
+
+// Get returns the notice id, found is false if there is none
+func Get(tx *bolt.Tx, id uint64) (n Notice, found bool, err error) {
+	b := tx.Bucket(NoticesBucket)
+	if b == nil {
+		return n, false, nil
+	}
+	v := b.Get(key(id))
+	if v == nil {
+		return n, false, nil
+	}
+	return n, true, json.Unmarshal(v, &n)
+}
+
+// List returns every notice, newest first
+func List(tx *bolt.Tx) ([]Notice, error) {
+	list := make([]Notice, 0)
+	b := tx.Bucket(NoticesBucket)
+	if b == nil {
+		return list, nil
+	}
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		n := Notice{}
+		if err := json.Unmarshal(v, &n); err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// AddPending stores a notice until the requester confirms it, returning the confirmation token
+func AddPending(tx *bolt.Tx, n Notice) (string, error) {
+	return pending.Put(tx, PendingNoticesBucket, n)
+}
+
+// TakePending removes and returns the notice waiting for confirmation with token,
+// found is false if there is none
+func TakePending(tx *bolt.Tx, token string) (n Notice, found bool, err error) {
+	found, err = pending.Take(tx, PendingNoticesBucket, token, &n)
+	return n, found, err
+}
+
+// AddPendingCounter stores a counter-notice until the uploader confirms it,
+// returning the confirmation token
+func AddPendingCounter(tx *bolt.Tx, cn CounterNotice) (string, error) {
+	return pending.Put(tx, PendingCounterBucket, cn)
+}
+
+// TakePendingCounter removes and returns the counter-notice waiting for confirmation with token,
+// found is false if there is none
+func TakePendingCounter(tx *bolt.Tx, token string) (cn CounterNotice, found bool, err error) {
+	found, err = pending.Take(tx, PendingCounterBucket, token, &cn)
+	return cn, found, err
+}
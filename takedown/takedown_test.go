@@ -0,0 +1,49 @@
+package takedown
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestAddList(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, work := range []string{"first", "second", "third"} {
+			if _, err := Add(tx, Notice{Work: work, Status: Received}); err != nil {
+				return err
+			}
+		}
+		n, found, err := Get(tx, 2)
+		if err != nil || !found {
+			t.Fatalf("notice 2 not found: %v", err)
+		}
+		n.Status = Applied
+		return Put(tx, n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		list, err := List(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 3 {
+			t.Fatalf("expected 3 notices, got %d", len(list))
+		}
+		for i, want := range []struct {
+			id     uint64
+			status string
+		}{{3, Received}, {2, Applied}, {1, Received}} {
+			if list[i].ID != want.id || list[i].Status != want.status {
+				t.Errorf("notice %d: expected %d %s, got %d %s", i, want.id, want.status, list[i].ID, list[i].Status)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,239 @@
+// Package thumbs generates and caches on disk the thumbnails of uploaded images
+package thumbs
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	// register the supported formats
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+const (
+	// MaxPixels is the size of the largest image a thumbnail is generated for,
+	// larger images would take too much memory to decode: each pixel takes up
+	// to 8 bytes between the decoded image and its copy on a white background,
+	// about 130MB per worker
+	MaxPixels = 16 * 1000 * 1000
+
+	// Small is the size of the thumbnails shown in listings
+	Small = 64
+	// Large is the size of the thumbnails shown in previews
+	Large = 800
+)
+
+var (
+	// ErrUnsupported is returned for files which are not images
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images larger than MaxPixels
+	ErrTooLarge = errors.New("image too large")
+)
+
+// Supported reports whether a thumbnail can be generated for a file named name
+func Supported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// A Cache stores the thumbnails in a directory
+type Cache struct {
+	dir string
+	// sem limits the number of images decoded at the same time
+	sem chan struct{}
+}
+
+// caches are the Caches of the files of each database, so that Delete and Move
+// can remove the thumbnails of the files which are gone
+var caches = struct {
+	sync.Mutex
+	m map[*bolt.DB]*Cache
+}{m: make(map[*bolt.DB]*Cache)}
+
+// New returns a Cache storing the thumbnails of the files of db in dir, which is
+// created if needed. It replaces the previous Cache of db.
+// At most workers thumbnails are generated concurrently.
+func New(db *bolt.DB, dir string, workers int) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir: dir,
+		sem: make(chan struct{}, workers),
+	}
+	caches.Lock()
+	caches.m[db] = c
+	caches.Unlock()
+	return c, nil
+}
+
+// prefix is shared by the thumbnails of all the versions of a file
+func prefix(name string) string {
+	h := sha1.Sum([]byte(name))
+	return hex.EncodeToString(h[:])
+}
+
+// key identifies the thumbnail of a specific version of a file
+func key(name string, dbf fs.DBFile, size int) string {
+	return fmt.Sprintf("%s-%d-%d-%d.jpg", prefix(name), dbf.ModTime.UnixNano(), dbf.Size, size)
+}
+
+// Remove deletes the thumbnails of every version of the file name
+func (c *Cache) Remove(name string) error {
+	thumbs, err := filepath.Glob(filepath.Join(c.dir, prefix(name)+"-*.jpg"))
+	if err != nil {
+		return err
+	}
+	for _, t := range thumbs {
+		if err := os.Remove(t); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the thumbnails of a file from the Cache of the database of tx,
+// if any. They are not restored if tx is rolled back, but generated again.
+func Delete(tx *bolt.Tx, name string) error {
+	caches.Lock()
+	c := caches.m[tx.DB()]
+	caches.Unlock()
+	if c == nil {
+		return nil
+	}
+	return c.Remove(name)
+}
+
+// Move removes the thumbnails of a file which has been renamed,
+// they are generated again under the new name
+func Move(tx *bolt.Tx, from, to string) error {
+	return Delete(tx, from)
+}
+
+// Get returns the path on disk of the thumbnail of the file name in dir,
+// at most size pixels wide and high, generating it if it is not cached.
+func (c *Cache) Get(dir fs.Dir, name string, dbf fs.DBFile, size int) (string, error) {
+	if !Supported(dbf.Name) {
+		return "", ErrUnsupported
+	}
+	dst := filepath.Join(c.dir, key(name, dbf, size))
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	// someone else may have generated it while we were waiting
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+
+	f, err := dir.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return "", ErrTooLarge
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	// write to a temporary file and rename it, so that readers never see partial thumbnails
+	tmp, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = jpeg.Encode(tmp, Resize(img, size), &jpeg.Options{Quality: 80})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return dst, os.Rename(tmp.Name(), dst)
+}
+
+// Resize scales img so that it fits in a size x size square, averaging the
+// source pixels covered by each destination pixel. Transparent areas are
+// painted white. Images already small enough keep their size.
+func Resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, h*size/w
+		} else {
+			dw, dh = w*size/h, size
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, (x+1)*w/dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(row[sx*4])
+					g += uint32(row[sx*4+1])
+					bl += uint32(row[sx*4+2])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
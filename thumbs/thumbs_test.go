@@ -0,0 +1,91 @@
+package thumbs
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/internal/dbtest"
+)
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			if x < 200 {
+				img.Set(x, y, color.Black)
+			}
+			// the right half is left transparent
+		}
+	}
+	thumb := Resize(img, 64)
+	if b := thumb.Bounds(); b.Dx() != 64 || b.Dy() != 16 {
+		t.Fatalf("expected a 64x16 thumbnail, got %dx%d", b.Dx(), b.Dy())
+	}
+	if r, _, _, _ := thumb.At(0, 0).RGBA(); r != 0 {
+		t.Errorf("expected black on the left, got %v", thumb.At(0, 0))
+	}
+	if r, _, _, _ := thumb.At(63, 15).RGBA(); r != 0xffff {
+		t.Errorf("expected transparency painted white, got %v", thumb.At(63, 15))
+	}
+
+	small := image.NewRGBA(image.Rect(0, 0, 10, 20))
+	if b := Resize(small, 64).Bounds(); b.Dx() != 10 || b.Dy() != 20 {
+		t.Errorf("small images should not be enlarged, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+	dir, err := ioutil.TempDir("", "thumbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := New(db, filepath.Join(dir, "cache"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := fs.Dir(filepath.Join(dir, "files"))
+	os.Mkdir(string(files), 0755)
+	dbf := fs.DBFile{Name: "a.png", ModTime: time.Now(), Size: 1}
+	for _, name := range []string{"/a.png", "/b.png"} {
+		f, err := os.Create(filepath.Join(string(files), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(f, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+		f.Close()
+		for _, size := range []int{Small, Large} {
+			if _, err := c.Get(files, name, dbf, size); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return Delete(tx, "/a.png")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, _ := filepath.Glob(filepath.Join(dir, "cache", "*"))
+	if len(left) != 2 {
+		t.Fatalf("expected two thumbnails to be left, got %v", left)
+	}
+	for _, l := range left {
+		if !strings.HasPrefix(filepath.Base(l), prefix("/b.png")) {
+			t.Errorf("expected only the thumbnails of /b.png to be left, got %s", l)
+		}
+	}
+}
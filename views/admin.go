@@ -0,0 +1,756 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/pending"
+	"github.com/socialnotes/mirror/reports"
+	"github.com/socialnotes/mirror/search"
+	"github.com/socialnotes/mirror/takedown"
+)
+
+const (
+	// adminSessionCookie is the name of the cookie holding the token of an admin session
+	adminSessionCookie = "admin_session"
+	// adminLoginExpiry is the time an admin has to follow the sign in link
+	adminLoginExpiry = time.Hour
+	// adminSessionExpiry is the duration of an admin session
+	adminSessionExpiry = 12 * time.Hour
+)
+
+var (
+	// adminLoginsBucket maps tokens to the sign in links waiting for the admin to follow them
+	adminLoginsBucket = []byte("pending_admin_logins")
+	// adminSessionsBucket maps the tokens of the signed in admins to their adminSession
+	adminSessionsBucket = []byte("admin_sessions")
+
+	errBadAction  = errors.New("unknown action")
+	errNotWaiting = errors.New("not awaiting approval")
+)
+
+// An adminSession identifies a signed in admin
+type adminSession struct {
+	Email     string
+	CreatedAt time.Time
+	// CSRF must be sent along every form, so that other sites cannot submit them
+	CSRF string
+}
+
+// adminReported is a file along with the reports it received
+type adminReported struct {
+	Path    string
+	File    fs.DBFile
+	Reports []reports.Report
+	// New counts the reports received after the last review
+	New int
+}
+
+// adminQueued is a file or a revision waiting for a moderator
+type adminQueued struct {
+	Path string
+	File fs.DBFile
+	// Revision is nil when the file itself waits for approval
+	Revision *fs.DBRevision
+}
+
+// NewAdminHandler returns an AdminHandler, moderators can sign in
+// but only approve or reject the uploads waiting for them
+func NewAdminHandler(fs fs.Dir, ts *Templates, db *bolt.DB, m *mailer.M, ex *search.Extractor, admins, moderators []string, prefix string) *AdminHandler {
+	return &AdminHandler{
+		fs: fs,
+		ts: ts,
+		db: db,
+		m:  m,
+		ex: ex,
+
+		admins:     admins,
+		moderators: moderators,
+		prefix:     prefix,
+	}
+}
+
+// AdminHandler serves the admin dashboard. Admins sign in through a link sent
+// to one of the configured addresses, the actions of the dashboard are applied
+// right away with the same operations as the confirmation of the public forms.
+type AdminHandler struct {
+	fs fs.Dir
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+	ex *search.Extractor
+
+	admins     []string
+	moderators []string
+	prefix     string
+}
+
+// canSignIn reports whether email belongs to an admin or to a moderator
+func (ah *AdminHandler) canSignIn(email string) bool {
+	return isAdmin(ah.admins, email) || isAdmin(ah.moderators, email)
+}
+
+// session returns the session of the admin who sent req,
+// ok is false if there is none or it has expired
+func (ah *AdminHandler) session(req *http.Request) (s adminSession, ok bool, err error) {
+	c, err := req.Cookie(adminSessionCookie)
+	if err != nil {
+		return s, false, nil
+	}
+	err = ah.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(adminSessionsBucket)
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(c.Value))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &s)
+	})
+	// admins removed from the configuration lose their sessions
+	ok = ok && time.Since(s.CreatedAt) < adminSessionExpiry && ah.canSignIn(s.Email)
+	return s, ok, err
+}
+
+// setCookie stores the session token in the browser, an empty token removes it
+func (ah *AdminHandler) setCookie(rw http.ResponseWriter, req *http.Request, token string) {
+	maxAge := int(adminSessionExpiry / time.Second)
+	if token == "" {
+		maxAge = -1
+	}
+	http.SetCookie(rw, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    token,
+		Path:     ah.prefix + "/",
+		MaxAge:   maxAge,
+		Secure:   req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// login sends the sign in links and starts the sessions of the admins following them
+func (ah *AdminHandler) login(rw http.ResponseWriter, req *http.Request) error {
+	sent := ""
+	switch {
+	case req.Method == "POST":
+		ma, err := mail.ParseAddress(req.FormValue("email"))
+		if err != nil {
+			ah.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+			return nil
+		}
+		email := strings.ToLower(ma.Address)
+		// the answer is the same for everyone, so that it does not tell who the admins are
+		sent = "If the address belongs to an admin you will receive an email with the link to sign in."
+		if !ah.canSignIn(email) {
+			log.Printf("[warn] sign in to the admin area attempted by %s\n", email)
+			break
+		}
+		var token string
+		err = ah.db.Update(func(tx *bolt.Tx) error {
+			var err error
+			token, err = pending.Put(tx, adminLoginsBucket, adminSession{Email: email, CreatedAt: time.Now()})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		go func() {
+			link := (&url.URL{Path: ah.prefix + "/login", RawQuery: "token=" + token}).String()
+			if err := ah.m.ConfirmLogin(email, link); err != nil {
+				log.Printf("[err] sending the sign in link to %s: %s\n", email, err)
+			}
+		}()
+
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+
+	case req.FormValue("token") != "":
+		s := adminSession{}
+		session := uuid.Must(uuid.NewV4()).String()
+		found := false
+		err := ah.db.Update(func(tx *bolt.Tx) error {
+			var err error
+			found, err = pending.Take(tx, adminLoginsBucket, req.FormValue("token"), &s)
+			if err != nil || !found || time.Since(s.CreatedAt) > adminLoginExpiry {
+				found = false
+				return err
+			}
+			b, err := tx.CreateBucketIfNotExists(adminSessionsBucket)
+			if err != nil {
+				return err
+			}
+			// forget the expired sessions
+			var expired [][]byte
+			err = b.ForEach(func(k, v []byte) error {
+				old := adminSession{}
+				if err := json.Unmarshal(v, &old); err != nil {
+					return err
+				}
+				if time.Since(old.CreatedAt) > adminSessionExpiry {
+					expired = append(expired, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			s.CreatedAt = time.Now()
+			s.CSRF = uuid.Must(uuid.NewV4()).String()
+			v, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(session), v)
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			ah.ts.Error(rw, http.StatusNotFound, "The link does not exist, it has expired or it has already been used.")
+			return nil
+		}
+		log.Printf("[info] %s signed in to the admin area\n", s.Email)
+		ah.setCookie(rw, req, session)
+		http.Redirect(rw, req, ah.prefix+"/", http.StatusSeeOther)
+		return nil
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ah.ts.Render(rw, "admin_login.html", struct {
+		Sent string
+	}{
+		Sent: sent,
+	})
+	return nil
+}
+
+// logout ends the session of the admin who sent req
+func (ah *AdminHandler) logout(rw http.ResponseWriter, req *http.Request) error {
+	c, err := req.Cookie(adminSessionCookie)
+	if err == nil {
+		err = ah.db.Update(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(adminSessionsBucket); b != nil {
+				return b.Delete([]byte(c.Value))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ah.setCookie(rw, req, "")
+	http.Redirect(rw, req, ah.prefix+"/login", http.StatusSeeOther)
+	return nil
+}
+
+// dashboard lists everything waiting for an admin
+func (ah *AdminHandler) dashboard(rw http.ResponseWriter, req *http.Request, s adminSession) error {
+	var (
+		uploads  = make([]treeFile, 0)
+		reported = make([]adminReported, 0)
+		removed  = make([]treeFile, 0)
+		notices  = make([]takedown.Notice, 0)
+		queued   []adminQueued
+	)
+	err := ah.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if queued, err = moderationQueue(tx); err != nil {
+			return err
+		}
+		err = tx.Bucket(fs.FilesBucket).ForEach(func(k, v []byte) error {
+			dbf := fs.DBFile{}
+			if err := json.Unmarshal(v, &dbf); err != nil {
+				return err
+			}
+			switch {
+			case !dbf.Authorized:
+				uploads = append(uploads, treeFile{Path: string(k), File: dbf})
+			case dbf.Takedown != nil:
+				removed = append(removed, treeFile{Path: string(k), File: dbf})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, p := range reports.Reported(tx) {
+			v := tx.Bucket(fs.FilesBucket).Get([]byte(p))
+			if v == nil {
+				continue
+			}
+			r := adminReported{Path: p}
+			if err := json.Unmarshal(v, &r.File); err != nil {
+				return err
+			}
+			if r.Reports, err = reports.List(tx, p); err != nil {
+				return err
+			}
+			if r.New, err = reports.CountSince(tx, p, r.File.ReviewedAt, nil); err != nil {
+				return err
+			}
+			reported = append(reported, r)
+		}
+
+		list, err := takedown.List(tx)
+		if err != nil {
+			return err
+		}
+		for _, n := range list {
+			if n.Status == takedown.Received {
+				notices = append(notices, n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ah.ts.Render(rw, "admin.html", struct {
+		Session  adminSession
+		Done     string
+		Uploads  []treeFile
+		Queued   int
+		Reported []adminReported
+		Removed  []treeFile
+		Notices  []takedown.Notice
+		Failures []mailer.Failure
+	}{
+		Session:  s,
+		Done:     req.FormValue("done"),
+		Uploads:  uploads,
+		Queued:   len(queued),
+		Reported: reported,
+		Removed:  removed,
+		Notices:  notices,
+		Failures: ah.m.Failures(),
+	})
+	return nil
+}
+
+// moderationQueue returns the confirmed files and revisions waiting for a moderator
+func moderationQueue(tx *bolt.Tx) ([]adminQueued, error) {
+	queued := make([]adminQueued, 0)
+	files := tx.Bucket(fs.FilesBucket)
+	err := files.ForEach(func(k, v []byte) error {
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		if dbf.Authorized && dbf.AwaitingApproval {
+			queued = append(queued, adminQueued{Path: string(k), File: dbf})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b := tx.Bucket(fs.RevisionsBucket)
+	if b == nil {
+		return queued, nil
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		r := &fs.DBRevision{}
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		if !r.Authorized || !r.AwaitingApproval {
+			return nil
+		}
+		// keys are the path, a zero byte and the revision number
+		q := adminQueued{Path: string(k[:len(k)-9]), Revision: r}
+		v = files.Get([]byte(q.Path))
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &q.File); err != nil {
+			return err
+		}
+		queued = append(queued, q)
+		return nil
+	})
+	return queued, err
+}
+
+// moderation lists the uploads waiting for a moderator
+func (ah *AdminHandler) moderation(rw http.ResponseWriter, req *http.Request, s adminSession) error {
+	var queued []adminQueued
+	err := ah.db.View(func(tx *bolt.Tx) error {
+		var err error
+		queued, err = moderationQueue(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ah.ts.Render(rw, "admin_moderation.html", struct {
+		Session adminSession
+		Admin   bool
+		Done    string
+		Queued  []adminQueued
+	}{
+		Session: s,
+		Admin:   isAdmin(ah.admins, s.Email),
+		Done:    req.FormValue("done"),
+		Queued:  queued,
+	})
+	return nil
+}
+
+// awaitingApproval reports whether the file at name, or its revision n if it is not zero,
+// is confirmed and waits for a moderator
+func awaitingApproval(tx *bolt.Tx, name string, n uint64) (bool, error) {
+	if n != 0 {
+		r, found, err := fs.GetRevision(tx, name, n)
+		return found && r.Authorized && r.AwaitingApproval, err
+	}
+	v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+	if v == nil {
+		return false, nil
+	}
+	dbf := fs.DBFile{}
+	if err := json.Unmarshal(v, &dbf); err != nil {
+		return false, err
+	}
+	return dbf.Authorized && dbf.AwaitingApproval, nil
+}
+
+// content serves the file at name, or its revision rev, so that moderators
+// can read the uploads which are not published yet. Moderators can only read
+// the uploads waiting for their approval.
+func (ah *AdminHandler) content(rw http.ResponseWriter, req *http.Request, s adminSession, name string) error {
+	stored, n := name, uint64(0)
+	if rev := req.FormValue("rev"); rev != "" {
+		var err error
+		if n, err = strconv.ParseUint(rev, 10, 64); err != nil {
+			ah.ts.Error(rw, http.StatusBadRequest, "The revision is not valid.")
+			return nil
+		}
+		stored = fs.RevisionPath(name, n)
+	}
+	if !isAdmin(ah.admins, s.Email) {
+		waiting := false
+		err := ah.db.View(func(tx *bolt.Tx) error {
+			var err error
+			waiting, err = awaitingApproval(tx, name, n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !waiting {
+			log.Printf("[warn] moderator %s refused the content of %s, it does not wait for approval\n", s.Email, stored)
+			ah.ts.Error(rw, http.StatusForbidden, "Moderators can only read the uploads waiting for approval.")
+			return nil
+		}
+	}
+	f, err := ah.fs.Open(stored)
+	if os.IsNotExist(err) {
+		ah.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// uploads are served from the admin area, they must not run scripts in it
+	rw.Header().Set("Content-Security-Policy", "sandbox")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(rw, req, path.Base(name), fi.ModTime(), f)
+	return nil
+}
+
+// moderate approves or rejects the file at name, or its revision rev,
+// if it is still waiting for a moderator
+func (ah *AdminHandler) moderate(tx *bolt.Tx, files *Files, s adminSession, name, action, rev string) error {
+	if rev == "" {
+		v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+		if v == nil {
+			return errNoFile
+		}
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		if !dbf.Authorized || !dbf.AwaitingApproval {
+			return errNotWaiting
+		}
+		if action == "approve" {
+			_, err := ApproveFile(tx, name, s.Email)
+			return err
+		}
+		return DeleteFile(tx, files, name)
+	}
+
+	n, err := strconv.ParseUint(rev, 10, 64)
+	if err != nil {
+		return errBadAction
+	}
+	r, found, err := fs.GetRevision(tx, name, n)
+	if err != nil || !found {
+		if err == nil {
+			err = errNoFile
+		}
+		return err
+	}
+	if !r.Authorized || !r.AwaitingApproval {
+		return errNotWaiting
+	}
+	if action == "approve" {
+		_, err = ApproveRevision(tx, files, name, n, s.Email)
+		return err
+	}
+	return RejectRevision(tx, files, name, n)
+}
+
+// file shows the record of the file at name with the forms changing it
+func (ah *AdminHandler) file(rw http.ResponseWriter, req *http.Request, s adminSession, name string) error {
+	var (
+		dbf   fs.DBFile
+		found bool
+		list  []reports.Report
+		revs  []fs.DBRevision
+	)
+	err := ah.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		found = true
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		var err error
+		if list, err = reports.List(tx, name); err != nil {
+			return err
+		}
+		revs, err = fs.Revisions(tx, name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		ah.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ah.ts.Render(rw, "admin_file.html", struct {
+		Session   adminSession
+		Done      string
+		Path      string
+		File      fs.DBFile
+		Tags      string
+		Types     []string
+		Reports   []reports.Report
+		Revisions []fs.DBRevision
+	}{
+		Session:   s,
+		Done:      req.FormValue("done"),
+		Path:      name,
+		File:      dbf,
+		Tags:      strings.Join(dbf.Tags, ", "),
+		Types:     fs.DocumentTypes,
+		Reports:   list,
+		Revisions: revs,
+	})
+	return nil
+}
+
+// act applies the action submitted by an admin and goes back to the page of the form
+func (ah *AdminHandler) act(rw http.ResponseWriter, req *http.Request, s adminSession) error {
+	name := path.Clean("/" + req.FormValue("path"))
+	action := req.FormValue("action")
+	back, done := name, ""
+
+	var meta fs.Metadata
+	if action == "metadata" {
+		var err error
+		if meta, err = parseMetadata(req); err != nil {
+			ah.ts.Error(rw, http.StatusBadRequest, "The metadata are not valid: "+err.Error())
+			return nil
+		}
+	}
+
+	e := newEntry(req, s.Email, action, name)
+	if action == "move" {
+		e.To = path.Clean("/" + req.FormValue("to"))
+	}
+	if rev := req.FormValue("rev"); rev != "" {
+		e.Details = "revision " + rev
+	}
+	err := UpdateFiles(ah.db, ah.fs, func(tx *bolt.Tx, files *Files) error {
+		return audit.Change(tx, e, func() error {
+			var err error
+			switch action {
+			case "authorize":
+				_, err = AuthorizeFile(tx, name)
+				done = "Authorized " + name
+			case "revoke":
+				_, err = RevokeFile(tx, name)
+				done = "Revoked " + name
+			case "delete":
+				err = DeleteFile(tx, files, name)
+				back, done = "", "Deleted "+name
+			case fs.ReviewHide, fs.ReviewRestore:
+				_, err = ReviewFile(tx, name, action)
+				done = map[string]string{fs.ReviewHide: "Hid ", fs.ReviewRestore: "Restored "}[action] + name
+			case "metadata":
+				err = updateFile(tx, name, func(dbf *fs.DBFile) error {
+					dbf.Metadata = meta
+					return nil
+				})
+				done = "Saved the metadata of " + name
+			case "move":
+				err = MoveFile(tx, files, name, e.To)
+				back, done = e.To, "Moved "+name+" to "+e.To
+			case "approve", "reject":
+				err = ah.moderate(tx, files, s, name, action, req.FormValue("rev"))
+				done = map[string]string{"approve": "Approved ", "reject": "Rejected "}[action] + name
+			default:
+				err = errBadAction
+			}
+			return err
+		})
+	})
+	switch err {
+	case nil:
+	case errNoFile:
+		ah.ts.Error(rw, http.StatusNotFound, "The file does not exist anymore.")
+		return nil
+	case errNoDirectory:
+		ah.ts.Error(rw, http.StatusBadRequest, "The destination directory does not exist.")
+		return nil
+	case errFileExists:
+		ah.ts.Error(rw, http.StatusConflict, "A file or directory with the same name already exists.")
+		return nil
+	case errBadAction:
+		ah.ts.Error(rw, http.StatusBadRequest, "The action is not valid.")
+		return nil
+	case errNotWaiting:
+		ah.ts.Error(rw, http.StatusConflict, "The upload is not waiting for approval anymore.")
+		return nil
+	case errNotPublished:
+		ah.ts.Error(rw, http.StatusConflict, "The file is hidden or taken down, restore it before approving its revisions.")
+		return nil
+	default:
+		return err
+	}
+	log.Printf("[info] admin %s: %s %s\n", s.Email, action, name)
+	if action == "authorize" || action == "approve" {
+		ah.ex.Enqueue(name)
+	}
+
+	switch {
+	case req.FormValue("back") == "moderation":
+		back = ah.prefix + "/moderation"
+	case req.FormValue("back") != "file" || back == "":
+		back = ah.prefix + "/"
+	default:
+		back = ah.prefix + "/file" + back
+	}
+	http.Redirect(rw, req, (&url.URL{Path: back, RawQuery: url.Values{"done": {done}}.Encode()}).String(), http.StatusSeeOther)
+	return nil
+}
+
+func (ah *AdminHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := strings.TrimPrefix(req.URL.Path, ah.prefix)
+	rw.Header().Set("Cache-Control", "no-store")
+	if name == "/login" {
+		return ah.login(rw, req)
+	}
+
+	s, ok, err := ah.session(req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if req.Method == "GET" {
+			http.Redirect(rw, req, ah.prefix+"/login", http.StatusSeeOther)
+			return nil
+		}
+		ah.ts.Error(rw, http.StatusForbidden, "Please sign in again.")
+		return nil
+	}
+
+	if req.Method == "POST" && req.FormValue("csrf") != s.CSRF {
+		ah.ts.Error(rw, http.StatusForbidden, "The form has expired, please reload the page.")
+		return nil
+	}
+
+	// moderators only see the moderation queue
+	if !isAdmin(ah.admins, s.Email) {
+		action := req.FormValue("action")
+		switch {
+		case name == "/" && req.Method == "GET":
+			http.Redirect(rw, req, ah.prefix+"/moderation", http.StatusSeeOther)
+			return nil
+		case name == "/logout", name == "/moderation", strings.HasPrefix(name, "/content/"):
+		case req.Method == "POST" && (action == "approve" || action == "reject"):
+		default:
+			ah.ts.Error(rw, http.StatusForbidden, "Only admins can do this.")
+			return nil
+		}
+	}
+
+	switch {
+	case name == "/logout" && req.Method == "POST":
+		return ah.logout(rw, req)
+	case name == "/bans" && req.Method == "POST":
+		return ah.ban(rw, req, s)
+	case req.Method == "POST":
+		return ah.act(rw, req, s)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case name == "/":
+		return ah.dashboard(rw, req, s)
+	case name == "/moderation":
+		return ah.moderation(rw, req, s)
+	case name == "/bans":
+		return ah.bans(rw, req, s)
+	case name == "/audit":
+		return ah.auditLog(rw, req, s)
+	case name == "/audit.jsonl":
+		return ah.auditExport(rw, req)
+	case strings.HasPrefix(name, "/content/"):
+		return ah.content(rw, req, s, path.Clean(strings.TrimPrefix(name, "/content")))
+	case strings.HasPrefix(name, "/file/"):
+		return ah.file(rw, req, s, path.Clean(strings.TrimPrefix(name, "/file")))
+	}
+	ah.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	return nil
+}
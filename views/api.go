@@ -0,0 +1,190 @@
+package views
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/recent"
+)
+
+// apiDirectory is the JSON representation of a directory
+type apiDirectory struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// apiFile is the JSON representation of an authorized file
+type apiFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	URL     string    `json:"url"`
+
+	Course    string   `json:"course,omitempty"`
+	Professor string   `json:"professor,omitempty"`
+	Year      string   `json:"year,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Language  string   `json:"lang,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// apiTree is the JSON representation of the content of a directory
+type apiTree struct {
+	Path        string         `json:"path"`
+	Directories []apiDirectory `json:"directories"`
+	Files       []apiFile      `json:"files"`
+}
+
+// apiError is the body returned by the API when a request fails
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// writeJSONError writes a JSON error body with the given status
+func writeJSONError(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(apiError{Status: status, Message: message})
+}
+
+// ToJSONHandler works like ToHandler but reports errors as JSON
+// instead of rendering error.html
+func ToJSONHandler(v ViewHandler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		err := v.ServeHTTP(rw, req)
+		if err == nil {
+			return
+		}
+
+		log.Printf("[err] %s\n", err)
+		status := http.StatusInternalServerError
+		if verr, ok := err.(*ViewError); ok {
+			status = verr.Status
+		}
+
+		writeJSONError(rw, status, http.StatusText(status))
+	})
+}
+
+// urlPath escapes a slash separated path so that it can be used as a link
+func urlPath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// serveJSON serializes data and serves it with an ETag derived from the body and,
+// unless modTime is zero, a Last-Modified header, so that clients can use conditional requests.
+// Deletions and moves do not change modTime, the ETag is checked first if the client sends both.
+func serveJSON(rw http.ResponseWriter, req *http.Request, data interface{}, modTime time.Time) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(body)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	http.ServeContent(rw, req, "", modTime, bytes.NewReader(body))
+	return nil
+}
+
+// lastModified returns the time the newest file in the subtree at dir was published
+func lastModified(db *bolt.DB, dir string) (time.Time, error) {
+	var t time.Time
+	err := db.View(func(tx *bolt.Tx) error {
+		entries, _ := recent.Newest(tx, 0, 1, func(p string) bool { return strings.HasPrefix(p, dir) })
+		if len(entries) > 0 {
+			t = entries[0].Time
+		}
+		return nil
+	})
+	return t, err
+}
+
+func NewTreeHandler(db *bolt.DB, prefix string) *TreeHandler {
+	return &TreeHandler{
+		db: db,
+
+		prefix: prefix,
+	}
+}
+
+// TreeHandler serves the content of a directory as JSON
+type TreeHandler struct {
+	db *bolt.DB
+
+	prefix string
+}
+
+func (th *TreeHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		writeJSONError(rw, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return nil
+	}
+
+	dir := path.Clean("/" + strings.TrimPrefix(req.URL.Path, th.prefix))
+	if dir != "/" {
+		dir += "/"
+	}
+
+	_, found, err := getDirectory(th.db, dir)
+	if err != nil {
+		return err
+	}
+	if !found {
+		writeJSONError(rw, http.StatusNotFound, "no such directory")
+		return nil
+	}
+	dirs, files, err := directoryContent(th.db, dir)
+	if err != nil {
+		return err
+	}
+
+	tree := apiTree{
+		Path:        dir,
+		Directories: make([]apiDirectory, 0, len(dirs)),
+		Files:       make([]apiFile, 0, len(files)),
+	}
+	for _, d := range dirs {
+		tree.Directories = append(tree.Directories, apiDirectory{
+			Name: d,
+			URL:  urlPath(th.prefix + dir + d + "/"),
+		})
+	}
+	lastMod, err := lastModified(th.db, dir)
+	if err != nil {
+		return err
+	}
+	filter := parseMetadataFilter(req.URL.Query())
+	for _, f := range files {
+		if !f.Published() || !filter.match(f.Metadata) {
+			continue
+		}
+		if f.ModTime.After(lastMod) {
+			lastMod = f.ModTime
+		}
+		tree.Files = append(tree.Files, apiFile{
+			Name:    f.Name,
+			Size:    f.Size,
+			ModTime: f.ModTime,
+			URL:     urlPath(dir + f.Name),
+
+			Course:    f.Course,
+			Professor: f.Professor,
+			Year:      f.Year,
+			Type:      f.Type,
+			Language:  f.Language,
+			Tags:      f.Tags,
+		})
+	}
+
+	return serveJSON(rw, req, tree, lastMod)
+}
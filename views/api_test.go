@@ -0,0 +1,104 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/internal/dbtest"
+	"github.com/socialnotes/mirror/recent"
+)
+
+func TestTreeHandler(t *testing.T) {
+	db, closeDB := dbtest.Open(t)
+	defer closeDB()
+
+	old := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := db.Update(func(tx *bolt.Tx) error {
+		files, err := tx.CreateBucketIfNotExists(fs.FilesBucket)
+		if err != nil {
+			return err
+		}
+		for name, dbf := range map[string]fs.DBFile{
+			"/Fisica/a.pdf":       {Name: "a.pdf", ModTime: old, Authorized: true, Metadata: fs.Metadata{Course: "145"}},
+			"/Fisica/b.pdf":       {Name: "b.pdf", ModTime: old, Authorized: true},
+			"/Fisica/pending.pdf": {Name: "pending.pdf", ModTime: old.Add(time.Hour)},
+			"/Fisica/sub/c.pdf":   {Name: "c.pdf", ModTime: old, Authorized: true, AuthorizedAt: old.Add(time.Minute)},
+		} {
+			v, _ := json.Marshal(dbf)
+			if err := files.Put([]byte(name), v); err != nil {
+				return err
+			}
+		}
+		for _, dir := range []string{"/", "/Fisica/", "/Fisica/sub/"} {
+			if err := fs.PutDirectory(tx, dir, fs.DBDirectory{}); err != nil {
+				return err
+			}
+		}
+		return recent.Rebuild(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	th := ToJSONHandler(NewTreeHandler(db, "/api/v1/tree"))
+	get := func(url string, header ...string) (*httptest.ResponseRecorder, apiTree) {
+		req := httptest.NewRequest("GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rw := httptest.NewRecorder()
+		th.ServeHTTP(rw, req)
+		tree := apiTree{}
+		if rw.Code == 200 {
+			if err := json.Unmarshal(rw.Body.Bytes(), &tree); err != nil {
+				t.Fatalf("invalid JSON for %s: %s", url, err)
+			}
+		}
+		return rw, tree
+	}
+
+	rw, tree := get("/api/v1/tree/Fisica")
+	if rw.Code != 200 || len(tree.Directories) != 1 || len(tree.Files) != 2 {
+		t.Fatalf("expected one directory and the two published files, got %d %+v", rw.Code, tree)
+	}
+	if tree.Directories[0].URL != "/api/v1/tree/Fisica/sub/" || tree.Files[0].URL != "/Fisica/a.pdf" {
+		t.Errorf("wrong links %+v", tree)
+	}
+	// the newest file of the subtree was published in the subdirectory
+	lastMod := rw.Header().Get("Last-Modified")
+	if want := old.Add(time.Minute).Format(http.TimeFormat); lastMod != want {
+		t.Errorf("expected Last-Modified %s, got %q", want, lastMod)
+	}
+	if rw, _ := get("/api/v1/tree/Fisica/", "If-Modified-Since", lastMod); rw.Code != 304 {
+		t.Errorf("expected 304 if not modified since, got %d", rw.Code)
+	}
+	if rw, _ := get("/api/v1/tree/Fisica/", "If-Modified-Since", old.Format(http.TimeFormat)); rw.Code != 200 {
+		t.Errorf("expected 200 if modified since, got %d", rw.Code)
+	}
+	etag := rw.Header().Get("ETag")
+	if rw, _ := get("/api/v1/tree/Fisica/", "If-None-Match", etag); rw.Code != 304 {
+		t.Errorf("expected 304 for the same ETag, got %d", rw.Code)
+	}
+	if _, tree := get("/api/v1/tree/Fisica/?course=145"); len(tree.Files) != 1 || tree.Files[0].Name != "a.pdf" {
+		t.Errorf("expected the files of course 145, got %+v", tree.Files)
+	}
+	if rw, _ := get("/api/v1/tree/Nope/"); rw.Code != 404 {
+		t.Errorf("expected 404 for a missing directory, got %d", rw.Code)
+	}
+
+	// deleting a file does not change the time of the others, the ETag must change anyway
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(fs.FilesBucket).Delete([]byte("/Fisica/b.pdf"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw, tree := get("/api/v1/tree/Fisica/", "If-None-Match", etag, "If-Modified-Since", lastMod); rw.Code != 200 || len(tree.Files) != 1 {
+		t.Errorf("expected the listing without the deleted file, got %d %+v", rw.Code, tree)
+	}
+}
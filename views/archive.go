@@ -0,0 +1,133 @@
+package views
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+// compressed lists the extensions of formats that do not benefit from deflate
+var compressed = map[string]bool{
+	".zip": true, ".rar": true, ".7z": true, ".gz": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+	".mp3": true, ".mp4": true, ".docx": true, ".pptx": true, ".xlsx": true,
+}
+
+func NewArchiveHandler(fs fs.Dir, ts *Templates, db *bolt.DB, prefix string, maxSize int64, maxFiles int) *ArchiveHandler {
+	return &ArchiveHandler{
+		fs: fs,
+		ts: ts,
+		db: db,
+
+		prefix:   prefix,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+}
+
+// ArchiveHandler streams the published files of a directory as a zip archive
+type ArchiveHandler struct {
+	fs fs.Dir
+	ts *Templates
+	db *bolt.DB
+
+	prefix   string
+	maxSize  int64
+	maxFiles int
+}
+
+func (ah *ArchiveHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	dir := path.Clean("/" + strings.TrimPrefix(req.URL.Path, ah.prefix))
+	if dir != "/" {
+		dir += "/"
+	}
+
+	files, err := publishedFiles(ah.db, dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		ah.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+	total := int64(0)
+	for _, f := range files {
+		total += f.File.Size
+	}
+	if total > ah.maxSize || len(files) > ah.maxFiles {
+		ah.ts.Error(rw, http.StatusRequestEntityTooLarge, fmt.Sprintf(
+			"This directory is too large to be downloaded at once (%d files, %s), the limit is %d files and %s. Try with one of its subdirectories.",
+			len(files), humanizeBytes(total), ah.maxFiles, humanizeBytes(ah.maxSize)))
+		return nil
+	}
+
+	name := path.Base(dir)
+	if name == "/" {
+		name = "socialnotes"
+	}
+	rw.Header().Set("Content-Type", "application/zip")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s.zip", url.PathEscape(name)))
+	rw.WriteHeader(http.StatusOK)
+	if req.Method == "HEAD" {
+		return nil
+	}
+
+	// once the response started errors can only be logged,
+	// the client will notice the truncated archive
+	zw := zip.NewWriter(rw)
+	for _, f := range files {
+		if err := ah.add(zw, dir, f); err != nil {
+			log.Printf("[err] adding %s to archive of %s: %s\n", f.Path, dir, err)
+			if _, ok := err.(*zipWriteError); ok {
+				return nil
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("[err] closing archive of %s: %s\n", dir, err)
+	}
+	return nil
+}
+
+// zipWriteError is returned when writing to the client failed
+type zipWriteError struct {
+	err error
+}
+
+func (z *zipWriteError) Error() string {
+	return z.err.Error()
+}
+
+// add writes a single file to the archive, naming it relative to dir
+func (ah *ArchiveHandler) add(zw *zip.Writer, dir string, f treeFile) error {
+	fsf, err := ah.fs.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer fsf.Close()
+
+	hdr := &zip.FileHeader{
+		Name:     strings.TrimPrefix(f.Path, dir),
+		Method:   zip.Deflate,
+		Modified: f.File.ModTime,
+	}
+	if compressed[strings.ToLower(path.Ext(f.Path))] {
+		hdr.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return &zipWriteError{err}
+	}
+	if _, err := io.Copy(w, fsf); err != nil {
+		return &zipWriteError{err}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package views
+
+import (
+	"bufio"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+)
+
+// auditPageSize is the number of entries shown on each page of the audit log
+const auditPageSize = 100
+
+// clientIP returns the address of the client who sent req
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// newEntry returns the audit log entry of action on the file at name,
+// empty for bans, made by actor with req
+func newEntry(req *http.Request, actor, action, name string) audit.Entry {
+	return audit.Entry{
+		Action: action,
+		Actor:  actor,
+		IP:     clientIP(req),
+		Path:   name,
+	}
+}
+
+// auditFilter reads the filter of the audit log from the query of req,
+// dates are days in the form 2006-01-02 and until is included
+func auditFilter(req *http.Request) (audit.Filter, error) {
+	f := audit.Filter{
+		Action: req.FormValue("action"),
+		Actor:  req.FormValue("actor"),
+		Path:   req.FormValue("path"),
+	}
+	if since := req.FormValue("since"); since != "" {
+		t, err := time.ParseInLocation("2006-01-02", since, time.Local)
+		if err != nil {
+			return f, err
+		}
+		f.Since = t
+	}
+	if until := req.FormValue("until"); until != "" {
+		t, err := time.ParseInLocation("2006-01-02", until, time.Local)
+		if err != nil {
+			return f, err
+		}
+		f.Until = t.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+// auditLog lists the entries of the audit log matching the filter in the query, newest first
+func (ah *AdminHandler) auditLog(rw http.ResponseWriter, req *http.Request, s adminSession) error {
+	f, err := auditFilter(req)
+	if err != nil {
+		ah.ts.Error(rw, http.StatusBadRequest, "The dates must be in the form 2006-01-02.")
+		return nil
+	}
+	before, _ := strconv.ParseUint(req.FormValue("before"), 10, 64)
+	var entries []audit.Entry
+	err = ah.db.View(func(tx *bolt.Tx) error {
+		var err error
+		// one more entry tells whether there is a next page
+		entries, err = audit.List(tx, f, before, auditPageSize+1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	next := uint64(0)
+	if len(entries) > auditPageSize {
+		entries = entries[:auditPageSize]
+		next = entries[auditPageSize-1].ID
+	}
+
+	q := req.URL.Query()
+	q.Del("before")
+	rw.WriteHeader(http.StatusOK)
+	ah.ts.Render(rw, "admin_audit.html", struct {
+		Session adminSession
+		Actions []string
+		Filter  audit.Filter
+		Since   string
+		Until   string
+		Query   template.URL
+		Entries []audit.Entry
+		Next    uint64
+	}{
+		Session: s,
+		Actions: audit.Actions,
+		Filter:  f,
+		Since:   req.FormValue("since"),
+		Until:   req.FormValue("until"),
+		Query:   template.URL(q.Encode()),
+		Entries: entries,
+		Next:    next,
+	})
+	return nil
+}
+
+// auditExport writes the entries of the audit log matching the filter in the query
+// as JSON lines, oldest first
+func (ah *AdminHandler) auditExport(rw http.ResponseWriter, req *http.Request) error {
+	f, err := auditFilter(req)
+	if err != nil {
+		ah.ts.Error(rw, http.StatusBadRequest, "The dates must be in the form 2006-01-02.")
+		return nil
+	}
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	w := bufio.NewWriter(rw)
+	enc := json.NewEncoder(w)
+	err = ah.db.View(func(tx *bolt.Tx) error {
+		return audit.Each(tx, f, func(e audit.Entry) error {
+			return enc.Encode(e)
+		})
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	// once the response started errors can only be logged
+	if err != nil {
+		log.Printf("[err] exporting the audit log: %s\n", err)
+	}
+	return nil
+}
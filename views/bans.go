@@ -0,0 +1,161 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/bans"
+)
+
+// bannedError is returned when the address of an uploader is banned
+type bannedError struct {
+	ban bans.Ban
+}
+
+func (e *bannedError) Error() string {
+	return "address banned by " + e.ban.Pattern
+}
+
+// message explains the ban to the uploader
+func (e *bannedError) message() string {
+	msg := "This address is not allowed to upload files"
+	if !e.ban.Expires.IsZero() {
+		msg += " until " + e.ban.Expires.Format("2 January 2006")
+	}
+	if e.ban.Reason != "" {
+		msg += ": " + e.ban.Reason
+	}
+	return msg + "."
+}
+
+// checkBan returns a *bannedError if email is banned
+func checkBan(tx *bolt.Tx, email string) error {
+	b, banned, err := bans.Check(tx, email)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return &bannedError{b}
+	}
+	return nil
+}
+
+// bans lists the bans with the form adding new ones
+func (ah *AdminHandler) bans(rw http.ResponseWriter, req *http.Request, s adminSession) error {
+	var list []bans.Ban
+	err := ah.db.View(func(tx *bolt.Tx) error {
+		var err error
+		list, err = bans.List(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ah.ts.Render(rw, "admin_bans.html", struct {
+		Session adminSession
+		Done    string
+		Bans    []bans.Ban
+		Now     time.Time
+	}{
+		Session: s,
+		Done:    req.FormValue("done"),
+		Bans:    list,
+		Now:     time.Now(),
+	})
+	return nil
+}
+
+// ban adds or removes a ban, hiding or deleting the files uploaded by the banned addresses if asked
+func (ah *AdminHandler) ban(rw http.ResponseWriter, req *http.Request, s adminSession) error {
+	pattern, err := bans.Normalize(req.FormValue("pattern"))
+	if err != nil {
+		ah.ts.Error(rw, http.StatusBadRequest, "The pattern is not valid: "+err.Error())
+		return nil
+	}
+
+	done := ""
+	switch req.FormValue("action") {
+	case "unban":
+		found := false
+		err = ah.db.Update(func(tx *bolt.Tx) error {
+			b, ok, err := bans.Get(tx, pattern)
+			if err != nil || !ok {
+				return err
+			}
+			e := newEntry(req, s.Email, audit.Unban, "")
+			e.Details = b.String()
+			return audit.ChangeBan(tx, e, pattern, func() error {
+				found, err = bans.Delete(tx, pattern)
+				return err
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			ah.ts.Error(rw, http.StatusNotFound, "The ban does not exist anymore.")
+			return nil
+		}
+		log.Printf("[info] admin %s: unban %s\n", s.Email, pattern)
+		done = "Removed the ban of " + pattern
+
+	case "ban":
+		b := bans.Ban{
+			Pattern:   pattern,
+			Reason:    strings.TrimSpace(req.FormValue("reason")),
+			CreatedBy: s.Email,
+			CreatedAt: time.Now(),
+		}
+		if until := req.FormValue("until"); until != "" {
+			t, err := time.ParseInLocation("2006-01-02", until, time.Local)
+			if err != nil {
+				ah.ts.Error(rw, http.StatusBadRequest, "The expiry must be a date in the form 2006-01-02.")
+				return nil
+			}
+			// the ban lasts the whole day
+			b.Expires = t.AddDate(0, 0, 1)
+		}
+		uploads := req.FormValue("uploads")
+		if uploads != "" && uploads != audit.Hide && uploads != audit.Delete {
+			ah.ts.Error(rw, http.StatusBadRequest, "The action on the uploads is not valid.")
+			return nil
+		}
+		var purged []string
+		err = UpdateFiles(ah.db, ah.fs, func(tx *bolt.Tx, files *Files) error {
+			e := newEntry(req, s.Email, audit.Ban, "")
+			e.Details = b.String()
+			if err := audit.ChangeBan(tx, e, pattern, func() error { return bans.Put(tx, b) }); err != nil {
+				return err
+			}
+			if uploads == "" {
+				return nil
+			}
+			var err error
+			purged, err = PurgeUploads(tx, files, b, uploads, newEntry(req, s.Email, uploads, ""))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("[info] admin %s: ban %s, %d files affected\n", s.Email, pattern, len(purged))
+		done = "Banned " + pattern
+		if uploads != "" {
+			done += fmt.Sprintf(", %d files affected", len(purged))
+		}
+
+	default:
+		ah.ts.Error(rw, http.StatusBadRequest, "The action is not valid.")
+		return nil
+	}
+
+	http.Redirect(rw, req, (&url.URL{Path: ah.prefix + "/bans", RawQuery: url.Values{"done": {done}}.Encode()}).String(), http.StatusSeeOther)
+	return nil
+}
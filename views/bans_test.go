@@ -0,0 +1,59 @@
+package views
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/search"
+)
+
+func TestBans(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	ex := search.NewExtractor(env.db, env.dir, 10)
+	ah := ToHandler(NewAdminHandler(env.dir, env.ts, env.db, env.m, ex, []string{"admin@unitn.it"}, nil, "/admin"), env.ts)
+	admin := env.signIn(t, ah, "admin@unitn.it")
+	logged := func(action string) (e audit.Entry) {
+		t.Helper()
+		env.db.View(func(tx *bolt.Tx) error {
+			entries, err := audit.List(tx, audit.Filter{Action: action}, 0, 10)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("expected one %s entry, got %v %+v", action, err, entries)
+			}
+			e = entries[0]
+			return nil
+		})
+		return e
+	}
+
+	rw := admin("POST", "/admin/bans", url.Values{"action": {"ban"}, "pattern": {"*@Spam.unitn.it"}, "reason": {"junk"}, "until": {"2999-01-01"}})
+	if rw.Code != 303 {
+		t.Fatalf("expected the ban to be added, got %d", rw.Code)
+	}
+	e := logged(audit.Ban)
+	if e.Actor != "admin@unitn.it" || e.IP == "" || string(e.Before) != "null" || !strings.Contains(string(e.After), `"junk"`) {
+		t.Errorf("expected the ban to be logged with its record, got %+v", e)
+	}
+	if e.Details != "*@spam.unitn.it, expires 2999-01-02 00:00: junk" {
+		t.Errorf("expected the pattern, the expiry and the reason, got %q", e.Details)
+	}
+
+	if rw := admin("POST", "/admin/bans", url.Values{"action": {"unban"}, "pattern": {"*@spam.unitn.it"}}); rw.Code != 303 {
+		t.Fatalf("expected the ban to be removed, got %d", rw.Code)
+	}
+	e = logged(audit.Unban)
+	if e.Actor != "admin@unitn.it" || string(e.After) != "null" || !strings.Contains(string(e.Before), `"junk"`) || !strings.Contains(e.Details, "junk") {
+		t.Errorf("expected the unban to be logged with the removed ban, got %+v", e)
+	}
+	if rw := admin("POST", "/admin/bans", url.Values{"action": {"unban"}, "pattern": {"*@spam.unitn.it"}}); rw.Code != 404 {
+		t.Errorf("expected a missing ban not to be found, got %d", rw.Code)
+	}
+	logged(audit.Unban)
+
+	if rw := admin("GET", "/admin/audit", nil); rw.Code != 200 || !strings.Contains(rw.Body.String(), "*@spam.unitn.it") {
+		t.Errorf("expected the bans in the audit log, got %d:\n%s", rw.Code, rw.Body)
+	}
+}
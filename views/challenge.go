@@ -0,0 +1,61 @@
+package views
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/socialnotes/mirror/pow"
+)
+
+// ChallengeHandler issues the proof-of-work challenges solved by the upload form,
+// RequireWork verifies them
+type ChallengeHandler struct {
+	issuer *pow.Issuer
+}
+
+// NewChallengeHandler returns a ChallengeHandler, a nil issuer answers
+// 404 Not Found so that the form uploads without solving a challenge
+func NewChallengeHandler(issuer *pow.Issuer) *ChallengeHandler {
+	return &ChallengeHandler{
+		issuer: issuer,
+	}
+}
+
+func (ch *ChallengeHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	if ch.issuer == nil {
+		writeJSONError(rw, http.StatusNotFound, "Challenges are disabled")
+		return nil
+	}
+	if req.Method != "GET" {
+		writeJSONError(rw, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return nil
+	}
+	c, err := ch.issuer.Issue()
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	return json.NewEncoder(rw).Encode(c)
+}
+
+// RequireWork makes the POST requests served by h solve a challenge of issuer, a nil issuer
+// requires nothing. The challenge and its solution are in the pow and pow-solution parameters
+// of the query, so that they are verified before reading the body and before any rate limit.
+func RequireWork(h http.Handler, ts *Templates, issuer *pow.Issuer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if issuer == nil || req.Method != "POST" {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		q := req.URL.Query()
+		if err := issuer.Verify(q.Get("pow"), q.Get("pow-solution")); err != nil {
+			log.Printf("[warn] %s refused: %s\n", req.URL.Path, err)
+			ts.Error(rw, http.StatusForbidden, "The anti-spam check failed ("+err.Error()+"), please reload the page and try again. JavaScript must be enabled to upload files.")
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,52 @@
+package views
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/socialnotes/mirror/pow"
+	"github.com/socialnotes/mirror/ratelimit"
+)
+
+func TestRequireWork(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	issuer, err := pow.NewIssuer(4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := NewRateLimits(ratelimit.Limit{Burst: 2, Per: time.Hour}, ratelimit.Limit{}, ratelimit.Limit{Burst: 1, Per: time.Hour})
+	uh := RequireWork(Limit(ToHandler(NewUploadHandler(env.dir, env.ts, env.db, env.m, false, "/upload"), env.ts), env.ts, rl), env.ts, issuer)
+	solved := func() string {
+		c, err := issuer.Issue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "?" + url.Values{"pow": {c.Token}, "pow-solution": {pow.Solve(c)}}.Encode()
+	}
+
+	// unsolved uploads are refused without using up the limits
+	for _, q := range []string{"", "?pow=x&pow-solution=y"} {
+		if rw := env.upload(t, uh, "/upload/Fisica"+q, "new.txt", "spam", "a@unitn.it"); rw.Code != 403 {
+			t.Errorf("expected the upload without a solution to be refused, got %d", rw.Code)
+		}
+	}
+	q := solved()
+	if rw := env.upload(t, uh, "/upload/Fisica"+q, "new.txt", "notes", "a@unitn.it"); rw.Code != 200 {
+		t.Fatalf("expected the solved upload to be accepted, got %d", rw.Code)
+	}
+	env.mail()
+	if rw := env.upload(t, uh, "/upload/Fisica"+q, "other.txt", "notes", "a@unitn.it"); rw.Code != 403 {
+		t.Errorf("expected a solution to be used once, got %d", rw.Code)
+	}
+	// the global limit of one upload has been used by the solved one
+	if rw := env.upload(t, uh, "/upload/Fisica"+solved(), "other.txt", "notes", "a@unitn.it"); rw.Code != 429 {
+		t.Errorf("expected the global limit to be reached, got %d", rw.Code)
+	}
+	env.noMail(t)
+	// the page of the form is served without a challenge
+	if rw := env.do(uh, "GET", "/upload/Fisica", nil); rw.Code == 403 {
+		t.Errorf("expected GET requests not to need a challenge, got %d", rw.Code)
+	}
+}
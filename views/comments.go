@@ -0,0 +1,263 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/comments"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+)
+
+// maxComment is the length in characters of the longest comment accepted
+const maxComment = 4000
+
+func NewCommentsHandler(ts *Templates, db *bolt.DB, m *mailer.M, admins []string, prefix string) *CommentsHandler {
+	return &CommentsHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		admins: admins,
+		prefix: prefix,
+	}
+}
+
+// CommentsHandler publishes the comments posted on the preview page of a file
+// once their authors confirm them by email, and lets admins and maintainers
+// hide or delete them from the moderation page.
+type CommentsHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	admins []string
+	prefix string
+}
+
+// render shows the moderation page of the thread of the file at name.
+// The page is public, so hidden comments are listed without their text.
+func (ch *CommentsHandler) render(rw http.ResponseWriter, name string, email string) error {
+	var thread []comments.Comment
+	err := ch.db.View(func(tx *bolt.Tx) error {
+		var err error
+		thread, err = comments.List(tx, name, true)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i := range thread {
+		if thread[i].Hidden {
+			thread[i].Text = ""
+		}
+	}
+	rw.WriteHeader(http.StatusOK)
+	ch.ts.Render(rw, "comments.html", struct {
+		Path     string
+		Comments []comments.Comment
+		Sent     bool
+		Email    string
+	}{
+		Path:     name,
+		Comments: thread,
+		Sent:     email != "",
+		Email:    email,
+	})
+	return nil
+}
+
+// post stores a new comment and sends the confirmation email to its author
+func (ch *CommentsHandler) post(rw http.ResponseWriter, req *http.Request, name string) error {
+	email, err := checkEmail(req.FormValue("email"))
+	if err != nil {
+		ch.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid. Remember that only unitn.it email addresses are accepted.")
+		return nil
+	}
+	text := strings.TrimSpace(strings.Replace(req.FormValue("text"), "\r\n", "\n", -1))
+	if text == "" || utf8.RuneCountInString(text) > maxComment {
+		ch.ts.Error(rw, http.StatusBadRequest, fmt.Sprintf("Comments must be between 1 and %d characters long.", maxComment))
+		return nil
+	}
+
+	c := comments.Comment{
+		Path:      name,
+		Email:     strings.ToLower(email),
+		Text:      text,
+		CreatedAt: time.Now(),
+	}
+	var token string
+	err = ch.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, err = comments.AddPending(tx, c)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: ch.prefix + name, RawQuery: "token=" + token}).String()
+		if err := ch.m.ConfirmComment(c.Email, path.Base(name), link); err != nil {
+			log.Printf("[err] sending confirmation email for a comment on %s: %s\n", name, err)
+		}
+	}()
+
+	rw.WriteHeader(http.StatusOK)
+	ch.ts.Render(rw, "comment.html", struct {
+		Path  string
+		Email string
+	}{
+		Path:  name,
+		Email: c.Email,
+	})
+	return nil
+}
+
+// moderate stores a moderation action, submitted as action:id,
+// and sends the confirmation email to the moderator
+func (ch *CommentsHandler) moderate(rw http.ResponseWriter, req *http.Request, name string) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		ch.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	parts := strings.SplitN(req.FormValue("action"), ":", 2)
+	if len(parts) != 2 {
+		ch.ts.Error(rw, http.StatusBadRequest, "The moderation action is not valid.")
+		return nil
+	}
+	action := parts[0]
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || (action != fs.CommentHide && action != fs.CommentShow && action != fs.CommentDelete) {
+		ch.ts.Error(rw, http.StatusBadRequest, "The moderation action is not valid.")
+		return nil
+	}
+
+	edit := fs.DBEdit{
+		Kind:          fs.EditComment,
+		Path:          name,
+		Comment:       id,
+		CommentAction: action,
+		Email:         strings.ToLower(ma.Address),
+		CreatedAt:     time.Now(),
+	}
+	var (
+		token   string
+		allowed bool
+		found   bool
+	)
+	err = ch.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if _, found, err = comments.Get(tx, name, id); err != nil || !found {
+			return err
+		}
+		token, allowed, err = storeEdit(tx, ch.admins, edit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		ch.ts.Error(rw, http.StatusNotFound, "The comment does not exist.")
+		return nil
+	}
+	if allowed {
+		go func() {
+			link := (&url.URL{Path: ch.prefix + name, RawQuery: "token=" + token}).String()
+			what := fmt.Sprintf("comment #%d on %s (%s)", id, name, action)
+			if err := ch.m.ConfirmEdit(edit.Email, what, link); err != nil {
+				log.Printf("[err] sending confirmation email for the moderation of %s: %s\n", name, err)
+			}
+		}()
+	} else {
+		// every address gets the same answer, so that it does not tell who maintains the course
+		log.Printf("[warn] %s is not allowed to moderate the comments on %s\n", edit.Email, name)
+	}
+
+	return ch.render(rw, name, edit.Email)
+}
+
+// confirm publishes the comment, or applies the moderation action, identified by token
+func (ch *CommentsHandler) confirm(rw http.ResponseWriter, req *http.Request, name, token string) error {
+	status, message, redirect, fragment := 0, "", "", ""
+	err := ch.db.Update(func(tx *bolt.Tx) error {
+		c, found, err := comments.TakePending(tx, token)
+		if err != nil {
+			return err
+		}
+		if found {
+			switch {
+			case c.Path != name:
+				status, message = http.StatusBadRequest, "The comment belongs to another file."
+				return nil
+			case time.Since(c.CreatedAt) > editExpiry:
+				status, message = http.StatusGone, "The comment has expired, please post it again."
+				return nil
+			}
+			id, err := comments.Add(tx, c)
+			redirect, fragment = "/preview"+name, fmt.Sprintf("#comment-%d", id)
+			log.Printf("[info] %s commented on %s\n", c.Email, name)
+			return err
+		}
+
+		edit, st, msg, err := takeEdit(tx, ch.admins, token, name, fs.EditComment)
+		if err != nil || st != 0 {
+			status, message = st, msg
+			return err
+		}
+		redirect = ch.prefix + name
+		log.Printf("[info] %s applied %s to comment %d of %s\n", edit.Email, edit.CommentAction, edit.Comment, name)
+		switch edit.CommentAction {
+		case fs.CommentHide, fs.CommentShow:
+			return comments.SetHidden(tx, name, edit.Comment, edit.CommentAction == fs.CommentHide)
+		case fs.CommentDelete:
+			return comments.Remove(tx, name, edit.Comment)
+		}
+		status, message = http.StatusBadRequest, "The edit does not moderate a comment."
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		ch.ts.Error(rw, status, message)
+		return nil
+	}
+	http.Redirect(rw, req, urlPath(redirect)+fragment, http.StatusSeeOther)
+	return nil
+}
+
+func (ch *CommentsHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, ch.prefix))
+	_, found, err := publishedFile(ch.db, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		ch.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST" && req.FormValue("action") != "":
+		return ch.moderate(rw, req, name)
+	case req.Method == "POST":
+		return ch.post(rw, req, name)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return ch.confirm(rw, req, name, req.FormValue("token"))
+	}
+	return ch.render(rw, name, "")
+}
@@ -0,0 +1,59 @@
+package views
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/comments"
+)
+
+func TestCommentsHandler(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	ch := ToHandler(NewCommentsHandler(env.ts, env.db, env.m, []string{"admin@unitn.it"}, "/comments"), env.ts)
+
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		for _, c := range []comments.Comment{
+			{Path: "/Fisica/a.txt", Email: "b@unitn.it", Text: "useful notes", CreatedAt: time.Now()},
+			{Path: "/Fisica/a.txt", Email: "c@unitn.it", Text: "buy cheap pills", CreatedAt: time.Now(), Hidden: true},
+		} {
+			if _, err := comments.Add(tx, c); err != nil {
+				return err
+			}
+		}
+		_, err := comments.AddPending(tx, comments.Comment{Path: "/Fisica/a.txt", Email: "d@unitn.it", Text: "old", CreatedAt: time.Now().Add(-editExpiry - time.Minute)})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := env.do(ch, "GET", "/comments/Fisica/a.txt", nil)
+	if body := rw.Body.String(); rw.Code != 200 || !strings.Contains(body, "useful notes") || strings.Contains(body, "cheap pills") {
+		t.Errorf("expected the text of hidden comments to be left out, got %d:\n%s", rw.Code, body)
+	}
+
+	if rw := env.do(ch, "POST", "/comments/Fisica/a.txt", url.Values{"email": {"nobody@unitn.it"}, "action": {"show:2"}}); rw.Code != 200 {
+		t.Errorf("expected strangers to get the usual answer, got %d", rw.Code)
+	}
+	env.noMail(t)
+	if rw := env.do(ch, "POST", "/comments/Fisica/a.txt", url.Values{"email": {"admin@unitn.it"}, "action": {"show:2"}}); rw.Code != 200 {
+		t.Errorf("expected the moderation to be stored, got %d", rw.Code)
+	}
+	if m, ok := env.mail(); !ok || m.To != "admin@unitn.it" {
+		t.Errorf("expected the confirmation to the admin, got %+v", m)
+	}
+
+	if err := PurgeExpired(env.db); err != nil {
+		t.Fatal(err)
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(comments.PendingBucket).Stats().KeyN; n != 0 {
+			t.Errorf("expected the expired comment to be purged, got %d pending", n)
+		}
+		return nil
+	})
+}
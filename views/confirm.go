@@ -1,36 +1,57 @@
 package views
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
 	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/search"
 	"github.com/satori/go.uuid"
 )
 
 type ConfirmHandler struct {
+	fs fs.Dir
 	ts *Templates
 	db *bolt.DB
+	m  *mailer.M
+	ex *search.Extractor
 
-	prefix string
+	moderators []string
+	prefix     string
 }
 
-func NewConfirmHandler(ts *Templates, db *bolt.DB, prefix string) *ConfirmHandler {
+// NewConfirmHandler returns a ConfirmHandler, moderators are notified
+// when confirmed files wait for their approval
+func NewConfirmHandler(fs fs.Dir, ts *Templates, db *bolt.DB, m *mailer.M, ex *search.Extractor, moderators []string, prefix string) *ConfirmHandler {
 	return &ConfirmHandler{
+		fs: fs,
 		ts: ts,
 		db: db,
+		m:  m,
+		ex: ex,
 
-		prefix: prefix,
+		moderators: moderators,
+		prefix:     prefix,
 	}
 }
 
-func (ch *ConfirmHandler) confirm(token string) (string, int, error) {
-	processed := 0
+// confirm authorizes the files uploaded with token, returning the email of the uploader,
+// the paths of the files published and the number of files waiting for a moderator
+func (ch *ConfirmHandler) confirm(req *http.Request, token string) (string, []string, int, error) {
+	processed := make([]string, 0)
 	email := ""
-	return email, processed, ch.db.Update(func(tx *bolt.Tx) error {
+	waiting := 0
+	err := UpdateFiles(ch.db, ch.fs, func(tx *bolt.Tx, files *Files) error {
 		bucket := tx.Bucket(fs.FilesBucket)
 		toConfirm := make(map[string]fs.DBFile)
 		dbf := fs.DBFile{}
@@ -48,41 +69,229 @@ func (ch *ConfirmHandler) confirm(token string) (string, int, error) {
 		if err != nil {
 			return err
 		}
-		for path, dbf := range toConfirm {
-			dbf.Authorized = true
-			v, err := json.Marshal(dbf)
+		for path, f := range toConfirm {
+			if err := checkBan(tx, f.Email); err != nil {
+				return err
+			}
+			var dbf fs.DBFile
+			err := audit.Change(tx, newEntry(req, f.Email, audit.Confirm, path), func() error {
+				var err error
+				dbf, err = AuthorizeFile(tx, path)
+				return err
+			})
 			if err != nil {
 				return err
 			}
-			bucket.Put([]byte(path), v)
 			email = dbf.Email
-			processed++
+			if dbf.AwaitingApproval {
+				waiting++
+				continue
+			}
+			processed = append(processed, path)
+		}
+
+		revised, queued, err := ch.confirmRevisions(tx, files, req, token)
+		if err != nil {
+			return err
+		}
+		for path, dbf := range revised {
+			email = dbf.Email
+			processed = append(processed, path)
+		}
+		for _, r := range queued {
+			email = r.Email
+			waiting++
+		}
+		return nil
+	})
+	return email, processed, waiting, err
+}
+
+// confirmRevisions replaces the files which have a new revision uploaded with token,
+// the replaced content is kept as an older revision.
+// It returns the updated files and the revisions waiting for a moderator by path.
+func (ch *ConfirmHandler) confirmRevisions(tx *bolt.Tx, files *Files, req *http.Request, token string) (map[string]fs.DBFile, map[string]fs.DBRevision, error) {
+	revised := make(map[string]fs.DBFile)
+	queued := make(map[string]fs.DBRevision)
+	toConfirm, err := fs.PendingRevisions(tx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	for path, r := range toConfirm {
+		if err := checkBan(tx, r.Email); err != nil {
+			return nil, nil, err
+		}
+		e := newEntry(req, r.Email, audit.Confirm, path)
+		e.Details = fmt.Sprintf("revision %d", r.Number)
+		if r.AwaitingApproval {
+			// the revision is applied once a moderator approves it
+			r.Authorized = true
+			r.AuthorizedAt = time.Now()
+			err := audit.Change(tx, e, func() error { return fs.PutRevision(tx, path, r) })
+			if err != nil {
+				return nil, nil, err
+			}
+			queued[path] = r
+			continue
+		}
+		var dbf fs.DBFile
+		err := audit.Change(tx, e, func() error {
+			var err error
+			dbf, err = ApplyRevision(tx, files, path, r)
+			return err
+		})
+		if err == errNoFile {
+			// the file has been deleted in the meantime
+			continue
+		}
+		if err == errNotPublished {
+			// the revision stays pending until the file is restored
+			log.Printf("[info] revision %d of %s not applied, the file is not published\n", r.Number, path)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		revised[path] = dbf
+	}
+	return revised, queued, nil
+}
+
+// maxResend bounds the confirmation emails sent again on each request
+const maxResend = 10
+
+// unconfirmed returns the name of the files and of the revisions uploaded by email
+// and never confirmed, by the token confirming them
+func unconfirmed(tx *bolt.Tx, email string) (map[string]string, error) {
+	names := make(map[string]string)
+	err := tx.Bucket(fs.FilesBucket).ForEach(func(k, v []byte) error {
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		// revoked files have been confirmed once
+		if dbf.Token != "" && !dbf.Authorized && dbf.AuthorizedAt.IsZero() && strings.EqualFold(dbf.Email, email) {
+			names[dbf.Token] = dbf.Name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b := tx.Bucket(fs.PendingRevisionsBucket)
+	if b == nil {
+		return names, nil
+	}
+	return names, b.ForEach(func(k, v []byte) error {
+		i := bytes.IndexByte(k, 0)
+		if i < 0 || len(v) != 8 {
+			return fmt.Errorf("malformed pending revision %q", k)
+		}
+		name := string(k[i+1:])
+		r, found, err := fs.GetRevision(tx, name, binary.BigEndian.Uint64(v))
+		if err != nil || !found {
+			return err
+		}
+		if strings.EqualFold(r.Email, email) {
+			names[r.Token] = path.Base(name)
 		}
 		return nil
 	})
 }
 
+// resend sends again the confirmation emails of the uploads of the address in the form.
+// The answer is the same whether there are any or not.
+func (ch *ConfirmHandler) resend(rw http.ResponseWriter, req *http.Request) error {
+	sent := ""
+	if req.Method == "POST" {
+		email, err := checkEmail(req.FormValue("email"))
+		if err != nil {
+			ch.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid. Remember that only unitn.it email addresses are accepted.")
+			return nil
+		}
+		var names map[string]string
+		err = ch.db.View(func(tx *bolt.Tx) error {
+			var err error
+			names, err = unconfirmed(tx, email)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		n := 0
+		for token, name := range names {
+			if n == maxResend {
+				log.Printf("[warn] %s has %d unconfirmed uploads, only %d links are sent again\n", email, len(names), n)
+				break
+			}
+			n++
+			go func(token, name string) {
+				if err := ch.m.ConfirmUpload(email, name, token); err != nil {
+					log.Printf("[err] resending confirmation email for %s: %s\n", name, err)
+				}
+			}(token, name)
+		}
+		log.Printf("[info] sent %d confirmation links again to %s\n", n, email)
+		sent = "If there are uploads waiting for the confirmation of " + email + ", we sent their links again."
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	ch.ts.Render(rw, "resend.html", struct {
+		Email string
+		Sent  string
+	}{
+		Email: req.FormValue("email"),
+		Sent:  sent,
+	})
+	return nil
+}
+
 func (ch *ConfirmHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
 	token := strings.Trim(strings.TrimPrefix(req.URL.Path, ch.prefix), "/")
+	if token == "resend" {
+		return ch.resend(rw, req)
+	}
 	_, err := uuid.FromString(token)
 	if err != nil {
 		log.Printf("[debug] invalid uuid %s\n", token)
 		ch.ts.Error(rw, http.StatusBadRequest, "Invalid token")
 		return nil
 	}
-	email, confirmed, err := ch.confirm(token)
+	email, confirmed, waiting, err := ch.confirm(req, token)
+	if be, ok := err.(*bannedError); ok {
+		log.Printf("[warn] confirmation with token %s refused, the uploader is banned\n", token)
+		ch.ts.Error(rw, http.StatusForbidden, be.message())
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	if confirmed > 0 {
-		log.Printf("[info] %s confirmed %d files with token %s\n", email, confirmed, token)
+	if len(confirmed)+waiting > 0 {
+		log.Printf("[info] %s confirmed %d files with token %s, %d wait for a moderator\n", email, len(confirmed)+waiting, token, waiting)
+	}
+	for _, path := range confirmed {
+		ch.ex.Enqueue(path)
+	}
+	if waiting > 0 {
+		go ch.notifyModerators(waiting)
 	}
 	ch.ts.Render(rw, "confirm.html", struct {
 		Email     string
 		Confirmed int
+		Waiting   int
 	}{
 		Email:     email,
-		Confirmed: confirmed,
+		Confirmed: len(confirmed) + waiting,
+		Waiting:   waiting,
 	})
 	return nil
 }
+
+// notifyModerators tells the moderators that n more files wait for their approval
+func (ch *ConfirmHandler) notifyModerators(n int) {
+	for _, to := range ch.moderators {
+		if err := ch.m.NotifyModeration(to, n, "/admin/moderation"); err != nil {
+			log.Printf("[err] notifying moderator %s: %s\n", to, err)
+		}
+	}
+}
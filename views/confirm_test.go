@@ -0,0 +1,79 @@
+package views
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/search"
+)
+
+func TestResend(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	ex := search.NewExtractor(env.db, env.dir, 10)
+	uh := ToHandler(NewUploadHandler(env.dir, env.ts, env.db, env.m, false, "/upload"), env.ts)
+	ch := ToHandler(NewConfirmHandler(env.dir, env.ts, env.db, env.m, ex, nil, "/confirm"), env.ts)
+	if rw := env.upload(t, uh, "/upload/Fisica", "a.txt", "second revision", "a@unitn.it"); rw.Code != 200 {
+		t.Fatalf("expected the revision to be uploaded, got %d", rw.Code)
+	}
+	upload, _ := env.mail()
+	// revoked files have been confirmed already
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		v, _ := json.Marshal(fs.DBFile{Name: "b.md", Email: "a@unitn.it", Token: "revoked", AuthorizedAt: time.Now()})
+		return tx.Bucket(fs.FilesBucket).Put([]byte("/Fisica/b.md"), v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resend := func(email string) string {
+		rw := env.do(ch, "POST", "/confirm/resend", url.Values{"email": {email}})
+		if rw.Code != 200 {
+			t.Errorf("expected the links to be sent again, got %d", rw.Code)
+		}
+		return strings.Replace(rw.Body.String(), email, "EMAIL", -1)
+	}
+
+	uploader := resend("A@unitn.it")
+	sent := ""
+	for i := 0; i < 2; i++ {
+		mail, ok := env.mail()
+		if !ok || mail.To != "A@unitn.it" {
+			t.Fatalf("expected two confirmation emails, got %+v", mail)
+		}
+		sent += mail.Text
+	}
+	env.noMail(t)
+	if !strings.Contains(sent, "/confirm/token\n") || !strings.Contains(sent, confirmLinkRe.FindString(upload.Text)) {
+		t.Errorf("expected the links of the unconfirmed file and revision, got:\n%s", sent)
+	}
+
+	// the answer does not tell whether there are uploads to confirm
+	if stranger := resend("x@unitn.it"); stranger != uploader {
+		t.Errorf("expected the same page for every address:\n%s\n%s", stranger, uploader)
+	}
+	env.noMail(t)
+	if rw := env.do(ch, "POST", "/confirm/resend", url.Values{"email": {"x@example.com"}}); rw.Code != 400 {
+		t.Errorf("expected other addresses to be refused, got %d", rw.Code)
+	}
+	if rw := env.do(ch, "GET", "/confirm/resend?email=a@unitn.it", nil); rw.Code != 200 || !strings.Contains(rw.Body.String(), `value="a@unitn.it"`) {
+		t.Errorf("expected the form, got %d:\n%s", rw.Code, rw.Body)
+	}
+
+	err = env.db.Update(func(tx *bolt.Tx) error {
+		_, err := AuthorizeFile(tx, "/Fisica/hidden.txt")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resend("a@unitn.it")
+	if mail, ok := env.mail(); !ok || !strings.Contains(mail.Text, confirmLinkRe.FindString(upload.Text)) {
+		t.Errorf("expected only the link of the revision, got %+v", mail)
+	}
+	env.noMail(t)
+}
@@ -0,0 +1,300 @@
+package views
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+const (
+	davNamespace = "DAV:"
+	// davAllow lists the methods supported by the read-only WebDAV handler
+	davAllow = "OPTIONS, GET, HEAD, PROPFIND"
+	// maxPropfindBody is the size of the largest PROPFIND request body accepted
+	maxPropfindBody = 64 << 10
+)
+
+// propfind is the body of a PROPFIND request
+type propfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	Allprop  *struct{} `xml:"DAV: allprop"`
+	Propname *struct{} `xml:"DAV: propname"`
+	Prop     *struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// davResource is a file or a directory exposed through WebDAV
+type davResource struct {
+	Path  string
+	IsDir bool
+	File  fs.DBFile
+}
+
+// davProps lists the properties every resource can have
+var davProps = []string{
+	"resourcetype", "displayname", "getcontentlength",
+	"getlastmodified", "getcontenttype", "getetag", "supportedlock",
+}
+
+// etag returns an entity tag which changes whenever the file is modified
+func (r davResource) etag() string {
+	return fmt.Sprintf(`"%x%x"`, r.File.ModTime.UnixNano(), r.File.Size)
+}
+
+// prop returns the value of the property name of r in XML,
+// ok is false if r does not have such property
+func (r davResource) prop(name string) (value string, ok bool) {
+	esc := func(s string) string {
+		b := new(bytes.Buffer)
+		xml.EscapeText(b, []byte(s))
+		return b.String()
+	}
+	switch name {
+	case "resourcetype":
+		if r.IsDir {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case "displayname":
+		return esc(path.Base(r.Path)), true
+	case "supportedlock":
+		return "", true
+	}
+	if r.IsDir {
+		return "", false
+	}
+	switch name {
+	case "getcontentlength":
+		return strconv.FormatInt(r.File.Size, 10), true
+	case "getlastmodified":
+		return r.File.ModTime.UTC().Format(http.TimeFormat), true
+	case "getcontenttype":
+		return esc(contentType(r.File.Name)), true
+	case "getetag":
+		return esc(r.etag()), true
+	}
+	return "", false
+}
+
+func NewDAVHandler(fs fs.Dir, db *bolt.DB, prefix string) *DAVHandler {
+	return &DAVHandler{
+		fs: fs,
+		db: db,
+
+		prefix: prefix,
+	}
+}
+
+// DAVHandler exposes the published files through a read-only WebDAV interface,
+// so that the archive can be mounted as a network drive.
+type DAVHandler struct {
+	fs fs.Dir
+	db *bolt.DB
+
+	prefix string
+}
+
+// resolve returns the resource at name, found is false if there is none
+func (dh *DAVHandler) resolve(name string) (r davResource, found bool, err error) {
+	if name == "/" {
+		_, found, err = getDirectory(dh.db, name)
+		return davResource{Path: "/", IsDir: true}, found, err
+	}
+	dbf, found, err := publishedFile(dh.db, name)
+	if err != nil || found {
+		return davResource{Path: name, File: dbf}, found, err
+	}
+	_, found, err = getDirectory(dh.db, name+"/")
+	return davResource{Path: name + "/", IsDir: true}, found, err
+}
+
+// children returns the subdirectories and the published files of the directory dir
+func (dh *DAVHandler) children(dir string) ([]davResource, error) {
+	dirs, files, err := directoryContent(dh.db, dir)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]davResource, 0, len(dirs)+len(files))
+	for _, d := range dirs {
+		children = append(children, davResource{Path: dir + d + "/", IsDir: true})
+	}
+	for _, f := range files {
+		if !f.Published() {
+			continue
+		}
+		children = append(children, davResource{Path: dir + f.Name, File: f})
+	}
+	return children, nil
+}
+
+// writeResponse writes the multistatus response element describing r.
+// names are the requested properties, all the known ones if nil.
+func (dh *DAVHandler) writeResponse(w io.Writer, r davResource, names []xml.Name, namesOnly bool) {
+	fmt.Fprintf(w, "<D:response><D:href>%s</D:href>", urlPath(dh.prefix+r.Path))
+	if names == nil {
+		io.WriteString(w, "<D:propstat><D:prop>")
+		for _, p := range davProps {
+			if v, ok := r.prop(p); ok {
+				if namesOnly {
+					v = ""
+				}
+				fmt.Fprintf(w, "<D:%s>%s</D:%s>", p, v, p)
+			}
+		}
+		io.WriteString(w, "</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+		return
+	}
+
+	found, missing := new(bytes.Buffer), new(bytes.Buffer)
+	for _, n := range names {
+		if n.Space == davNamespace {
+			if v, ok := r.prop(n.Local); ok {
+				fmt.Fprintf(found, "<D:%s>%s</D:%s>", n.Local, v, n.Local)
+				continue
+			}
+			fmt.Fprintf(missing, "<D:%s/>", n.Local)
+			continue
+		}
+		missing.WriteString("<X:")
+		xml.EscapeText(missing, []byte(n.Local))
+		missing.WriteString(` xmlns:X="`)
+		xml.EscapeText(missing, []byte(n.Space))
+		missing.WriteString(`"/>`)
+	}
+	if found.Len() > 0 {
+		fmt.Fprintf(w, "<D:propstat><D:prop>%s</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>", found)
+	}
+	if missing.Len() > 0 {
+		fmt.Fprintf(w, "<D:propstat><D:prop>%s</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>", missing)
+	}
+	io.WriteString(w, "</D:response>")
+}
+
+func (dh *DAVHandler) propfind(rw http.ResponseWriter, req *http.Request, r davResource) error {
+	depth := req.Header.Get("Depth")
+	if depth == "" {
+		depth = "infinity"
+	}
+	if depth != "0" && depth != "1" {
+		rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		rw.WriteHeader(http.StatusForbidden)
+		io.WriteString(rw, xml.Header+`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+		return nil
+	}
+
+	var (
+		names     []xml.Name
+		namesOnly bool
+	)
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxPropfindBody))
+	if err != nil {
+		return err
+	}
+	// an empty body asks for all the properties
+	if len(bytes.TrimSpace(body)) > 0 {
+		pf := propfind{}
+		if err := xml.Unmarshal(body, &pf); err != nil {
+			http.Error(rw, "malformed propfind request", http.StatusBadRequest)
+			return nil
+		}
+		switch {
+		case pf.Propname != nil:
+			namesOnly = true
+		case pf.Prop != nil:
+			names = make([]xml.Name, 0, len(pf.Prop.Names))
+			for _, n := range pf.Prop.Names {
+				names = append(names, n.XMLName)
+			}
+		}
+	}
+
+	resources := []davResource{r}
+	if r.IsDir && depth == "1" {
+		children, err := dh.children(r.Path)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, children...)
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString(xml.Header)
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	for _, res := range resources {
+		dh.writeResponse(b, res, names, namesOnly)
+	}
+	b.WriteString("</D:multistatus>")
+
+	rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rw.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	rw.WriteHeader(http.StatusMultiStatus)
+	rw.Write(b.Bytes())
+	return nil
+}
+
+func (dh *DAVHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	rw.Header().Set("DAV", "1")
+	switch req.Method {
+	case "OPTIONS":
+		rw.Header().Set("Allow", davAllow)
+		rw.Header().Set("MS-Author-Via", "DAV")
+		rw.WriteHeader(http.StatusOK)
+		return nil
+	case "GET", "HEAD", "PROPFIND":
+	default:
+		// the archive can only be modified through the website
+		rw.Header().Set("Allow", davAllow)
+		http.Error(rw, "the archive is read-only", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, dh.prefix))
+	r, found, err := dh.resolve(name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil
+	}
+
+	if req.Method == "PROPFIND" {
+		return dh.propfind(rw, req, r)
+	}
+	if r.IsDir {
+		// directories have no content, browsers are sent to the listing
+		http.Redirect(rw, req, urlPath(r.Path), http.StatusFound)
+		return nil
+	}
+
+	f, err := dh.fs.Open(r.Path)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			return ViewErr(err, http.StatusNotFound)
+		case os.IsPermission(err):
+			return ViewErr(err, http.StatusForbidden)
+		default:
+			return err
+		}
+	}
+	defer f.Close()
+	// copies made by file managers are not counted as downloads,
+	// they read files to build previews and would inflate the counts
+	rw.Header().Set("ETag", r.etag())
+	http.ServeContent(rw, req, r.File.Name, r.File.ModTime, f)
+	return nil
+}
@@ -0,0 +1,111 @@
+package views
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// davMultistatus is the part of a PROPFIND response checked by the tests
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Propstats []struct {
+			Prop struct {
+				Length     string     `xml:"getcontentlength"`
+				Collection *struct{}  `xml:"resourcetype>collection"`
+				Other      []xml.Name `xml:",any"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func TestDAVHandler(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	dh := ToHandler(NewDAVHandler(env.dir, env.db, "/dav"), env.ts)
+	propfind := func(target, depth, body string) (*httptest.ResponseRecorder, davMultistatus) {
+		req := httptest.NewRequest("PROPFIND", target, strings.NewReader(body))
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+		rw := httptest.NewRecorder()
+		dh.ServeHTTP(rw, req)
+		ms := davMultistatus{}
+		if rw.Code == 207 {
+			if err := xml.Unmarshal(rw.Body.Bytes(), &ms); err != nil {
+				t.Fatalf("invalid multistatus for %s: %s\n%s", target, err, rw.Body)
+			}
+		}
+		return rw, ms
+	}
+
+	rw, ms := propfind("/dav/Fisica/", "1", "")
+	if rw.Code != 207 {
+		t.Fatalf("expected 207, got %d", rw.Code)
+	}
+	hrefs := make(map[string]string)
+	for _, r := range ms.Responses {
+		if len(r.Propstats) != 1 || r.Propstats[0].Status != "HTTP/1.1 200 OK" {
+			t.Errorf("expected the properties of %s to be found, got %+v", r.Href, r.Propstats)
+			continue
+		}
+		p := r.Propstats[0].Prop
+		hrefs[r.Href] = p.Length
+		if isDir := strings.HasSuffix(r.Href, "/"); isDir != (p.Collection != nil) {
+			t.Errorf("wrong resourcetype for %s", r.Href)
+		}
+	}
+	expected := map[string]string{
+		"/dav/Fisica/":      "",
+		"/dav/Fisica/sub/":  "",
+		"/dav/Fisica/a.txt": "25",
+		"/dav/Fisica/b.md":  "24",
+	}
+	if len(hrefs) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, hrefs)
+	}
+	for href, length := range expected {
+		if l, ok := hrefs[href]; !ok || l != length {
+			t.Errorf("expected %s with length %q, got %q (listed: %v)", href, length, l, ok)
+		}
+	}
+
+	if _, ms := propfind("/dav/Fisica/a.txt", "0", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/><Z:color xmlns:Z="urn:x"/></D:prop></D:propfind>`); len(ms.Responses) != 1 || len(ms.Responses[0].Propstats) != 2 || ms.Responses[0].Propstats[1].Status != "HTTP/1.1 404 Not Found" {
+		t.Errorf("expected the unknown property to be missing, got %+v", ms)
+	}
+	if _, ms := propfind("/dav/Fisica/a.txt", "0", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`); len(ms.Responses) != 1 || ms.Responses[0].Propstats[0].Prop.Length != "" {
+		t.Errorf("expected the property names only, got %+v", ms)
+	}
+	if rw, _ := propfind("/dav/Fisica/", "", ""); rw.Code != 403 || !strings.Contains(rw.Body.String(), "propfind-finite-depth") {
+		t.Errorf("expected an infinite depth to be refused, got %d", rw.Code)
+	}
+	if rw, _ := propfind("/dav/Fisica/", "1", "<propfind"); rw.Code != 400 {
+		t.Errorf("expected a malformed body to be refused, got %d", rw.Code)
+	}
+	if rw, _ := propfind("/dav/Fisica/hidden.txt", "0", ""); rw.Code != 404 {
+		t.Errorf("expected unconfirmed files to be missing, got %d", rw.Code)
+	}
+
+	for _, c := range []struct {
+		method, target string
+		code           int
+	}{
+		{"OPTIONS", "/dav/", 200},
+		{"PUT", "/dav/Fisica/new.txt", 405},
+		{"DELETE", "/dav/Fisica/a.txt", 405},
+		{"GET", "/dav/Fisica/", 302},
+		{"GET", "/dav/Fisica/a.txt", 200},
+		{"GET", "/dav/Fisica/hidden.txt", 404},
+	} {
+		rw := env.do(dh, c.method, c.target, nil)
+		if rw.Code != c.code || rw.Header().Get("DAV") != "1" {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.target, c.code, rw.Code)
+		}
+		if c.method == "GET" && c.code == 200 && rw.Body.String() != "hello world /Fisica/a.txt" {
+			t.Errorf("wrong content %q", rw.Body)
+		}
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"log"
 
 	"github.com/boltdb/bolt"
 	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/recent"
+	"github.com/socialnotes/mirror/search"
 )
 
 // CheckDatabase performs sanity checks on the database provided
@@ -19,6 +22,47 @@ func CheckDatabase(db *bolt.DB) error {
 	})
 }
 
+// PrepareDatabase creates the auxiliary buckets missing from databases
+// created by older versions, populating them from the files bucket
+func PrepareDatabase(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if !search.Names.Exists(tx) {
+			log.Println("[info] building file name index")
+			if err := search.RebuildNames(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(fs.DirectoriesBucket) == nil {
+			log.Println("[info] creating directory records")
+			if err := fs.MigrateDirectories(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(fs.PendingRevisionsBucket) == nil {
+			log.Println("[info] indexing pending revisions")
+			if err := fs.IndexPendingRevisions(tx); err != nil {
+				return err
+			}
+		}
+		if !recent.Exists(tx) {
+			log.Println("[info] building time index")
+			if err := recent.Rebuild(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// afterDirectory returns the smallest key greater than the paths
+// of everything contained in the subdirectory name of prefix
+func afterDirectory(prefix, name []byte) []byte {
+	k := make([]byte, 0, len(prefix)+len(name)+1)
+	k = append(append(k, prefix...), name...)
+	// '0' is the byte following '/'
+	return append(k, '0')
+}
+
 // directoryContent returns the files and directories contained in the indicated subdirectory
 // errors are returned only in case of malformed records in the database
 func directoryContent(db *bolt.DB, path string) (dirs []string, files []fs.DBFile, err error) {
@@ -27,31 +71,118 @@ func directoryContent(db *bolt.DB, path string) (dirs []string, files []fs.DBFil
 	prefix := []byte(path)
 
 	return dirs, files, db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(fs.FilesBucket)
-		c := bucket.Cursor()
-		last := []byte{}
-		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-			k = bytes.TrimPrefix(k, prefix)
+		c := tx.Bucket(fs.DirectoriesBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+			name := bytes.TrimPrefix(k, prefix)
+			pos := bytes.IndexByte(name, '/')
+			if pos < 1 {
+				// the directory itself
+				k, _ = c.Next()
+				continue
+			}
+			dirs = append(dirs, string(name[:pos]))
+			k, _ = c.Seek(afterDirectory(prefix, name[:pos]))
+		}
+
+		c = tx.Bucket(fs.FilesBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+			name := bytes.TrimPrefix(k, prefix)
 			// there is still a slash after the prefix has been stripped
-			// therefore it is a directory
-			if pos := bytes.IndexByte(k, '/'); pos > -1 {
-				dir := k[:pos]
-				// if I already encountered the directory, just skip
-				if bytes.Equal(last, dir) {
-					continue
-				}
-				last = dir[:]
-				dirs = append(dirs, string(dir))
+			// therefore it is in a subdirectory
+			if pos := bytes.IndexByte(name, '/'); pos > -1 {
+				k, v = c.Seek(afterDirectory(prefix, name[:pos]))
 				continue
 			}
-			// it's a file
 			dbf := fs.DBFile{}
 			if err := json.Unmarshal(v, &dbf); err != nil {
 				return err
 			}
 			files = append(files, dbf)
+			k, v = c.Next()
 		}
 
 		return nil
 	})
 }
+
+// getDirectory returns the directory stored at dir,
+// found is false if there is no such directory
+func getDirectory(db *bolt.DB, dir string) (dbd fs.DBDirectory, found bool, err error) {
+	return dbd, found, db.View(func(tx *bolt.Tx) error {
+		dbd, found, err = fs.GetDirectory(tx, dir)
+		return err
+	})
+}
+
+// treeFile is a file along with its full path
+type treeFile struct {
+	Path string
+	File fs.DBFile
+}
+
+// publishedFiles returns the published files contained, at any depth, in the directory prefix
+func publishedFiles(db *bolt.DB, prefix string) ([]treeFile, error) {
+	files := make([]treeFile, 0)
+	return files, db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(fs.FilesBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			dbf := fs.DBFile{}
+			if err := json.Unmarshal(v, &dbf); err != nil {
+				return err
+			}
+			if !dbf.Published() {
+				continue
+			}
+			files = append(files, treeFile{Path: string(k), File: dbf})
+		}
+		return nil
+	})
+}
+
+// publishedFile returns the file stored at path,
+// found is false if no such file exists or it is not published
+func publishedFile(db *bolt.DB, path string) (dbf fs.DBFile, found bool, err error) {
+	dbf, found, err = storedFile(db, path)
+	return dbf, found && dbf.Published(), err
+}
+
+// storedFile returns the file stored at path whether it is published or not,
+// found is false if no such file exists
+func storedFile(db *bolt.DB, path string) (dbf fs.DBFile, found bool, err error) {
+	return dbf, found, db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(fs.FilesBucket).Get([]byte(path))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &dbf)
+	})
+}
+
+// errNoFile is returned by updateFile when there is no file at the given path
+var errNoFile = errors.New("no such file")
+
+// errNotPublished is returned by ApplyRevision when the file is not shown to visitors
+var errNotPublished = errors.New("file not published")
+
+// updateFile applies fn to the file stored at path and saves the result
+func updateFile(tx *bolt.Tx, path string, fn func(dbf *fs.DBFile) error) error {
+	bucket := tx.Bucket(fs.FilesBucket)
+	v := bucket.Get([]byte(path))
+	if v == nil {
+		return errNoFile
+	}
+	dbf := fs.DBFile{}
+	if err := json.Unmarshal(v, &dbf); err != nil {
+		return err
+	}
+	if err := fn(&dbf); err != nil {
+		return err
+	}
+	v, err := json.Marshal(dbf)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(path), v)
+}
@@ -0,0 +1,223 @@
+package views
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/markdown"
+)
+
+const (
+	// maxDescription is the size of the largest description rendered above a listing
+	maxDescription = 64 << 10
+	// readmeName is the name of the file used as description when none has been edited
+	readmeName = "README.md"
+)
+
+// directoryDescription renders the description of the directory dbd at path:
+// the one edited on the website if present, the README.md among its files otherwise
+func directoryDescription(dir fs.Dir, path string, dbd fs.DBDirectory, files []listFile) (template.HTML, error) {
+	if strings.TrimSpace(dbd.Description) != "" {
+		return markdown.Render(dbd.Description), nil
+	}
+
+	for _, f := range files {
+		if !strings.EqualFold(f.Name, readmeName) {
+			continue
+		}
+		data, _, err := readPrefix(dir, path+f.Name, maxDescription)
+		if err != nil {
+			return "", err
+		}
+		return markdown.Render(string(data)), nil
+	}
+	return "", nil
+}
+
+// parseMaintainers parses a list of email addresses separated by commas or spaces
+func parseMaintainers(s string) ([]string, error) {
+	maintainers := make([]string, 0)
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' }) {
+		ma, err := mail.ParseAddress(field)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid email address", field)
+		}
+		maintainers = append(maintainers, strings.ToLower(ma.Address))
+	}
+	return maintainers, nil
+}
+
+func NewDescribeHandler(ts *Templates, db *bolt.DB, m *mailer.M, admins []string, prefix string) *DescribeHandler {
+	return &DescribeHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		admins: admins,
+		prefix: prefix,
+	}
+}
+
+// DescribeHandler lets admins and maintainers edit the description of a directory.
+// Edits are published once the editor confirms them through the link sent by email.
+type DescribeHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	admins []string
+	prefix string
+}
+
+// confirm publishes the edit identified by token
+func (dh *DescribeHandler) confirm(rw http.ResponseWriter, req *http.Request, dir, token string) error {
+	status, message := 0, ""
+	err := dh.db.Update(func(tx *bolt.Tx) error {
+		edit, st, msg, err := takeEdit(tx, dh.admins, token, dir, fs.EditDescription)
+		if err != nil || st != 0 {
+			status, message = st, msg
+			return err
+		}
+
+		dbd, found, err := fs.GetDirectory(tx, dir)
+		if err != nil {
+			return err
+		}
+		if !found {
+			status, message = http.StatusNotFound, "The directory does not exist anymore."
+			return nil
+		}
+		dbd.Description = edit.Text
+		if edit.SetMaintainers && isAdmin(dh.admins, edit.Email) {
+			dbd.Maintainers = edit.Maintainers
+		}
+		dbd.DescriptionEmail = edit.Email
+		dbd.DescriptionUpdatedAt = time.Now()
+		log.Printf("[info] %s edited the description of %s\n", edit.Email, dir)
+		return fs.PutDirectory(tx, dir, dbd)
+	})
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		dh.ts.Error(rw, status, message)
+		return nil
+	}
+	http.Redirect(rw, req, urlPath(dir), http.StatusSeeOther)
+	return nil
+}
+
+// submit stores an edit and sends the confirmation email to the editor
+func (dh *DescribeHandler) submit(rw http.ResponseWriter, req *http.Request, dir string) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		dh.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	text := strings.Replace(req.FormValue("description"), "\r\n", "\n", -1)
+	if len(text) > maxDescription {
+		dh.ts.Error(rw, http.StatusBadRequest, fmt.Sprintf("The description must be shorter than %s.", humanizeBytes(maxDescription)))
+		return nil
+	}
+	maintainers, err := parseMaintainers(req.FormValue("maintainers"))
+	if err != nil {
+		dh.ts.Error(rw, http.StatusBadRequest, "The maintainers are not valid: "+err.Error())
+		return nil
+	}
+
+	edit := fs.DBEdit{
+		Kind:           fs.EditDescription,
+		Path:           dir,
+		Text:           text,
+		Maintainers:    maintainers,
+		SetMaintainers: isAdmin(dh.admins, ma.Address),
+		Email:          strings.ToLower(ma.Address),
+		CreatedAt:      time.Now(),
+	}
+	var (
+		token   string
+		allowed bool
+	)
+	err = dh.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, allowed, err = storeEdit(tx, dh.admins, edit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if allowed {
+		go func() {
+			link := (&url.URL{Path: dh.prefix + dir, RawQuery: "token=" + token}).String()
+			if err := dh.m.ConfirmEdit(edit.Email, "the description of "+dir, link); err != nil {
+				log.Printf("[err] sending confirmation email for the description of %s: %s\n", dir, err)
+			}
+		}()
+	} else {
+		// every address gets the same answer, so that it does not tell who maintains dir
+		log.Printf("[warn] %s is not allowed to edit the description of %s\n", edit.Email, dir)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	dh.ts.Render(rw, "describe.html", struct {
+		Path        string
+		Sent        bool
+		Email       string
+		Description string
+		Maintainers string
+	}{
+		Path:  dir,
+		Sent:  true,
+		Email: edit.Email,
+	})
+	return nil
+}
+
+func (dh *DescribeHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	dir := path.Clean("/" + strings.TrimPrefix(req.URL.Path, dh.prefix))
+	if dir != "/" {
+		dir += "/"
+	}
+	dbd, found, err := getDirectory(dh.db, dir)
+	if err != nil {
+		return err
+	}
+	if !found {
+		dh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST":
+		return dh.submit(rw, req, dir)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return dh.confirm(rw, req, dir, req.FormValue("token"))
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	dh.ts.Render(rw, "describe.html", struct {
+		Path        string
+		Sent        bool
+		Email       string
+		Description string
+		Maintainers string
+	}{
+		Path:        dir,
+		Description: dbd.Description,
+		Maintainers: strings.Join(dbd.Maintainers, ", "),
+	})
+	return nil
+}
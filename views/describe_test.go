@@ -0,0 +1,66 @@
+package views
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+func TestDescribeHandler(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		return fs.PutDirectory(tx, "/Fisica/", fs.DBDirectory{Maintainers: []string{"prof@unitn.it"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh := ToHandler(NewDescribeHandler(env.ts, env.db, env.m, []string{"admin@unitn.it"}, "/describe"), env.ts)
+	submit := func(email string) (int, string) {
+		rw := env.do(dh, "POST", "/describe/Fisica/", url.Values{"email": {email}, "description": {"# Fisica"}})
+		return rw.Code, rw.Body.String()
+	}
+
+	// strangers get the same answer as maintainers, but no email
+	code, stranger := submit("nobody@unitn.it")
+	env.noMail(t)
+	_, maintainer := submit("Prof@unitn.it")
+	mail, ok := env.mail()
+	if code != 200 || strings.Replace(stranger, "nobody@unitn.it", "prof@unitn.it", -1) != maintainer {
+		t.Errorf("expected the same page for every address, got %d:\n%s\n%s", code, stranger, maintainer)
+	}
+	if !ok || mail.To != "prof@unitn.it" {
+		t.Fatalf("expected the confirmation to the maintainer, got %+v", mail)
+	}
+
+	i := strings.Index(mail.Text, "token=")
+	token := strings.TrimSpace(mail.Text[i+len("token="):])
+	token = token[:strings.IndexAny(token, "\n ")]
+	if rw := env.do(dh, "GET", "/describe/Fisica/?token="+token, nil); rw.Code != 303 {
+		t.Errorf("expected the edit to be confirmed, got %d", rw.Code)
+	}
+
+	// unconfirmed edits are purged once they expire
+	err = env.db.Update(func(tx *bolt.Tx) error {
+		_, _, err := storeEdit(tx, nil, fs.DBEdit{Kind: fs.EditDescription, Path: "/Fisica/", Email: "prof@unitn.it", CreatedAt: time.Now().Add(-editExpiry - time.Minute)})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit("Prof@unitn.it")
+	env.mail()
+	if err := PurgeExpired(env.db); err != nil {
+		t.Fatal(err)
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(fs.EditsBucket).Stats().KeyN; n != 1 {
+			t.Errorf("expected only the recent edit to be kept, got %d", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package views
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+	"github.com/socialnotes/mirror/comments"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/pending"
+	"github.com/socialnotes/mirror/ratings"
+	"github.com/socialnotes/mirror/reports"
+	"github.com/socialnotes/mirror/takedown"
+)
+
+// editExpiry is how long an edit waits for confirmation
+const editExpiry = 48 * time.Hour
+
+// expiringBuckets hold the edits and the actions waiting for a confirmation by email,
+// their entries are useless once the link sent has expired
+var expiringBuckets = []struct {
+	name   []byte
+	expiry time.Duration
+}{
+	{fs.EditsBucket, editExpiry},
+	{comments.PendingBucket, editExpiry},
+	{ratings.PendingBucket, editExpiry},
+	{reports.PendingBucket, editExpiry},
+	{takedown.PendingNoticesBucket, editExpiry},
+	{takedown.PendingCounterBucket, editExpiry},
+	{adminLoginsBucket, adminLoginExpiry},
+}
+
+// PurgeExpired removes the unconfirmed edits and actions whose link has expired
+func PurgeExpired(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, b := range expiringBuckets {
+			n, err := pending.Purge(tx, b.name, time.Now().Add(-b.expiry))
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Printf("[info] removed %d expired entries from %s\n", n, b.name)
+			}
+		}
+		return nil
+	})
+}
+
+// isAdmin reports whether email belongs to one of the admins
+func isAdmin(admins []string, email string) bool {
+	for _, a := range admins {
+		if strings.EqualFold(a, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// canEdit reports whether email can edit the directory dir and its files,
+// that is if it belongs to an admin or to a maintainer of dir or of one of its parents
+func canEdit(tx *bolt.Tx, admins []string, email, dir string) (bool, error) {
+	if isAdmin(admins, email) {
+		return true, nil
+	}
+	for {
+		dbd, _, err := fs.GetDirectory(tx, dir)
+		if err != nil {
+			return false, err
+		}
+		if isAdmin(dbd.Maintainers, email) {
+			return true, nil
+		}
+		if dir == "/" {
+			return false, nil
+		}
+		dir = fs.ParentDir(dir)
+	}
+}
+
+// storeEdit saves edit until the editor confirms it, returning the confirmation token.
+// allowed is false, and nothing is stored, if the editor cannot edit edit.Path.
+func storeEdit(tx *bolt.Tx, admins []string, edit fs.DBEdit) (token string, allowed bool, err error) {
+	if allowed, err = canEdit(tx, admins, edit.Email, edit.Dir()); err != nil || !allowed {
+		return "", allowed, err
+	}
+	data, err := json.Marshal(edit)
+	if err != nil {
+		return "", false, err
+	}
+	b, err := tx.CreateBucketIfNotExists(fs.EditsBucket)
+	if err != nil {
+		return "", false, err
+	}
+	token = uuid.Must(uuid.NewV4()).String()
+	return token, true, b.Put([]byte(token), data)
+}
+
+// takeEdit removes the edit of p of the given kind identified by token and returns it.
+// If the edit cannot be applied status and message describe the reason.
+func takeEdit(tx *bolt.Tx, admins []string, token, p, kind string) (edit fs.DBEdit, status int, message string, err error) {
+	edits, err := tx.CreateBucketIfNotExists(fs.EditsBucket)
+	if err != nil {
+		return edit, 0, "", err
+	}
+	v := edits.Get([]byte(token))
+	if v == nil {
+		return edit, http.StatusNotFound, "The edit does not exist or it has already been confirmed.", nil
+	}
+	if err := json.Unmarshal(v, &edit); err != nil {
+		return edit, 0, "", err
+	}
+	if edit.Path != p {
+		return edit, http.StatusBadRequest, "The edit belongs to another path.", nil
+	}
+	if edit.Kind != kind {
+		return edit, http.StatusBadRequest, "The edit does not belong to this page.", nil
+	}
+	if err := edits.Delete([]byte(token)); err != nil {
+		return edit, 0, "", err
+	}
+	if time.Since(edit.CreatedAt) > editExpiry {
+		return edit, http.StatusGone, "The edit has expired, please submit it again.", nil
+	}
+	// permissions might have been revoked in the meantime
+	allowed, err := canEdit(tx, admins, edit.Email, edit.Dir())
+	if err != nil {
+		return edit, 0, "", err
+	}
+	if !allowed {
+		return edit, http.StatusForbidden, "You are not allowed to edit this path anymore.", nil
+	}
+	return edit, 0, "", nil
+}
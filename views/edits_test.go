@@ -0,0 +1,33 @@
+package views
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+func TestTakeEditKind(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	admins := []string{"admin@unitn.it"}
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		token, _, err := storeEdit(tx, admins, fs.DBEdit{Kind: fs.EditMetadata, Path: "/Fisica/a.txt", Metadata: &fs.Metadata{}, Email: "admin@unitn.it", CreatedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, status, _, err := takeEdit(tx, admins, token, "/Fisica/a.txt", fs.EditComment); err != nil || status != http.StatusBadRequest {
+			t.Errorf("expected a metadata edit to be refused by the comments, got %d %v", status, err)
+		}
+		edit, status, _, err := takeEdit(tx, admins, token, "/Fisica/a.txt", fs.EditMetadata)
+		if err != nil || status != 0 || edit.Metadata == nil {
+			t.Errorf("expected the metadata edit to be taken, got %d %v %+v", status, err, edit)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package views
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const feedEntries = 50
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+}
+
+type rssFeed struct {
+	XMLName       xml.Name  `xml:"rss"`
+	Version       string    `xml:"version,attr"`
+	Title         string    `xml:"channel>title"`
+	Link          string    `xml:"channel>link"`
+	Description   string    `xml:"channel>description"`
+	LastBuildDate string    `xml:"channel>lastBuildDate"`
+	Items         []rssItem `xml:"channel>item"`
+}
+
+// contentType guesses the mime type of a file from its name
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func NewFeedHandler(db *bolt.DB, siteURL, prefix string) *FeedHandler {
+	return &FeedHandler{
+		db: db,
+
+		siteURL: strings.TrimSuffix(siteURL, "/"),
+		prefix:  prefix,
+	}
+}
+
+// FeedHandler serves the files most recently published in a directory,
+// or any of its subdirectories, as an Atom feed or, with format=rss, as an RSS 2.0 feed
+type FeedHandler struct {
+	db *bolt.DB
+
+	siteURL string
+	prefix  string
+}
+
+func (fh *FeedHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	dir := path.Clean("/" + strings.TrimPrefix(req.URL.Path, fh.prefix))
+	if dir != "/" {
+		dir += "/"
+	}
+
+	_, found, err := getDirectory(fh.db, dir)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ViewErr(fmt.Errorf("no directory %s", dir), http.StatusNotFound)
+	}
+	var files []recentFile
+	err = fh.db.View(func(tx *bolt.Tx) error {
+		files, _ = newestFiles(tx, dir, 0, feedEntries)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	updated := time.Time{}
+	if len(files) > 0 {
+		updated = files[0].PublishedAt
+	}
+
+	var (
+		feed        interface{}
+		ct          string
+		title       = "SocialNotes: " + dir
+		dirURL      = fh.siteURL + urlPath(dir)
+		feedURL     = fh.siteURL + urlPath(fh.prefix+dir)
+		description = "Files recently published in " + dir
+	)
+	if req.FormValue("format") == "rss" {
+		rss := rssFeed{
+			Version:       "2.0",
+			Title:         title,
+			Link:          dirURL,
+			Description:   description,
+			LastBuildDate: updated.Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(files)),
+		}
+		for _, f := range files {
+			fileURL := fh.siteURL + urlPath(f.Path)
+			rss.Items = append(rss.Items, rssItem{
+				Title:       f.File.Name,
+				Link:        fh.siteURL + urlPath("/preview"+f.Path),
+				Description: fmt.Sprintf("%s (%s)", f.Path, humanizeBytes(f.File.Size)),
+				GUID:        rssGUID{Value: fmt.Sprintf("%s#%d", fileURL, f.PublishedAt.Unix())},
+				PubDate:     f.PublishedAt.Format(time.RFC1123Z),
+				Enclosure: rssEnclosure{
+					URL:    fileURL,
+					Length: f.File.Size,
+					Type:   contentType(f.File.Name),
+				},
+			})
+		}
+		feed, ct = rss, "application/rss+xml; charset=utf-8"
+	} else {
+		atom := atomFeed{
+			Title:   title,
+			ID:      dirURL,
+			Updated: updated.Format(time.RFC3339),
+			Author:  "SocialNotes",
+			Links: []atomLink{
+				{Rel: "self", Href: feedURL, Type: "application/atom+xml"},
+				{Rel: "alternate", Href: dirURL, Type: "text/html"},
+			},
+			Entries: make([]atomEntry, 0, len(files)),
+		}
+		for _, f := range files {
+			fileURL := fh.siteURL + urlPath(f.Path)
+			atom.Entries = append(atom.Entries, atomEntry{
+				Title:     f.File.Name,
+				ID:        fmt.Sprintf("%s#%d", fileURL, f.PublishedAt.Unix()),
+				Updated:   f.PublishedAt.Format(time.RFC3339),
+				Published: f.PublishedAt.Format(time.RFC3339),
+				Links: []atomLink{
+					{Rel: "alternate", Href: fh.siteURL + urlPath("/preview"+f.Path), Type: "text/html"},
+					{Rel: "enclosure", Href: fileURL, Type: contentType(f.File.Name), Length: f.File.Size},
+				},
+				Summary: fmt.Sprintf("%s (%s)", f.Path, humanizeBytes(f.File.Size)),
+			})
+		}
+		feed, ct = atom, "application/atom+xml; charset=utf-8"
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString(xml.Header)
+	if err := xml.NewEncoder(b).Encode(feed); err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", ct)
+	http.ServeContent(rw, req, "", updated, bytes.NewReader(b.Bytes()))
+	return nil
+}
@@ -0,0 +1,417 @@
+package views
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/bans"
+	"github.com/socialnotes/mirror/comments"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/ratings"
+	"github.com/socialnotes/mirror/recent"
+	"github.com/socialnotes/mirror/reports"
+	"github.com/socialnotes/mirror/search"
+	"github.com/socialnotes/mirror/stats"
+	"github.com/socialnotes/mirror/thumbs"
+)
+
+// The operations below keep the files bucket, the indexes and the
+// records attached to a file consistent with the files on disk.
+// They are shared by the public handlers, the admin dashboard and mirrorctl.
+
+// Files changes the files stored in Dir along with a database transaction,
+// so that the disk stays consistent with the database whether it is committed or not:
+// files are written or renamed right away and removed or renamed back if the
+// transaction is rolled back, they are removed only once it is committed.
+type Files struct {
+	Dir fs.Dir
+
+	undo   []func()
+	remove []string
+}
+
+// UpdateFiles runs fn in a read-write transaction of db, applying the changes
+// fn makes to the files of dir according to the outcome of the transaction
+func UpdateFiles(db *bolt.DB, dir fs.Dir, fn func(tx *bolt.Tx, files *Files) error) error {
+	files := &Files{Dir: dir}
+	if err := db.Update(func(tx *bolt.Tx) error { return fn(tx, files) }); err != nil {
+		files.rollback()
+		return err
+	}
+	files.commit()
+	return nil
+}
+
+// rename moves the file or directory at from to to, creating the directories leading to it
+func (f *Files) rename(from, to string) error {
+	if err := f.Dir.MkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	if err := f.Dir.Rename(from, to); err != nil {
+		return err
+	}
+	f.undo = append(f.undo, func() {
+		if err := f.Dir.Rename(to, from); err != nil {
+			log.Printf("[err] moving %s back to %s: %s\n", to, from, err)
+		}
+	})
+	return nil
+}
+
+// created removes the file at name, which the caller is writing,
+// if the transaction is rolled back
+func (f *Files) created(name string) {
+	f.undo = append(f.undo, func() {
+		if err := f.Dir.Remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("[err] removing %s: %s\n", name, err)
+		}
+	})
+}
+
+// removeAll removes name and everything it contains once the transaction is committed
+func (f *Files) removeAll(name string) {
+	f.remove = append(f.remove, name)
+}
+
+func (f *Files) rollback() {
+	for i := len(f.undo) - 1; i >= 0; i-- {
+		f.undo[i]()
+	}
+}
+
+func (f *Files) commit() {
+	for _, name := range f.remove {
+		if err := f.Dir.RemoveAll(name); err != nil {
+			log.Printf("[warn] removing %s: %s\n", name, err)
+		}
+	}
+}
+
+// AuthorizeFile publishes the file stored at path, as if its uploader confirmed it.
+// Its text is not extracted, callers running an Extractor should enqueue path.
+func AuthorizeFile(tx *bolt.Tx, path string) (fs.DBFile, error) {
+	var dbf fs.DBFile
+	err := updateFile(tx, path, func(f *fs.DBFile) error {
+		f.Authorized = true
+		f.AuthorizedAt = time.Now()
+		dbf = *f
+		return nil
+	})
+	if err != nil {
+		return dbf, err
+	}
+	if err := search.IndexNames(tx, path, dbf); err != nil {
+		return dbf, err
+	}
+	return dbf, recent.Add(tx, path, dbf)
+}
+
+// RevokeFile unpublishes the file stored at path until it is authorized again
+func RevokeFile(tx *bolt.Tx, path string) (fs.DBFile, error) {
+	var dbf fs.DBFile
+	err := updateFile(tx, path, func(f *fs.DBFile) error {
+		if err := recent.Remove(tx, path, *f); err != nil {
+			return err
+		}
+		f.Authorized = false
+		dbf = *f
+		return nil
+	})
+	if err != nil {
+		return dbf, err
+	}
+	if err := search.IndexNames(tx, path, dbf); err != nil {
+		return dbf, err
+	}
+	return dbf, search.DeleteContent(tx, path)
+}
+
+// ReviewFile hides the file stored at path, or restores it if action is fs.ReviewRestore.
+// Only reports received after the review count towards hiding it again.
+func ReviewFile(tx *bolt.Tx, path, action string) (fs.DBFile, error) {
+	var dbf fs.DBFile
+	return dbf, updateFile(tx, path, func(f *fs.DBFile) error {
+		f.Flagged = action == fs.ReviewHide
+		f.ReviewedAt = time.Now()
+		dbf = *f
+		return nil
+	})
+}
+
+// DeleteFile removes the file stored at path from the database and from files,
+// along with its revisions and everything attached to it
+func DeleteFile(tx *bolt.Tx, files *Files, name string) error {
+	bucket := tx.Bucket(fs.FilesBucket)
+	v := bucket.Get([]byte(name))
+	if v == nil {
+		return errNoFile
+	}
+	dbf := fs.DBFile{}
+	if err := json.Unmarshal(v, &dbf); err != nil {
+		return err
+	}
+	if err := bucket.Delete([]byte(name)); err != nil {
+		return err
+	}
+	for _, del := range []func(*bolt.Tx, string) error{
+		search.Names.Delete,
+		search.DeleteContent,
+		stats.Delete,
+		thumbs.Delete,
+		ratings.Delete,
+		comments.Delete,
+		reports.Delete,
+		fs.DeleteRevisions,
+	} {
+		if err := del(tx, name); err != nil {
+			return err
+		}
+	}
+	if err := recent.Remove(tx, name, dbf); err != nil {
+		return err
+	}
+	files.removeAll(name)
+	files.removeAll(path.Join(fs.RevisionsDir, name))
+	return nil
+}
+
+// MoveFile renames the file stored at from to to, which must be in an existing directory.
+// Its revisions and everything attached to it follow the file.
+func MoveFile(tx *bolt.Tx, files *Files, from, to string) error {
+	to = path.Clean("/" + to)
+	bucket := tx.Bucket(fs.FilesBucket)
+	v := bucket.Get([]byte(from))
+	if v == nil {
+		return errNoFile
+	}
+	if _, found, err := fs.GetDirectory(tx, fs.FileDir(to)); err != nil || !found {
+		if err == nil {
+			err = errNoDirectory
+		}
+		return err
+	}
+	if _, found, err := fs.GetDirectory(tx, to+"/"); err != nil || found || bucket.Get([]byte(to)) != nil {
+		if err == nil {
+			err = errFileExists
+		}
+		return err
+	}
+
+	dbf := fs.DBFile{}
+	if err := json.Unmarshal(v, &dbf); err != nil {
+		return err
+	}
+	if err := recent.Remove(tx, from, dbf); err != nil {
+		return err
+	}
+	if err := bucket.Delete([]byte(from)); err != nil {
+		return err
+	}
+	dbf.Name = path.Base(to)
+	v, err := json.Marshal(dbf)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(to), v); err != nil {
+		return err
+	}
+	if err := search.Names.Delete(tx, from); err != nil {
+		return err
+	}
+	if err := search.IndexNames(tx, to, dbf); err != nil {
+		return err
+	}
+	if err := recent.Add(tx, to, dbf); err != nil {
+		return err
+	}
+	for _, move := range []func(*bolt.Tx, string, string) error{
+		search.MoveContent,
+		stats.Move,
+		thumbs.Move,
+		ratings.Move,
+		comments.Move,
+		reports.Move,
+		fs.MoveRevisions,
+	} {
+		if err := move(tx, from, to); err != nil {
+			return err
+		}
+	}
+
+	if err := files.rename(from, to); err != nil {
+		return err
+	}
+	revFrom := path.Join(fs.RevisionsDir, from)
+	if _, err := files.Dir.Stat(revFrom); os.IsNotExist(err) {
+		return nil
+	}
+	return files.rename(revFrom, path.Join(fs.RevisionsDir, to))
+}
+
+// SetUploader changes the email of the uploader of the file stored at path
+func SetUploader(tx *bolt.Tx, path, email string) (fs.DBFile, error) {
+	var dbf fs.DBFile
+	return dbf, updateFile(tx, path, func(f *fs.DBFile) error {
+		f.Email = email
+		dbf = *f
+		return nil
+	})
+}
+
+// ApplyRevision makes r the current content of the file stored at name,
+// keeping the replaced content as an older revision. The file must be published.
+func ApplyRevision(tx *bolt.Tx, files *Files, name string, r fs.DBRevision) (fs.DBFile, error) {
+	bucket := tx.Bucket(fs.FilesBucket)
+	v := bucket.Get([]byte(name))
+	if v == nil {
+		return fs.DBFile{}, errNoFile
+	}
+	dbf := fs.DBFile{}
+	if err := json.Unmarshal(v, &dbf); err != nil {
+		return dbf, err
+	}
+	// a file hidden or taken down keeps its content until it is restored
+	if !dbf.Published() {
+		return dbf, errNotPublished
+	}
+	old := dbf.AsRevision()
+	if err := fs.PutRevision(tx, name, old); err != nil {
+		return dbf, err
+	}
+	if err := fs.DeleteRevision(tx, name, r.Number); err != nil {
+		return dbf, err
+	}
+	if err := recent.Remove(tx, name, dbf); err != nil {
+		return dbf, err
+	}
+	if err := thumbs.Delete(tx, name); err != nil {
+		return dbf, err
+	}
+
+	dbf.Size = r.Size
+	dbf.ModTime = r.ModTime
+	dbf.Token = r.Token
+	dbf.AuthorizedAt = time.Now()
+	dbf.Revision = r.Number
+	v, err := json.Marshal(dbf)
+	if err != nil {
+		return dbf, err
+	}
+	if err := bucket.Put([]byte(name), v); err != nil {
+		return dbf, err
+	}
+	if err := recent.Add(tx, name, dbf); err != nil {
+		return dbf, err
+	}
+
+	// the first rename is undone if the second one fails
+	if err := files.rename(name, fs.RevisionPath(name, old.Number)); err != nil {
+		return dbf, err
+	}
+	return dbf, files.rename(fs.RevisionPath(name, r.Number), name)
+}
+
+// ApproveFile publishes the file stored at path, which was waiting for a moderator.
+// Its text is not extracted, callers running an Extractor should enqueue path.
+func ApproveFile(tx *bolt.Tx, path, moderator string) (fs.DBFile, error) {
+	var dbf fs.DBFile
+	err := updateFile(tx, path, func(f *fs.DBFile) error {
+		f.AwaitingApproval = false
+		f.ApprovedBy = moderator
+		f.ApprovedAt = time.Now()
+		dbf = *f
+		return nil
+	})
+	if err != nil {
+		return dbf, err
+	}
+	if err := search.IndexNames(tx, path, dbf); err != nil {
+		return dbf, err
+	}
+	return dbf, recent.Add(tx, path, dbf)
+}
+
+// ApproveRevision applies the revision n of the file stored at name,
+// which was waiting for a moderator
+func ApproveRevision(tx *bolt.Tx, files *Files, name string, n uint64, moderator string) (fs.DBFile, error) {
+	r, found, err := fs.GetRevision(tx, name, n)
+	if err != nil || !found {
+		if err == nil {
+			err = errNoFile
+		}
+		return fs.DBFile{}, err
+	}
+	dbf, err := ApplyRevision(tx, files, name, r)
+	if err != nil {
+		return dbf, err
+	}
+	// the approval changes the time the file is listed as recent with
+	if err := recent.Remove(tx, name, dbf); err != nil {
+		return dbf, err
+	}
+	err = updateFile(tx, name, func(f *fs.DBFile) error {
+		f.ApprovedBy = moderator
+		f.ApprovedAt = time.Now()
+		dbf = *f
+		return nil
+	})
+	if err != nil {
+		return dbf, err
+	}
+	return dbf, recent.Add(tx, name, dbf)
+}
+
+// RejectRevision removes the revision n of the file stored at name, which was not applied yet
+func RejectRevision(tx *bolt.Tx, files *Files, name string, n uint64) error {
+	if _, found, err := fs.GetRevision(tx, name, n); err != nil || !found {
+		if err == nil {
+			err = errNoFile
+		}
+		return err
+	}
+	if err := fs.DeleteRevision(tx, name, n); err != nil {
+		return err
+	}
+	files.removeAll(fs.RevisionPath(name, n))
+	return nil
+}
+
+// PurgeUploads hides the files uploaded by the addresses matching b, or deletes them if action
+// is audit.Delete, recording every change in the audit log as e. It returns the paths of the files changed.
+func PurgeUploads(tx *bolt.Tx, files *Files, b bans.Ban, action string, e audit.Entry) ([]string, error) {
+	var paths []string
+	err := tx.Bucket(fs.FilesBucket).ForEach(func(k, v []byte) error {
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil {
+			return err
+		}
+		if b.Matches(dbf.Email) && (action == audit.Delete || !dbf.Flagged) {
+			paths = append(paths, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.Action, e.Details = action, "banned by "+b.Pattern
+	for _, p := range paths {
+		e.Path = p
+		err := audit.Change(tx, e, func() error {
+			if action == audit.Delete {
+				return DeleteFile(tx, files, p)
+			}
+			_, err := ReviewFile(tx, p, fs.ReviewHide)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,282 @@
+package views
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/bans"
+	"github.com/socialnotes/mirror/comments"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/ratings"
+	"github.com/socialnotes/mirror/recent"
+	"github.com/socialnotes/mirror/reports"
+	"github.com/socialnotes/mirror/search"
+	"github.com/socialnotes/mirror/stats"
+)
+
+// attached counts the records attached to a file
+type attached struct {
+	Listed, Named, Text    bool
+	Downloads, Votes       uint64
+	Comments, Reports      int
+	Revisions              int
+	OnDisk, RevisionOnDisk bool
+}
+
+// attach adds a download, a vote, a comment, a report, an older revision
+// and the extracted text to the file at name
+func (env *testEnv) attach(t *testing.T, name string) {
+	t.Helper()
+	c := stats.NewCounter(env.db)
+	c.Hit(name)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := search.IndexContent(env.db, env.dir, name); err != nil {
+		t.Fatal(err)
+	}
+	rev := filepath.Join(string(env.dir), fs.RevisionPath(name, 2))
+	if err := os.MkdirAll(filepath.Dir(rev), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(rev, []byte("older"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		if err := ratings.Vote(tx, name, "b@unitn.it", 4); err != nil {
+			return err
+		}
+		if _, err := comments.Add(tx, comments.Comment{Path: name, Email: "b@unitn.it", Text: "thanks", CreatedAt: time.Now()}); err != nil {
+			return err
+		}
+		if err := reports.Add(tx, reports.Report{Path: name, Email: "b@unitn.it", Reason: "spam", CreatedAt: time.Now()}); err != nil {
+			return err
+		}
+		return fs.PutRevision(tx, name, fs.DBRevision{Number: 2, Token: "older", Authorized: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// attachedTo returns what is attached to the file at name
+func (env *testEnv) attachedTo(t *testing.T, name string) attached {
+	t.Helper()
+	var a attached
+	err := env.db.View(func(tx *bolt.Tx) error {
+		entries, _ := recent.Newest(tx, 0, 100, func(p string) bool { return p == name })
+		a.Listed = len(entries) > 0
+		for _, r := range search.Search(tx, search.Tokenize(filepath.Base(name)), "/", search.Names) {
+			a.Named = a.Named || r.Path == name
+		}
+		a.Text = search.Text(tx, name) != ""
+		a.Downloads = stats.Downloads(tx, name)
+		a.Votes = ratings.Get(tx, name).Count
+		var err error
+		if a.Comments, err = comments.Count(tx, name); err != nil {
+			return err
+		}
+		rs, err := reports.List(tx, name)
+		if err != nil {
+			return err
+		}
+		a.Reports = len(rs)
+		revs, err := fs.Revisions(tx, name)
+		a.Revisions = len(revs)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.dir.Stat(name)
+	a.OnDisk = err == nil
+	_, err = env.dir.Stat(fs.RevisionPath(name, 2))
+	a.RevisionOnDisk = err == nil
+	return a
+}
+
+var (
+	everything = attached{true, true, true, 1, 1, 1, 1, 1, true, true}
+	nothing    = attached{}
+	errAbort   = errors.New("abort")
+)
+
+func TestDeleteFile(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	name := "/Fisica/a.txt"
+	env.attach(t, name)
+	if a := env.attachedTo(t, name); a != everything {
+		t.Fatalf("expected everything to be attached, got %+v", a)
+	}
+
+	// the file stays on disk if the transaction is rolled back
+	err := UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+		if err := DeleteFile(tx, files, name); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected the transaction to be aborted, got %v", err)
+	}
+	if a := env.attachedTo(t, name); a != everything {
+		t.Errorf("expected nothing to change, got %+v", a)
+	}
+
+	err = UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+		return DeleteFile(tx, files, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := env.attachedTo(t, name); a != nothing {
+		t.Errorf("expected nothing left, got %+v", a)
+	}
+	if _, found := env.file(t, name); found {
+		t.Error("expected the record to be deleted")
+	}
+	if a := env.attachedTo(t, "/Fisica/b.md"); !a.OnDisk || !a.Listed || !a.Named {
+		t.Errorf("expected the other files to be kept, got %+v", a)
+	}
+	err = UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+		return DeleteFile(tx, files, name)
+	})
+	if err != errNoFile {
+		t.Errorf("expected errNoFile, got %v", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	from, to := "/Fisica/a.txt", "/Fisica/sub/moved.txt"
+	env.attach(t, from)
+	move := func(from, to string, abort bool) error {
+		return UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+			if err := MoveFile(tx, files, from, to); err != nil || !abort {
+				return err
+			}
+			return errAbort
+		})
+	}
+
+	// the files are moved back if the transaction is rolled back
+	if err := move(from, to, true); err != errAbort {
+		t.Fatalf("expected the transaction to be aborted, got %v", err)
+	}
+	if a := env.attachedTo(t, from); a != everything {
+		t.Errorf("expected nothing to change, got %+v", a)
+	}
+	if a := env.attachedTo(t, to); a != nothing {
+		t.Errorf("expected nothing at the destination, got %+v", a)
+	}
+
+	for dest, want := range map[string]error{
+		"/Fisica/b.md":       errFileExists,
+		"/Fisica/sub":        errFileExists,
+		"/Chimica/moved.txt": errNoDirectory,
+	} {
+		if err := move(from, dest, false); err != want {
+			t.Errorf("moving to %s: expected %v, got %v", dest, want, err)
+		}
+	}
+	if err := move("/Fisica/none.txt", to, false); err != errNoFile {
+		t.Errorf("expected errNoFile, got %v", err)
+	}
+
+	if err := move(from, to, false); err != nil {
+		t.Fatal(err)
+	}
+	if a := env.attachedTo(t, from); a != nothing {
+		t.Errorf("expected nothing left at the source, got %+v", a)
+	}
+	if a := env.attachedTo(t, to); a != everything {
+		t.Errorf("expected everything to follow the file, got %+v", a)
+	}
+	if dbf, _ := env.file(t, to); dbf.Name != "moved.txt" {
+		t.Errorf("expected the record to be renamed, got %+v", dbf)
+	}
+}
+
+func TestPurgeUploads(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	env.attach(t, "/Fisica/a.txt")
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		_, err := SetUploader(tx, "/Fisica/b.md", "b@unitn.it")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	purge := func(action string) []string {
+		var paths []string
+		err := UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+			var err error
+			paths, err = PurgeUploads(tx, files, bans.Ban{Pattern: "a@*"}, action, audit.Entry{Actor: "admin@unitn.it"})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paths
+	}
+
+	if paths := purge(audit.Hide); len(paths) != 3 {
+		t.Errorf("expected the three files of a@unitn.it to be hidden, got %v", paths)
+	}
+	if dbf, _ := env.file(t, "/Fisica/a.txt"); !dbf.Flagged {
+		t.Errorf("expected the file to be hidden, got %+v", dbf)
+	}
+	if a := env.attachedTo(t, "/Fisica/a.txt"); a != everything {
+		t.Errorf("expected hidden files to keep everything, got %+v", a)
+	}
+	if paths := purge(audit.Hide); len(paths) != 0 {
+		t.Errorf("expected hidden files to be skipped, got %v", paths)
+	}
+
+	if paths := purge(audit.Delete); len(paths) != 3 {
+		t.Errorf("expected the hidden files to be deleted too, got %v", paths)
+	}
+	if a := env.attachedTo(t, "/Fisica/a.txt"); a != nothing {
+		t.Errorf("expected nothing left, got %+v", a)
+	}
+	if _, found := env.file(t, "/Fisica/b.md"); !found {
+		t.Error("expected the file of b@unitn.it to be kept")
+	}
+	err = env.db.View(func(tx *bolt.Tx) error {
+		entries, err := audit.List(tx, audit.Filter{Path: "/Fisica/a.txt"}, 0, 10)
+		if err == nil && len(entries) != 2 {
+			t.Errorf("expected the hide and the delete to be logged, got %+v", entries)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilesCreatedRollback(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	err := UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+		files.created("/Fisica/new.txt")
+		if err := ioutil.WriteFile(filepath.Join(string(env.dir), "Fisica", "new.txt"), []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected the transaction to be aborted, got %v", err)
+	}
+	if _, err := env.dir.Stat("/Fisica/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected the new file to be removed, got %v", err)
+	}
+}
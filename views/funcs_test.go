@@ -14,11 +14,11 @@ func TestHumanizeBytes(t *testing.T) {
 
 	e = humanizeBytes(3)
 	if strings.Compare(e, "3B") != 0 {
-		t.Error("Wrong encode for byte %s\n", e)
+		t.Errorf("Wrong encode for byte %s\n", e)
 	}
 
 	e = humanizeBytes(1<<62 + 1<<61)
 	if strings.Compare(e, "6EB") != 0 {
-		t.Error("Wrong max size %s\n", e)
+		t.Errorf("Wrong max size %s\n", e)
 	}
 }
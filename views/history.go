@@ -0,0 +1,128 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+)
+
+func NewHistoryHandler(fs fs.Dir, ts *Templates, db *bolt.DB, prefix string) *HistoryHandler {
+	return &HistoryHandler{
+		fs: fs,
+		ts: ts,
+		db: db,
+
+		prefix: prefix,
+	}
+}
+
+// HistoryHandler lists the revisions of a file and serves the older ones,
+// the current revision is served as the file itself
+type HistoryHandler struct {
+	fs fs.Dir
+	ts *Templates
+	db *bolt.DB
+
+	prefix string
+}
+
+// serveRevision serves the content of the older revision n of the file at name
+func (hh *HistoryHandler) serveRevision(rw http.ResponseWriter, req *http.Request, name string, file fs.DBFile, n uint64) error {
+	if n == file.CurrentRevision() {
+		http.Redirect(rw, req, urlPath(name), http.StatusFound)
+		return nil
+	}
+	var (
+		r     fs.DBRevision
+		found bool
+	)
+	err := hh.db.View(func(tx *bolt.Tx) error {
+		var err error
+		r, found, err = fs.GetRevision(tx, name, n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !found || !r.Published() {
+		hh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	f, err := hh.fs.Open(fs.RevisionPath(name, n))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ViewErr(err, http.StatusNotFound)
+		}
+		return err
+	}
+	defer f.Close()
+	ext := path.Ext(file.Name)
+	filename := fmt.Sprintf("%s.r%d%s", strings.TrimSuffix(file.Name, ext), n, ext)
+	rw.Header().Set("Content-Type", contentType(file.Name))
+	rw.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
+	http.ServeContent(rw, req, filename, r.ModTime, f)
+	return nil
+}
+
+func (hh *HistoryHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, hh.prefix))
+	file, found, err := publishedFile(hh.db, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		hh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	if rev := req.FormValue("rev"); rev != "" {
+		n, err := strconv.ParseUint(rev, 10, 64)
+		if err != nil {
+			hh.ts.Error(rw, http.StatusBadRequest, "The revision is not valid.")
+			return nil
+		}
+		return hh.serveRevision(rw, req, name, file, n)
+	}
+
+	older := make([]fs.DBRevision, 0)
+	err = hh.db.View(func(tx *bolt.Tx) error {
+		revs, err := fs.Revisions(tx, name)
+		if err != nil {
+			return err
+		}
+		// newest first, pending revisions are not shown
+		for i := len(revs) - 1; i >= 0; i-- {
+			if revs[i].Published() {
+				older = append(older, revs[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	hh.ts.Render(rw, "history.html", struct {
+		Path      string
+		Directory string
+		File      fs.DBFile
+		Current   fs.DBRevision
+		Older     []fs.DBRevision
+	}{
+		Path:      name,
+		Directory: fs.FileDir(name),
+		File:      file,
+		Current:   file.AsRevision(),
+		Older:     older,
+	})
+	return nil
+}
@@ -0,0 +1,264 @@
+package views
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/ratings"
+)
+
+const (
+	sortName      = "name"
+	sortModTime   = "mtime"
+	sortSize      = "size"
+	sortDownloads = "downloads"
+	sortRating    = "rating"
+
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// listFile is a file shown in a directory listing
+type listFile struct {
+	fs.DBFile
+	Downloads uint64
+	Rating    ratings.Score
+	Comments  int
+}
+
+// fileLess contains the comparison function for every supported sort key
+var fileLess = map[string]func(a, b listFile) bool{
+	sortName: func(a, b listFile) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	},
+	sortModTime: func(a, b listFile) bool {
+		return a.ModTime.Before(b.ModTime)
+	},
+	sortSize: func(a, b listFile) bool {
+		return a.Size < b.Size
+	},
+	sortDownloads: func(a, b listFile) bool {
+		return a.Downloads < b.Downloads
+	},
+	sortRating: func(a, b listFile) bool {
+		ra, rb := a.Rating.Rank(), b.Rating.Rank()
+		if ra == rb {
+			return a.Rating.Count < b.Rating.Count
+		}
+		return ra < rb
+	},
+}
+
+// listOptions holds the sorting, filtering and pagination state of a directory listing.
+// It is parsed from and serialized to the query string so that links in the
+// listing keep the current state.
+type listOptions struct {
+	Sort    string
+	Desc    bool
+	Filter  string
+	Meta    metadataFilter
+	Page    int
+	PerPage int
+}
+
+// parseListOptions extracts the listing options from the query string,
+// falling back to sensible defaults for missing or invalid values
+func parseListOptions(q url.Values) listOptions {
+	lo := listOptions{
+		Sort:    sortName,
+		Desc:    q.Get("order") == "desc",
+		Filter:  strings.TrimSpace(q.Get("filter")),
+		Meta:    parseMetadataFilter(q),
+		Page:    1,
+		PerPage: defaultPerPage,
+	}
+	if _, ok := fileLess[q.Get("sort")]; ok {
+		lo.Sort = q.Get("sort")
+	}
+	if p, err := strconv.Atoi(q.Get("page")); err == nil && p > 0 {
+		lo.Page = p
+	}
+	if pp, err := strconv.Atoi(q.Get("per_page")); err == nil && pp > 0 {
+		if pp > maxPerPage {
+			pp = maxPerPage
+		}
+		lo.PerPage = pp
+	}
+	return lo
+}
+
+// query serializes the options, omitting the ones left at their default value
+func (lo listOptions) query() string {
+	v := url.Values{}
+	if lo.Sort != sortName {
+		v.Set("sort", lo.Sort)
+	}
+	if lo.Desc {
+		v.Set("order", "desc")
+	}
+	if lo.Filter != "" {
+		v.Set("filter", lo.Filter)
+	}
+	lo.Meta.set(v)
+	if lo.Page > 1 {
+		v.Set("page", strconv.Itoa(lo.Page))
+	}
+	if lo.PerPage != defaultPerPage {
+		v.Set("per_page", strconv.Itoa(lo.PerPage))
+	}
+	if len(v) == 0 {
+		return "?"
+	}
+	return "?" + v.Encode()
+}
+
+// SortURL returns the link for a column header: selecting the current sort key
+// again reverses the direction, a new key starts in ascending order
+func (lo listOptions) SortURL(key string) string {
+	if lo.Sort == key {
+		lo.Desc = !lo.Desc
+	} else {
+		lo.Sort = key
+		lo.Desc = false
+	}
+	lo.Page = 1
+	return lo.query()
+}
+
+// PageURL returns the link to the n-th page keeping the rest of the state
+func (lo listOptions) PageURL(n int) string {
+	lo.Page = n
+	return lo.query()
+}
+
+// MetaURL returns the link filtering the listing by the metadata field name
+func (lo listOptions) MetaURL(name, value string) string {
+	v := url.Values{}
+	lo.Meta.set(v)
+	v.Set(name, value)
+	lo.Meta = parseMetadataFilter(v)
+	lo.Page = 1
+	return lo.query()
+}
+
+// SortIndicator returns an arrow if the listing is sorted by key
+func (lo listOptions) SortIndicator(key string) string {
+	switch {
+	case lo.Sort != key:
+		return ""
+	case lo.Desc:
+		return "▼"
+	default:
+		return "▲"
+	}
+}
+
+// listPage is a single page of a filtered and sorted directory listing
+type listPage struct {
+	Directories []string
+	Files       []listFile
+
+	Total    int
+	Page     int
+	Pages    int
+	HasPrev  bool
+	HasNext  bool
+	PrevPage int
+	NextPage int
+}
+
+// apply filters, sorts and paginates dirs and files.
+// Directories are always listed before files, they are only ever sorted
+// and filtered by name.
+// The slices passed are modified in place.
+func (lo listOptions) apply(dirs []string, files []listFile) listPage {
+	if lo.Filter != "" {
+		needle := strings.ToLower(lo.Filter)
+		fd := dirs[:0]
+		for _, d := range dirs {
+			if strings.Contains(strings.ToLower(d), needle) {
+				fd = append(fd, d)
+			}
+		}
+		dirs = fd
+		ff := files[:0]
+		for _, f := range files {
+			if strings.Contains(strings.ToLower(f.Name), needle) {
+				ff = append(ff, f)
+			}
+		}
+		files = ff
+	}
+	// directories are kept to navigate to the matching files they contain
+	if !lo.Meta.Empty() {
+		ff := files[:0]
+		for _, f := range files {
+			if lo.Meta.match(f.Metadata) {
+				ff = append(ff, f)
+			}
+		}
+		files = ff
+	}
+
+	dirsDesc := lo.Sort == sortName && lo.Desc
+	sort.SliceStable(dirs, func(i, j int) bool {
+		if dirsDesc {
+			return strings.ToLower(dirs[j]) < strings.ToLower(dirs[i])
+		}
+		return strings.ToLower(dirs[i]) < strings.ToLower(dirs[j])
+	})
+	less := fileLess[lo.Sort]
+	sort.SliceStable(files, func(i, j int) bool {
+		if lo.Desc {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+
+	total := len(dirs) + len(files)
+	pages := (total + lo.PerPage - 1) / lo.PerPage
+	if pages == 0 {
+		pages = 1
+	}
+	page := lo.Page
+	if page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * lo.PerPage
+	end := start + lo.PerPage
+	if end > total {
+		end = total
+	}
+	lp := listPage{
+		Directories: make([]string, 0),
+		Files:       make([]listFile, 0),
+
+		Total:    total,
+		Page:     page,
+		Pages:    pages,
+		HasPrev:  page > 1,
+		HasNext:  page < pages,
+		PrevPage: page - 1,
+		NextPage: page + 1,
+	}
+	// the window [start, end) spans directories first and then files
+	if start < len(dirs) {
+		de := end
+		if de > len(dirs) {
+			de = len(dirs)
+		}
+		lp.Directories = dirs[start:de]
+	}
+	fStart, fEnd := start-len(dirs), end-len(dirs)
+	if fStart < 0 {
+		fStart = 0
+	}
+	if fEnd > fStart {
+		lp.Files = files[fStart:fEnd]
+	}
+	return lp
+}
@@ -0,0 +1,89 @@
+package views
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/socialnotes/mirror/fs"
+)
+
+func TestListOptionsApply(t *testing.T) {
+	now := time.Now()
+	files := []listFile{
+		{DBFile: fs.DBFile{Name: "b.pdf", Size: 10, ModTime: now}, Downloads: 3},
+		{DBFile: fs.DBFile{Name: "A.pdf", Size: 30, ModTime: now.Add(-time.Hour)}, Downloads: 1},
+		{DBFile: fs.DBFile{Name: "c.txt", Size: 20, ModTime: now.Add(time.Hour)}, Downloads: 2},
+	}
+	q, _ := url.ParseQuery("sort=size&order=desc&per_page=2&page=2")
+	lo := parseListOptions(q)
+	lp := lo.apply([]string{"dir"}, files)
+	if lp.Pages != 2 || lp.Total != 4 {
+		t.Fatalf("expected 2 pages and 4 entries, got %d and %d", lp.Pages, lp.Total)
+	}
+	if len(lp.Directories) != 0 || len(lp.Files) != 2 {
+		t.Fatalf("expected 0 dirs and 2 files on page 2, got %d and %d", len(lp.Directories), len(lp.Files))
+	}
+	if lp.Files[0].Name != "c.txt" || lp.Files[1].Name != "b.pdf" {
+		t.Errorf("wrong order on page 2: %s, %s", lp.Files[0].Name, lp.Files[1].Name)
+	}
+
+	q, _ = url.ParseQuery("sort=downloads&order=desc")
+	lp = parseListOptions(q).apply(nil, files)
+	if lp.Files[0].Name != "b.pdf" || lp.Files[2].Name != "A.pdf" {
+		t.Errorf("wrong order by downloads: %v", lp.Files)
+	}
+
+	q, _ = url.ParseQuery("filter=PDF")
+	lp = parseListOptions(q).apply([]string{"pdfs", "other"}, files)
+	if len(lp.Directories) != 1 || len(lp.Files) != 2 || lp.Files[0].Name != "A.pdf" {
+		t.Errorf("filter returned unexpected entries: %v %v", lp.Directories, lp.Files)
+	}
+}
+
+func TestListOptionsURL(t *testing.T) {
+	q, _ := url.ParseQuery("sort=mtime&filter=esame&page=3&type=exam&year=2016-2017")
+	lo := parseListOptions(q)
+	if u := lo.SortURL("mtime"); u != "?filter=esame&order=desc&sort=mtime&type=exam&year=2016%2F17" {
+		t.Errorf("unexpected sort url %s", u)
+	}
+	if u := lo.SortURL("size"); u != "?filter=esame&sort=size&type=exam&year=2016%2F17" {
+		t.Errorf("unexpected sort url %s", u)
+	}
+	if u := lo.PageURL(4); u != "?filter=esame&page=4&sort=mtime&type=exam&year=2016%2F17" {
+		t.Errorf("unexpected page url %s", u)
+	}
+}
+
+func TestMetadataFilter(t *testing.T) {
+	files := []listFile{
+		{DBFile: fs.DBFile{Name: "a.pdf", Metadata: fs.Metadata{Course: "145012", Type: "exam", Year: "2016/17", Tags: []string{"orale"}}}},
+		{DBFile: fs.DBFile{Name: "b.pdf", Metadata: fs.Metadata{Course: "145012", Type: "notes", Professor: "Mario Rossi"}}},
+		{DBFile: fs.DBFile{Name: "c.pdf"}},
+	}
+	for query, expected := range map[string]int{
+		"course=145012":             2,
+		"type=exam&tag=ORALE":       1,
+		"professor=rossi":           1,
+		"year=2016/2017&type=notes": 0,
+		"year=not-a-year":           3,
+	} {
+		q, _ := url.ParseQuery(query)
+		lp := parseListOptions(q).apply([]string{"dir"}, append([]listFile(nil), files...))
+		if len(lp.Files) != expected || len(lp.Directories) != 1 {
+			t.Errorf("%s: expected %d files, got %d", query, expected, len(lp.Files))
+		}
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	for in, out := range map[string]string{"2016/17": "2016/17", "2016-2017": "2016/17", "1999/00": "1999/00", "2016/18": "", "2016": ""} {
+		year, err := parseYear(in)
+		if out == "" && err == nil {
+			t.Errorf("expected %s to be rejected, got %s", in, year)
+		}
+		if out != "" && year != out {
+			t.Errorf("expected %s to become %s, got %s (%v)", in, out, year, err)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+)
+
+const (
+	// maxMetadataField is the length of the longest metadata value accepted
+	maxMetadataField = 100
+	maxTags          = 10
+	maxTagLength     = 32
+)
+
+var (
+	yearRe     = regexp.MustCompile(`^(\d{4})\s*[/-]\s*(\d{2}|\d{4})$`)
+	languageRe = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// parseYear normalizes an academic year to the 2016/17 form
+func parseYear(s string) (string, error) {
+	m := yearRe.FindStringSubmatch(s)
+	if m == nil {
+		return "", fmt.Errorf("%s is not an academic year, use the 2016/17 form", s)
+	}
+	first, _ := strconv.Atoi(m[1])
+	second, _ := strconv.Atoi(m[2])
+	if second%100 != (first+1)%100 || (len(m[2]) == 4 && second != first+1) {
+		return "", fmt.Errorf("%s is not an academic year, the years must be consecutive", s)
+	}
+	return fmt.Sprintf("%d/%02d", first, second%100), nil
+}
+
+// parseTags splits a comma separated list of tags, normalizing and deduplicating them
+func parseTags(s string) ([]string, error) {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.Join(strings.Fields(t), " "))
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > maxTagLength {
+			return nil, fmt.Errorf("the tag %s is longer than %d characters", t, maxTagLength)
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return tags, nil
+}
+
+// isDocumentType reports whether t is one of fs.DocumentTypes
+func isDocumentType(t string) bool {
+	for _, dt := range fs.DocumentTypes {
+		if t == dt {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMetadata validates the metadata fields of a submitted form
+func parseMetadata(req *http.Request) (fs.Metadata, error) {
+	field := func(name string) string {
+		return strings.Join(strings.Fields(req.FormValue(name)), " ")
+	}
+	m := fs.Metadata{
+		Course:    strings.ToUpper(field("course")),
+		Professor: field("professor"),
+		Type:      strings.ToLower(field("type")),
+		Language:  strings.ToLower(field("lang")),
+	}
+	if len(m.Course) > maxMetadataField || len(m.Professor) > maxMetadataField {
+		return m, fmt.Errorf("course and professor must be shorter than %d characters", maxMetadataField)
+	}
+	if m.Type != "" && !isDocumentType(m.Type) {
+		return m, fmt.Errorf("%s is not a document type", m.Type)
+	}
+	if m.Language != "" && !languageRe.MatchString(m.Language) {
+		return m, fmt.Errorf("%s is not a two letter language code", m.Language)
+	}
+	if y := field("year"); y != "" {
+		year, err := parseYear(y)
+		if err != nil {
+			return m, err
+		}
+		m.Year = year
+	}
+	tags, err := parseTags(req.FormValue("tags"))
+	if err != nil {
+		return m, err
+	}
+	if len(tags) > 0 {
+		m.Tags = tags
+	}
+	return m, nil
+}
+
+// metadataFilter selects the files whose metadata match every field set
+type metadataFilter struct {
+	Course    string
+	Professor string
+	Year      string
+	Type      string
+	Language  string
+	Tag       string
+}
+
+// parseMetadataFilter extracts the metadata filter from the query string
+func parseMetadataFilter(q url.Values) metadataFilter {
+	mf := metadataFilter{
+		Course:    strings.TrimSpace(q.Get("course")),
+		Professor: strings.TrimSpace(q.Get("professor")),
+		Type:      strings.TrimSpace(q.Get("type")),
+		Language:  strings.TrimSpace(q.Get("lang")),
+		Tag:       strings.TrimSpace(q.Get("tag")),
+	}
+	if year, err := parseYear(strings.TrimSpace(q.Get("year"))); err == nil {
+		mf.Year = year
+	}
+	return mf
+}
+
+// set adds the fields of the filter to v
+func (mf metadataFilter) set(v url.Values) {
+	for name, value := range map[string]string{
+		"course": mf.Course, "professor": mf.Professor, "year": mf.Year,
+		"type": mf.Type, "lang": mf.Language, "tag": mf.Tag,
+	} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+}
+
+// Empty reports whether the filter accepts every file
+func (mf metadataFilter) Empty() bool {
+	return mf == metadataFilter{}
+}
+
+// match reports whether m satisfies the filter.
+// Professors match by substring, the other fields must be equal.
+func (mf metadataFilter) match(m fs.Metadata) bool {
+	eq := func(filter, value string) bool {
+		return filter == "" || strings.EqualFold(filter, value)
+	}
+	if !eq(mf.Course, m.Course) || !eq(mf.Year, m.Year) || !eq(mf.Type, m.Type) || !eq(mf.Language, m.Language) {
+		return false
+	}
+	if mf.Professor != "" && !strings.Contains(strings.ToLower(m.Professor), strings.ToLower(mf.Professor)) {
+		return false
+	}
+	if mf.Tag == "" {
+		return true
+	}
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, mf.Tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewMetadataHandler(ts *Templates, db *bolt.DB, m *mailer.M, admins []string, prefix string) *MetadataHandler {
+	return &MetadataHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		admins: admins,
+		prefix: prefix,
+	}
+}
+
+// MetadataHandler lets admins and maintainers edit the metadata of a file.
+// Edits are applied once the editor confirms them through the link sent by email.
+type MetadataHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	admins []string
+	prefix string
+}
+
+// render shows the edit form of the file at name, or the confirmation of a submitted edit
+func (mh *MetadataHandler) render(rw http.ResponseWriter, name string, file fs.DBFile, email string) {
+	rw.WriteHeader(http.StatusOK)
+	mh.ts.Render(rw, "metadata.html", struct {
+		Path      string
+		Directory string
+		File      fs.DBFile
+		Tags      string
+		Types     []string
+		Sent      bool
+		Email     string
+	}{
+		Path:      name,
+		Directory: fs.FileDir(name),
+		File:      file,
+		Tags:      strings.Join(file.Tags, ", "),
+		Types:     fs.DocumentTypes,
+		Sent:      email != "",
+		Email:     email,
+	})
+}
+
+// confirm applies the edit identified by token
+func (mh *MetadataHandler) confirm(rw http.ResponseWriter, req *http.Request, name, token string) error {
+	status, message := 0, ""
+	err := mh.db.Update(func(tx *bolt.Tx) error {
+		edit, st, msg, err := takeEdit(tx, mh.admins, token, name, fs.EditMetadata)
+		if err != nil || st != 0 {
+			status, message = st, msg
+			return err
+		}
+		return audit.Change(tx, newEntry(req, edit.Email, audit.Metadata, name), func() error {
+			return updateFile(tx, name, func(dbf *fs.DBFile) error {
+				dbf.Metadata = *edit.Metadata
+				log.Printf("[info] %s edited the metadata of %s\n", edit.Email, name)
+				return nil
+			})
+		})
+	})
+	if err == errNoFile {
+		status, message, err = http.StatusNotFound, "The file does not exist anymore.", nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		mh.ts.Error(rw, status, message)
+		return nil
+	}
+	http.Redirect(rw, req, urlPath("/preview"+name), http.StatusSeeOther)
+	return nil
+}
+
+// submit stores an edit and sends the confirmation email to the editor
+func (mh *MetadataHandler) submit(rw http.ResponseWriter, req *http.Request, name string, file fs.DBFile) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		mh.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	meta, err := parseMetadata(req)
+	if err != nil {
+		mh.ts.Error(rw, http.StatusBadRequest, "The metadata are not valid: "+err.Error())
+		return nil
+	}
+
+	edit := fs.DBEdit{
+		Kind:      fs.EditMetadata,
+		Path:      name,
+		Metadata:  &meta,
+		Email:     strings.ToLower(ma.Address),
+		CreatedAt: time.Now(),
+	}
+	var (
+		token   string
+		allowed bool
+	)
+	err = mh.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, allowed, err = storeEdit(tx, mh.admins, edit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if allowed {
+		go func() {
+			link := (&url.URL{Path: mh.prefix + name, RawQuery: "token=" + token}).String()
+			if err := mh.m.ConfirmEdit(edit.Email, "the metadata of "+name, link); err != nil {
+				log.Printf("[err] sending confirmation email for the metadata of %s: %s\n", name, err)
+			}
+		}()
+	} else {
+		// every address gets the same answer, so that it does not tell who maintains the course
+		log.Printf("[warn] %s is not allowed to edit the metadata of %s\n", edit.Email, name)
+	}
+
+	mh.render(rw, name, file, edit.Email)
+	return nil
+}
+
+func (mh *MetadataHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, mh.prefix))
+	file, found, err := publishedFile(mh.db, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		mh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST":
+		return mh.submit(rw, req, name, file)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return mh.confirm(rw, req, name, req.FormValue("token"))
+	}
+	mh.render(rw, name, file, "")
+	return nil
+}
@@ -0,0 +1,25 @@
+package views
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMetadataHandlerStrangers(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	mh := ToHandler(NewMetadataHandler(env.ts, env.db, env.m, []string{"admin@unitn.it"}, "/metadata"), env.ts)
+
+	form := url.Values{"email": {"nobody@unitn.it"}, "course": {"145012"}}
+	if rw := env.do(mh, "POST", "/metadata/Fisica/a.txt", form); rw.Code != 200 {
+		t.Errorf("expected strangers to get the usual answer, got %d", rw.Code)
+	}
+	env.noMail(t)
+	form.Set("email", "Admin@unitn.it")
+	if rw := env.do(mh, "POST", "/metadata/Fisica/a.txt", form); rw.Code != 200 {
+		t.Errorf("expected the edit to be stored, got %d", rw.Code)
+	}
+	if m, ok := env.mail(); !ok || m.To != "admin@unitn.it" {
+		t.Errorf("expected the confirmation to the admin, got %+v", m)
+	}
+}
@@ -0,0 +1,127 @@
+package views
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/search"
+)
+
+func TestModeration(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	ex := search.NewExtractor(env.db, env.dir, 10)
+	uh := ToHandler(NewUploadHandler(env.dir, env.ts, env.db, env.m, true, "/upload"), env.ts)
+	ch := ToHandler(NewConfirmHandler(env.dir, env.ts, env.db, env.m, ex, []string{"mod@unitn.it"}, "/confirm"), env.ts)
+	ah := ToHandler(NewAdminHandler(env.dir, env.ts, env.db, env.m, ex, []string{"admin@unitn.it"}, []string{"mod@unitn.it"}, "/admin"), env.ts)
+	content := func(name string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(string(env.dir), name))
+		return string(b)
+	}
+	upload := func(name, text, email string) {
+		t.Helper()
+		if rw := env.upload(t, uh, "/upload/Fisica", name, text, email); rw.Code != 200 {
+			t.Fatalf("expected %s to be uploaded, got %d", name, rw.Code)
+		}
+		mail, _ := env.mail()
+		if rw := env.do(ch, "GET", confirmLinkRe.FindString(mail.Text), nil); rw.Code != 200 {
+			t.Fatalf("expected %s to be confirmed, got %d", name, rw.Code)
+		}
+		if mail, ok := env.mail(); !ok || mail.To != "mod@unitn.it" {
+			t.Fatalf("expected the moderator to be notified, got %+v", mail)
+		}
+	}
+	upload("new.txt", "new file", "z@unitn.it")
+	upload("spam.txt", "spam", "z@unitn.it")
+	upload("a.txt", "second revision", "a@unitn.it")
+	upload("b.md", "bad revision", "a@unitn.it")
+	if dbf, _ := env.file(t, "/Fisica/new.txt"); !dbf.Authorized || !dbf.AwaitingApproval {
+		t.Errorf("expected the upload to wait for a moderator, got %+v", dbf)
+	}
+	if content("/Fisica/a.txt") == "second revision" {
+		t.Error("expected the revision not to be applied before its approval")
+	}
+
+	mod := env.signIn(t, ah, "mod@unitn.it")
+	rw := mod("GET", "/admin/moderation", nil)
+	for _, p := range []string{"/Fisica/new.txt", "/Fisica/spam.txt", "/Fisica/a.txt", "/Fisica/b.md"} {
+		if !strings.Contains(rw.Body.String(), p) {
+			t.Errorf("expected %s in the moderation queue", p)
+		}
+	}
+	// moderators read only the uploads waiting for them
+	for target, want := range map[string]int{
+		"/admin/content/Fisica/new.txt":     200,
+		"/admin/content/Fisica/a.txt?rev=2": 200,
+		"/admin/content/Fisica/a.txt":       403,
+		"/admin/content/Fisica/a.txt?rev=1": 403,
+		"/admin/content/Fisica/hidden.txt":  403,
+	} {
+		if rw := mod("GET", target, nil); rw.Code != want {
+			t.Errorf("GET %s: expected %d, got %d", target, want, rw.Code)
+		}
+	}
+	moderate := func(name, action, rev string) int {
+		return mod("POST", "/admin/", url.Values{"path": {name}, "action": {action}, "rev": {rev}, "back": {"moderation"}}).Code
+	}
+
+	if code := moderate("/Fisica/new.txt", "approve", ""); code != 303 {
+		t.Errorf("expected the upload to be approved, got %d", code)
+	}
+	if dbf, _ := env.file(t, "/Fisica/new.txt"); dbf.AwaitingApproval || dbf.ApprovedBy != "mod@unitn.it" {
+		t.Errorf("expected the approval to be recorded, got %+v", dbf)
+	}
+	if code := moderate("/Fisica/new.txt", "approve", ""); code != 409 {
+		t.Errorf("expected a second approval to conflict, got %d", code)
+	}
+	if rw := mod("GET", "/admin/content/Fisica/new.txt", nil); rw.Code != 403 {
+		t.Errorf("expected approved files not to be served to moderators, got %d", rw.Code)
+	}
+
+	if code := moderate("/Fisica/spam.txt", "reject", ""); code != 303 {
+		t.Errorf("expected the upload to be rejected, got %d", code)
+	}
+	if _, found := env.file(t, "/Fisica/spam.txt"); found || content("/Fisica/spam.txt") != "" {
+		t.Error("expected the rejected upload to be deleted")
+	}
+
+	if code := moderate("/Fisica/a.txt", "approve", "2"); code != 303 {
+		t.Errorf("expected the revision to be approved, got %d", code)
+	}
+	if dbf, _ := env.file(t, "/Fisica/a.txt"); dbf.Revision != 2 || dbf.ApprovedBy != "mod@unitn.it" || content("/Fisica/a.txt") != "second revision" {
+		t.Errorf("expected the revision to be applied, got %+v", dbf)
+	}
+	if content(fs.RevisionPath("/Fisica/a.txt", 1)) == "" {
+		t.Error("expected the replaced content to be kept")
+	}
+
+	old := content("/Fisica/b.md")
+	if code := moderate("/Fisica/b.md", "reject", "2"); code != 303 {
+		t.Errorf("expected the revision to be rejected, got %d", code)
+	}
+	if content("/Fisica/b.md") != old || content(fs.RevisionPath("/Fisica/b.md", 2)) != "" {
+		t.Error("expected the rejected revision to be removed")
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if revs, _ := fs.Revisions(tx, "/Fisica/b.md"); len(revs) != 0 {
+			t.Errorf("expected no revisions left, got %+v", revs)
+		}
+		return nil
+	})
+	if code := moderate("/Fisica/b.md", "reject", "2"); code != 404 {
+		t.Errorf("expected a missing revision not to be found, got %d", code)
+	}
+
+	// moderators only approve and reject
+	if code := moderate("/Fisica/a.txt", "delete", ""); code != 403 {
+		t.Errorf("expected moderators not to delete files, got %d", code)
+	}
+	if rw := mod("GET", "/admin/moderation", nil); strings.Contains(rw.Body.String(), "/Fisica/") {
+		t.Errorf("expected the queue to be empty, got:\n%s", rw.Body)
+	}
+}
@@ -0,0 +1,205 @@
+package views
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/comments"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/markdown"
+	"github.com/socialnotes/mirror/ratings"
+	"github.com/socialnotes/mirror/thumbs"
+)
+
+const (
+	// maxMarkdownPreview is the size of the largest markdown file rendered in previews
+	maxMarkdownPreview = 256 << 10
+	// textExcerpt is the length of the excerpt shown for text files
+	textExcerpt = 16 << 10
+)
+
+// textFiles lists the extensions of the files shown as plain text excerpts
+var textFiles = map[string]bool{
+	".txt": true, ".csv": true, ".tex": true, ".log": true,
+	".c": true, ".h": true, ".cpp": true, ".java": true, ".py": true,
+	".go": true, ".m": true, ".r": true, ".sql": true, ".json": true, ".xml": true,
+}
+
+// isMarkdown reports whether the file named name is rendered as markdown
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// readPrefix reads at most n bytes from the beginning of the file at name,
+// truncated reports whether the file is longer than n
+func readPrefix(dir fs.Dir, name string, n int64) (data []byte, truncated bool, err error) {
+	f, err := dir.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(f, n+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > n {
+		data, truncated = data[:n], true
+	}
+	return data, truncated, nil
+}
+
+func NewPreviewHandler(fs fs.Dir, ts *Templates, db *bolt.DB, prefix string) *PreviewHandler {
+	return &PreviewHandler{
+		fs: fs,
+		ts: ts,
+		db: db,
+
+		prefix: prefix,
+	}
+}
+
+// PreviewHandler shows the details of a file along with a preview of its content
+type PreviewHandler struct {
+	fs fs.Dir
+	ts *Templates
+	db *bolt.DB
+
+	prefix string
+}
+
+func (ph *PreviewHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, ph.prefix))
+	file, found, err := publishedFile(ph.db, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		ph.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	var (
+		rendered  template.HTML
+		text      string
+		truncated bool
+	)
+	ext := strings.ToLower(path.Ext(file.Name))
+	switch {
+	case isMarkdown(file.Name) && file.Size <= maxMarkdownPreview:
+		data, _, err := readPrefix(ph.fs, name, maxMarkdownPreview)
+		if err != nil {
+			return err
+		}
+		rendered = markdown.Render(string(data))
+	case isMarkdown(file.Name) || textFiles[ext]:
+		data, t, err := readPrefix(ph.fs, name, textExcerpt)
+		if err != nil {
+			return err
+		}
+		if t {
+			// do not cut a multi-byte character in half
+			for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+				data = data[:len(data)-1]
+			}
+		}
+		if utf8.Valid(data) {
+			text, truncated = string(data), t
+		}
+	}
+
+	var (
+		score  ratings.Score
+		thread []comments.Comment
+	)
+	err = ph.db.View(func(tx *bolt.Tx) error {
+		score = ratings.Get(tx, name)
+		thread, err = comments.List(tx, name, false)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := path.Dir(name)
+	if dir != "/" {
+		dir += "/"
+	}
+	rw.WriteHeader(http.StatusOK)
+	ph.ts.Render(rw, "preview.html", struct {
+		Path      string
+		Directory string
+		File      fs.DBFile
+		Thumbnail bool
+		Markdown  template.HTML
+		Text      string
+		Truncated bool
+		Rating    ratings.Score
+		Comments  []comments.Comment
+	}{
+		Path:      name,
+		Directory: dir,
+		File:      file,
+		Thumbnail: thumbs.Supported(file.Name),
+		Markdown:  rendered,
+		Text:      text,
+		Truncated: truncated,
+		Rating:    score,
+		Comments:  thread,
+	})
+	return nil
+}
+
+func NewThumbHandler(fs fs.Dir, db *bolt.DB, cache *thumbs.Cache, prefix string) *ThumbHandler {
+	return &ThumbHandler{
+		fs:    fs,
+		db:    db,
+		cache: cache,
+
+		prefix: prefix,
+	}
+}
+
+// ThumbHandler serves the thumbnails of published images
+type ThumbHandler struct {
+	fs    fs.Dir
+	db    *bolt.DB
+	cache *thumbs.Cache
+
+	prefix string
+}
+
+func (th *ThumbHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, th.prefix))
+	file, found, err := publishedFile(th.db, name)
+	if err != nil {
+		return err
+	}
+	if !found || !thumbs.Supported(file.Name) {
+		return ViewErr(os.ErrNotExist, http.StatusNotFound)
+	}
+
+	size := thumbs.Small
+	if req.FormValue("size") == "large" {
+		size = thumbs.Large
+	}
+	thumb, err := th.cache.Get(th.fs, name, file, size)
+	if err != nil {
+		return ViewErr(err, http.StatusNotFound)
+	}
+	f, err := os.Open(thumb)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rw.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(rw, req, "thumbnail.jpg", file.ModTime, f)
+	return nil
+}
@@ -0,0 +1,142 @@
+package views
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/ratings"
+)
+
+func NewRateHandler(ts *Templates, db *bolt.DB, m *mailer.M, prefix string) *RateHandler {
+	return &RateHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		prefix: prefix,
+	}
+}
+
+// RateHandler lets students rate files.
+// Votes are counted once the voter confirms them through the link sent by email,
+// every email has a single vote per file which is replaced by later ones.
+type RateHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	prefix string
+}
+
+// confirm counts the vote identified by token
+func (rh *RateHandler) confirm(rw http.ResponseWriter, req *http.Request, name, token string) error {
+	status, message := 0, ""
+	err := rh.db.Update(func(tx *bolt.Tx) error {
+		p, found, err := ratings.TakePending(tx, token)
+		switch {
+		case err != nil:
+			return err
+		case !found:
+			status, message = http.StatusNotFound, "The vote does not exist or it has already been confirmed."
+			return nil
+		case p.Path != name:
+			status, message = http.StatusBadRequest, "The vote belongs to another file."
+			return nil
+		case time.Since(p.CreatedAt) > editExpiry:
+			status, message = http.StatusGone, "The vote has expired, please rate the file again."
+			return nil
+		}
+		log.Printf("[info] %s rated %s with %d\n", p.Email, name, p.Rating)
+		return ratings.Vote(tx, p.Path, p.Email, p.Rating)
+	})
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		rh.ts.Error(rw, status, message)
+		return nil
+	}
+	http.Redirect(rw, req, urlPath("/preview"+name), http.StatusSeeOther)
+	return nil
+}
+
+// submit stores a vote and sends the confirmation email to the voter
+func (rh *RateHandler) submit(rw http.ResponseWriter, req *http.Request, name string) error {
+	email, err := checkEmail(req.FormValue("email"))
+	if err != nil {
+		rh.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid. Remember that only unitn.it email addresses are accepted.")
+		return nil
+	}
+	rating, err := strconv.Atoi(req.FormValue("rating"))
+	if err != nil || rating < ratings.MinRating || rating > ratings.MaxRating {
+		rh.ts.Error(rw, http.StatusBadRequest, "The rating must be a number between 1 and 5.")
+		return nil
+	}
+
+	p := ratings.Pending{
+		Path:      name,
+		Email:     strings.ToLower(email),
+		Rating:    rating,
+		CreatedAt: time.Now(),
+	}
+	var token string
+	err = rh.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, err = ratings.AddPending(tx, p)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: rh.prefix + name, RawQuery: "token=" + token}).String()
+		if err := rh.m.ConfirmVote(p.Email, path.Base(name), link); err != nil {
+			log.Printf("[err] sending confirmation email for the rating of %s: %s\n", name, err)
+		}
+	}()
+
+	rw.WriteHeader(http.StatusOK)
+	rh.ts.Render(rw, "rate.html", struct {
+		Path   string
+		Email  string
+		Rating int
+	}{
+		Path:   name,
+		Email:  p.Email,
+		Rating: rating,
+	})
+	return nil
+}
+
+func (rh *RateHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, rh.prefix))
+	_, found, err := publishedFile(rh.db, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		rh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST":
+		return rh.submit(rw, req, name)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return rh.confirm(rw, req, name, req.FormValue("token"))
+	}
+	// the rating form is part of the preview page
+	http.Redirect(rw, req, urlPath("/preview"+name), http.StatusFound)
+	return nil
+}
@@ -0,0 +1,158 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/socialnotes/mirror/ratelimit"
+)
+
+// RateLimits bounds the requests which send emails, by the address of the client,
+// by the email they are sent to and overall
+type RateLimits struct {
+	IP     *ratelimit.Limiter
+	Email  *ratelimit.Limiter
+	Global *ratelimit.Limiter
+}
+
+// NewRateLimits returns the RateLimits applying the given limits
+func NewRateLimits(ip, email, global ratelimit.Limit) *RateLimits {
+	return &RateLimits{
+		IP:     ratelimit.New(ip),
+		Email:  ratelimit.New(email),
+		Global: ratelimit.New(global),
+	}
+}
+
+// allow takes a token for req from every limiter, wait is the time
+// after which the request would be allowed if it is not
+func (rl *RateLimits) allow(req *http.Request) (ok bool, wait time.Duration, reason string) {
+	if ok, wait := rl.IP.Allow(clientIP(req)); !ok {
+		return false, wait, "client " + clientIP(req)
+	}
+	// the form is parsed after the cheaper limit, the handler rejects invalid addresses
+	if ma, err := mail.ParseAddress(req.FormValue("email")); err == nil {
+		email := strings.ToLower(ma.Address)
+		if ok, wait := rl.Email.Allow(email); !ok {
+			return false, wait, "email " + email
+		}
+	}
+	// the global limit counts only the requests allowed by the others
+	if ok, wait := rl.Global.Allow(""); !ok {
+		return false, wait, "global"
+	}
+	return true, 0, ""
+}
+
+// Limit applies rl to the POST requests served by h, answering
+// 429 Too Many Requests with a Retry-After header to the ones exceeding it
+func Limit(h http.Handler, ts *Templates, rl *RateLimits) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		ok, wait, reason := rl.allow(req)
+		if ok {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		seconds := int(wait / time.Second)
+		if wait%time.Second != 0 {
+			seconds++
+		}
+		log.Printf("[warn] rate limit exceeded for %s on %s, retry in %ds\n", reason, req.URL.Path, seconds)
+		rw.Header().Set("Retry-After", strconv.Itoa(seconds))
+		ts.Error(rw, http.StatusTooManyRequests, fmt.Sprintf("Too many requests, please try again in %s.", humanizeWait(wait)))
+	})
+}
+
+// humanizeWait rounds d up to minutes, or seconds when shorter
+func humanizeWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d seconds", int((d+time.Second-1)/time.Second))
+	}
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes == 1 {
+		return "a minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// ParseNetworks reads a comma separated list of addresses and CIDR networks
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("%s is not an address nor a network", e)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trusted reports whether ip belongs to one of proxies
+func trusted(proxies []*net.IPNet, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP replaces the address of the requests forwarded by one of proxies with the address
+// of the client in the X-Forwarded-For header, the nearest address which is not a trusted proxy.
+// The header of other clients is ignored, as they can forge it.
+func RealIP(h http.Handler, proxies []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fwd := req.Header.Get("X-Forwarded-For")
+		if fwd == "" || !trusted(proxies, clientIP(req)) {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			client = hop
+			if !trusted(proxies, hop) {
+				break
+			}
+		}
+		if client != "" {
+			req.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
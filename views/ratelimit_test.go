@@ -0,0 +1,41 @@
+package views
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/socialnotes/mirror/ratelimit"
+	"github.com/socialnotes/mirror/search"
+)
+
+func TestLimit(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	ex := search.NewExtractor(env.db, env.dir, 10)
+	rl := NewRateLimits(ratelimit.Limit{Burst: 3, Per: time.Hour}, ratelimit.Limit{Burst: 1, Per: time.Hour}, ratelimit.Limit{})
+	h := Limit(ToHandler(NewConfirmHandler(env.dir, env.ts, env.db, env.m, ex, nil, "/confirm"), env.ts), env.ts, rl)
+	resend := func(email string) int {
+		return env.do(h, "POST", "/confirm/resend", url.Values{"email": {email}}).Code
+	}
+
+	if code := resend("a@unitn.it"); code != 200 {
+		t.Errorf("expected the first request to be allowed, got %d", code)
+	}
+	env.mail()
+	if code := resend("A@unitn.it"); code != 429 {
+		t.Errorf("expected the same address to be limited, got %d", code)
+	}
+	env.noMail(t)
+	if code := resend("b@unitn.it"); code != 200 {
+		t.Errorf("expected another address to be allowed, got %d", code)
+	}
+	rw := env.do(h, "POST", "/confirm/resend", url.Values{"email": {"c@unitn.it"}})
+	if rw.Code != 429 || rw.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the client to be limited with a Retry-After, got %d %v", rw.Code, rw.Header())
+	}
+	// only the forms are limited
+	if rw := env.do(h, "GET", "/confirm/resend", nil); rw.Code != 200 {
+		t.Errorf("expected the page of the form not to be limited, got %d", rw.Code)
+	}
+}
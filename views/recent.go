@@ -0,0 +1,177 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/recent"
+)
+
+const recentPerPage = 50
+
+// recentFile is a file in the recently published list
+type recentFile struct {
+	Path        string
+	PublishedAt time.Time
+	File        fs.DBFile
+}
+
+// apiRecentFile is the JSON representation of a recently published file
+type apiRecentFile struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	PublishedAt time.Time `json:"published"`
+	URL         string    `json:"url"`
+}
+
+// apiRecent is the JSON representation of a page of recently published files
+type apiRecent struct {
+	Page  int             `json:"page"`
+	Next  string          `json:"next,omitempty"`
+	Files []apiRecentFile `json:"files"`
+}
+
+// recentPage returns the published files on the given page of the time index,
+// more reports whether there are further pages
+func recentPage(db *bolt.DB, page int) (files []recentFile, more bool, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		files, more = newestFiles(tx, "/", (page-1)*recentPerPage, recentPerPage)
+		return nil
+	})
+	return files, more, err
+}
+
+// newestFiles returns at most n published files whose path starts with prefix,
+// newest first, skipping the first skip of them. more reports whether older files follow.
+func newestFiles(tx *bolt.Tx, prefix string, skip, n int) (files []recentFile, more bool) {
+	bucket := tx.Bucket(fs.FilesBucket)
+	published := make(map[string]fs.DBFile)
+	entries, more := recent.Newest(tx, skip, n, func(path string) bool {
+		if !strings.HasPrefix(path, prefix) {
+			return false
+		}
+		v := bucket.Get([]byte(path))
+		if v == nil {
+			return false
+		}
+		dbf := fs.DBFile{}
+		if err := json.Unmarshal(v, &dbf); err != nil || !dbf.Published() {
+			return false
+		}
+		published[path] = dbf
+		return true
+	})
+	files = make([]recentFile, 0, len(entries))
+	for _, e := range entries {
+		files = append(files, recentFile{
+			Path:        e.Path,
+			PublishedAt: e.Time,
+			File:        published[e.Path],
+		})
+	}
+	return files, more
+}
+
+// parsePage returns the page requested with the page parameter, starting from 1
+func parsePage(req *http.Request) int {
+	page, err := strconv.Atoi(req.FormValue("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+func NewRecentHandler(ts *Templates, db *bolt.DB) *RecentHandler {
+	return &RecentHandler{
+		ts: ts,
+		db: db,
+	}
+}
+
+// RecentHandler lists the files most recently published in any directory
+type RecentHandler struct {
+	ts *Templates
+	db *bolt.DB
+}
+
+func (rh *RecentHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	page := parsePage(req)
+	files, more, err := recentPage(rh.db, page)
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rh.ts.Render(rw, "recent.html", struct {
+		Files    []recentFile
+		Page     int
+		HasPrev  bool
+		HasNext  bool
+		PrevPage int
+		NextPage int
+	}{
+		Files:    files,
+		Page:     page,
+		HasPrev:  page > 1,
+		HasNext:  more,
+		PrevPage: page - 1,
+		NextPage: page + 1,
+	})
+	return nil
+}
+
+func NewRecentAPIHandler(db *bolt.DB, prefix string) *RecentAPIHandler {
+	return &RecentAPIHandler{
+		db: db,
+
+		prefix: prefix,
+	}
+}
+
+// RecentAPIHandler serves the files most recently published in any directory as JSON
+type RecentAPIHandler struct {
+	db *bolt.DB
+
+	prefix string
+}
+
+func (rh *RecentAPIHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		writeJSONError(rw, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return nil
+	}
+
+	page := parsePage(req)
+	files, more, err := recentPage(rh.db, page)
+	if err != nil {
+		return err
+	}
+
+	data := apiRecent{
+		Page:  page,
+		Files: make([]apiRecentFile, 0, len(files)),
+	}
+	if more {
+		data.Next = rh.prefix + "/?page=" + strconv.Itoa(page+1)
+	}
+	for _, f := range files {
+		data.Files = append(data.Files, apiRecentFile{
+			Path:        f.Path,
+			Name:        f.File.Name,
+			Size:        f.File.Size,
+			ModTime:     f.File.ModTime,
+			PublishedAt: f.PublishedAt,
+			URL:         urlPath(f.Path),
+		})
+	}
+
+	// pages shift whenever a file is published, so only the ETag is reliable
+	return serveJSON(rw, req, data, time.Time{})
+}
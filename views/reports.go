@@ -0,0 +1,277 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/reports"
+)
+
+// maxReportDetails is the length in characters of the longest report details accepted
+const maxReportDetails = 4000
+
+func NewReportHandler(ts *Templates, db *bolt.DB, m *mailer.M, admins []string, threshold int, prefix string) *ReportHandler {
+	return &ReportHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		admins:    admins,
+		threshold: threshold,
+		prefix:    prefix,
+	}
+}
+
+// ReportHandler collects the reports of problematic files.
+// Reports are counted once the reporter confirms them by email, a file reported by
+// threshold distinct unitn emails is hidden until an admin reviews it and restores it.
+// A threshold of zero never hides files. The reports are listed in the admin dashboard.
+type ReportHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	admins    []string
+	threshold int
+	prefix    string
+}
+
+// render shows the report form of the file at name, or the review form
+// if review is true. sent is the message shown after a submission, if any.
+func (rh *ReportHandler) render(rw http.ResponseWriter, name string, file fs.DBFile, review bool, sent string) error {
+	rw.WriteHeader(http.StatusOK)
+	rh.ts.Render(rw, "flag.html", struct {
+		Path    string
+		File    fs.DBFile
+		Reasons []reports.Reason
+		Review  bool
+		Sent    string
+	}{
+		Path:    name,
+		File:    file,
+		Reasons: reports.Reasons,
+		Review:  review,
+		Sent:    sent,
+	})
+	return nil
+}
+
+// report stores a report and sends the confirmation email to the reporter
+func (rh *ReportHandler) report(rw http.ResponseWriter, req *http.Request, name string, file fs.DBFile) error {
+	// copyright holders rarely have a unitn address, every address is accepted
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		rh.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	reason := req.FormValue("reason")
+	if !reports.IsReason(reason) {
+		rh.ts.Error(rw, http.StatusBadRequest, "Please choose the reason of your report.")
+		return nil
+	}
+	details := strings.TrimSpace(strings.Replace(req.FormValue("details"), "\r\n", "\n", -1))
+	if utf8.RuneCountInString(details) > maxReportDetails {
+		rh.ts.Error(rw, http.StatusBadRequest, fmt.Sprintf("The details must be shorter than %d characters.", maxReportDetails))
+		return nil
+	}
+
+	r := reports.Report{
+		Path:      name,
+		Email:     strings.ToLower(ma.Address),
+		Reason:    reason,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+	var token string
+	err = rh.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, err = reports.AddPending(tx, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: rh.prefix + name, RawQuery: "token=" + token}).String()
+		if err := rh.m.ConfirmReport(r.Email, path.Base(name), link); err != nil {
+			log.Printf("[err] sending confirmation email for the report of %s: %s\n", name, err)
+		}
+	}()
+
+	return rh.render(rw, name, file, false, "You will receive an email with a confirmation link. Your report will reach the admins once you press on the link.")
+}
+
+// review stores the decision of an admin and sends the confirmation email
+func (rh *ReportHandler) review(rw http.ResponseWriter, req *http.Request, name string, file fs.DBFile) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		rh.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	action := req.FormValue("action")
+	if action != fs.ReviewHide && action != fs.ReviewRestore {
+		rh.ts.Error(rw, http.StatusBadRequest, "The review action is not valid.")
+		return nil
+	}
+	email := strings.ToLower(ma.Address)
+	sent := "If you are an admin you will receive an email with a confirmation link. The file will be " + map[string]string{
+		fs.ReviewHide:    "hidden",
+		fs.ReviewRestore: "restored",
+	}[action] + " once you press on the link."
+	// everyone gets the same answer, so that the page does not tell who the admins are
+	if !isAdmin(rh.admins, email) {
+		log.Printf("[warn] review of %s by %s refused, not an admin\n", name, email)
+		return rh.render(rw, name, file, true, sent)
+	}
+
+	edit := fs.DBEdit{
+		Kind:         fs.EditReview,
+		Path:         name,
+		ReviewAction: action,
+		Email:        email,
+		CreatedAt:    time.Now(),
+	}
+	var token string
+	err = rh.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, _, err = storeEdit(tx, rh.admins, edit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: rh.prefix + name, RawQuery: "token=" + token}).String()
+		if err := rh.m.ConfirmEdit(email, "the visibility of "+name+" ("+action+")", link); err != nil {
+			log.Printf("[err] sending confirmation email for the review of %s: %s\n", name, err)
+		}
+	}()
+
+	return rh.render(rw, name, file, true, sent)
+}
+
+// confirm counts the report, or applies the review, identified by token
+func (rh *ReportHandler) confirm(rw http.ResponseWriter, req *http.Request, name, token string) error {
+	status, message := 0, ""
+	var (
+		dbf    fs.DBFile
+		done   string
+		review bool
+	)
+	err := rh.db.Update(func(tx *bolt.Tx) error {
+		r, found, err := reports.TakePending(tx, token)
+		if err != nil {
+			return err
+		}
+		if found {
+			switch {
+			case r.Path != name:
+				status, message = http.StatusBadRequest, "The report belongs to another file."
+				return nil
+			case time.Since(r.CreatedAt) > editExpiry:
+				status, message = http.StatusGone, "The report has expired, please submit it again."
+				return nil
+			}
+			if err := reports.Add(tx, r); err != nil {
+				return err
+			}
+			log.Printf("[info] %s reported %s as %s\n", r.Email, name, r.Reason)
+			done = "Thank you, your report has been sent to the admins."
+			v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+			if v == nil {
+				return errNoFile
+			}
+			if err := json.Unmarshal(v, &dbf); err != nil {
+				return err
+			}
+			// anyone can report a file, but only the community can hide it
+			n, err := reports.CountSince(tx, name, dbf.ReviewedAt, func(email string) bool {
+				_, err := checkEmail(email)
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+			if rh.threshold == 0 || n < rh.threshold || dbf.Flagged {
+				return nil
+			}
+			e := newEntry(req, r.Email, audit.Hide, name)
+			e.Details = fmt.Sprintf("%d reports", n)
+			return audit.Change(tx, e, func() error {
+				return updateFile(tx, name, func(f *fs.DBFile) error {
+					f.Flagged = true
+					log.Printf("[warn] %s is hidden after %d reports, it needs a review\n", name, n)
+					dbf = *f
+					return nil
+				})
+			})
+		}
+
+		edit, st, msg, err := takeEdit(tx, rh.admins, token, name, fs.EditReview)
+		if err != nil || st != 0 {
+			status, message = st, msg
+			return err
+		}
+		if !isAdmin(rh.admins, edit.Email) {
+			status, message = http.StatusBadRequest, "Only admins can review files."
+			return nil
+		}
+		log.Printf("[info] %s reviewed %s: %s\n", edit.Email, name, edit.ReviewAction)
+		done, review = "The review has been applied.", true
+		return audit.Change(tx, newEntry(req, edit.Email, edit.ReviewAction, name), func() error {
+			dbf, err = ReviewFile(tx, name, edit.ReviewAction)
+			return err
+		})
+	})
+	if err == errNoFile {
+		status, message, err = http.StatusNotFound, "The file does not exist anymore.", nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		rh.ts.Error(rw, status, message)
+		return nil
+	}
+	return rh.render(rw, name, dbf, review, done)
+}
+
+func (rh *ReportHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, rh.prefix))
+	// hidden files can still be reviewed and reported
+	file, found, err := storedFile(rh.db, name)
+	if err != nil {
+		return err
+	}
+	if !found || !file.Authorized || file.AwaitingApproval {
+		rh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST" && req.FormValue("action") != "":
+		return rh.review(rw, req, name, file)
+	case req.Method == "POST":
+		return rh.report(rw, req, name, file)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return rh.confirm(rw, req, name, req.FormValue("token"))
+	}
+	return rh.render(rw, name, file, req.FormValue("review") != "", "")
+}
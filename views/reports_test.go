@@ -0,0 +1,131 @@
+package views
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/pending"
+	"github.com/socialnotes/mirror/reports"
+)
+
+// mailToken returns the token of the confirmation link in the text of mail
+func mailToken(t *testing.T, mail testMail) string {
+	t.Helper()
+	i := strings.Index(mail.Text, "token=")
+	if i < 0 {
+		t.Fatalf("expected a confirmation link, got %q", mail.Text)
+	}
+	token := mail.Text[i+len("token="):]
+	return token[:strings.IndexAny(token, "\n ")]
+}
+
+func TestReportHandler(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	rh := ToHandler(NewReportHandler(env.ts, env.db, env.m, []string{"admin@unitn.it"}, 2, "/report"), env.ts)
+	report := func(email string) {
+		t.Helper()
+		form := url.Values{"email": {email}, "reason": {"copyright"}, "details": {"chapter 3 of my book"}}
+		if rw := env.do(rh, "POST", "/report/Fisica/a.txt", form); rw.Code != 200 {
+			t.Fatalf("expected the report to be accepted, got %d", rw.Code)
+		}
+		mail, ok := env.mail()
+		if !ok || mail.To != email {
+			t.Fatalf("expected a confirmation for %s, got %+v", email, mail)
+		}
+		if rw := env.do(rh, "GET", "/report/Fisica/a.txt?token="+mailToken(t, mail), nil); rw.Code != 200 {
+			t.Fatalf("expected the report to be confirmed, got %d", rw.Code)
+		}
+	}
+	flagged := func() bool {
+		dbf, _ := env.file(t, "/Fisica/a.txt")
+		return dbf.Flagged
+	}
+
+	// anyone can report a file, but only unitn addresses hide it
+	report("holder@publisher.com")
+	report("other@publisher.com")
+	if flagged() {
+		t.Error("expected reports from other addresses not to hide the file")
+	}
+	report("x@unitn.it")
+	if flagged() {
+		t.Error("expected one report from a unitn address not to hide the file")
+	}
+	report("y@unitn.it")
+	if !flagged() {
+		t.Error("expected the file to be hidden after two reports from unitn addresses")
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if list, _ := reports.List(tx, "/Fisica/a.txt"); len(list) != 4 {
+			t.Errorf("expected every report to be stored, got %d", len(list))
+		}
+		return nil
+	})
+
+	// the details and the reporters are only shown to admins
+	rw := env.do(rh, "GET", "/report/Fisica/a.txt?review=1", nil)
+	if body := rw.Body.String(); rw.Code != 200 || strings.Contains(body, "chapter 3") || strings.Contains(body, "publisher.com") {
+		t.Errorf("expected no report details on the public page, got %d:\n%s", rw.Code, body)
+	}
+
+	// strangers get the same answer as admins, but no email
+	review := func(email string) string {
+		rw := env.do(rh, "POST", "/report/Fisica/a.txt", url.Values{"email": {email}, "action": {fs.ReviewRestore}})
+		if rw.Code != 200 {
+			t.Errorf("expected the review to be accepted, got %d", rw.Code)
+		}
+		return rw.Body.String()
+	}
+	stranger := review("x@unitn.it")
+	env.noMail(t)
+	admin := review("admin@unitn.it")
+	if stranger != admin {
+		t.Errorf("expected the same page for every address:\n%s\n%s", stranger, admin)
+	}
+	mail, ok := env.mail()
+	if !ok || mail.To != "admin@unitn.it" {
+		t.Fatalf("expected a confirmation for the admin, got %+v", mail)
+	}
+	if rw := env.do(rh, "GET", "/report/Fisica/a.txt?token="+mailToken(t, mail), nil); rw.Code != 200 || flagged() {
+		t.Errorf("expected the file to be restored, got %d", rw.Code)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	var fresh, expired string
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if fresh, err = reports.AddPending(tx, reports.Report{Path: "/Fisica/a.txt", CreatedAt: time.Now()}); err != nil {
+			return err
+		}
+		expired, err = reports.AddPending(tx, reports.Report{Path: "/Fisica/a.txt", CreatedAt: time.Now().Add(-editExpiry - time.Minute)})
+		if err != nil {
+			return err
+		}
+		_, err = pending.Put(tx, adminLoginsBucket, adminSession{Email: "admin@unitn.it", CreatedAt: time.Now().Add(-adminLoginExpiry - time.Minute)})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PurgeExpired(env.db); err != nil {
+		t.Fatal(err)
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reports.PendingBucket)
+		if b.Get([]byte(fresh)) == nil || b.Get([]byte(expired)) != nil {
+			t.Error("expected only the expired report to be purged")
+		}
+		if n := tx.Bucket(adminLoginsBucket).Stats().KeyN; n != 0 {
+			t.Errorf("expected the expired sign in link to be purged, %d left", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+package views
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/search"
+)
+
+var confirmLinkRe = regexp.MustCompile(`/confirm/[0-9a-f-]{36}`)
+
+func TestRevisions(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	uh := ToHandler(NewUploadHandler(env.dir, env.ts, env.db, env.m, false, "/upload"), env.ts)
+	ch := ToHandler(NewConfirmHandler(env.dir, env.ts, env.db, env.m, search.NewExtractor(env.db, env.dir, 10), nil, "/confirm"), env.ts)
+	hh := ToHandler(NewHistoryHandler(env.dir, env.ts, env.db, "/history"), env.ts)
+	content := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(string(env.dir), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	old := content("/Fisica/a.txt")
+
+	// only the uploader can add a revision
+	if rw := env.upload(t, uh, "/upload/Fisica", "a.txt", "stolen", "b@unitn.it"); rw.Code != 409 {
+		t.Errorf("expected a conflict for another uploader, got %d", rw.Code)
+	}
+	env.noMail(t)
+	if rw := env.upload(t, uh, "/upload/Fisica", "a.txt", "second revision", "a@unitn.it"); rw.Code != 200 {
+		t.Fatalf("expected the revision to be uploaded, got %d: %s", rw.Code, rw.Body)
+	}
+	mail, ok := env.mail()
+	link := confirmLinkRe.FindString(mail.Text)
+	if !ok || mail.To != "a@unitn.it" || link == "" {
+		t.Fatalf("expected a confirmation link for the uploader, got %+v", mail)
+	}
+
+	// the revision is not served nor listed before it is confirmed
+	if got := content("/Fisica/a.txt"); got != old {
+		t.Errorf("expected the file to be unchanged, got %q", got)
+	}
+	rw := env.do(hh, "GET", "/history/Fisica/a.txt", nil)
+	if rw.Code != 200 || strings.Contains(rw.Body.String(), "?rev=") {
+		t.Errorf("expected no older revisions, got %d:\n%s", rw.Code, rw.Body)
+	}
+	if rw := env.do(hh, "GET", "/history/Fisica/a.txt?rev=2", nil); rw.Code != 404 {
+		t.Errorf("expected the pending revision not to be served, got %d", rw.Code)
+	}
+	if rw := env.do(hh, "GET", "/history/Fisica/a.txt?rev=3", nil); rw.Code != 404 {
+		t.Errorf("expected a missing revision not to be found, got %d", rw.Code)
+	}
+
+	if rw := env.do(ch, "GET", link, nil); rw.Code != 200 {
+		t.Fatalf("expected the revision to be confirmed, got %d", rw.Code)
+	}
+	if got := content("/Fisica/a.txt"); got != "second revision" {
+		t.Errorf("expected the new content to be served, got %q", got)
+	}
+	if got := content(fs.RevisionPath("/Fisica/a.txt", 1)); got != old {
+		t.Errorf("expected the old content to be kept, got %q", got)
+	}
+	dbf, _ := env.file(t, "/Fisica/a.txt")
+	if dbf.Revision != 2 || dbf.Size != int64(len("second revision")) {
+		t.Errorf("expected the record of revision 2, got %+v", dbf)
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if revs, err := fs.PendingRevisions(tx, link[len("/confirm/"):]); err != nil || len(revs) != 0 {
+			t.Errorf("expected no pending revisions left, got %v %v", revs, err)
+		}
+		return nil
+	})
+
+	rw = env.do(hh, "GET", "/history/Fisica/a.txt", nil)
+	if !strings.Contains(rw.Body.String(), "2 (current)") || !strings.Contains(rw.Body.String(), "/history/Fisica/a.txt?rev=1") {
+		t.Errorf("expected both revisions to be listed, got:\n%s", rw.Body)
+	}
+	rw = env.do(hh, "GET", "/history/Fisica/a.txt?rev=1", nil)
+	if rw.Code != 200 || rw.Body.String() != old {
+		t.Errorf("expected the old content, got %d %q", rw.Code, rw.Body)
+	}
+	if rw := env.do(hh, "GET", "/history/Fisica/a.txt?rev=2", nil); rw.Code != 302 {
+		t.Errorf("expected the current revision to redirect to the file, got %d", rw.Code)
+	}
+
+	// a token is used once
+	if rw := env.do(ch, "GET", link, nil); rw.Code != 200 || content("/Fisica/a.txt") != "second revision" {
+		t.Errorf("expected nothing to change, got %d", rw.Code)
+	}
+}
+
+func TestApplyRevisionRollback(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	name := "/Fisica/a.txt"
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		return fs.PutRevision(tx, name, fs.DBRevision{Number: 2, Token: "new", Email: "a@unitn.it"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the content of the revision is missing, so the second rename fails
+	err = UpdateFiles(env.db, env.dir, func(tx *bolt.Tx, files *Files) error {
+		r, _, err := fs.GetRevision(tx, name, 2)
+		if err != nil {
+			return err
+		}
+		_, err = ApplyRevision(tx, files, name, r)
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected the revision not to be applied")
+	}
+	if _, err := env.dir.Stat(name); err != nil {
+		t.Errorf("expected the file to be moved back: %s", err)
+	}
+	if _, err := env.dir.Stat(fs.RevisionPath(name, 1)); !os.IsNotExist(err) {
+		t.Errorf("expected no older revision on disk, got %v", err)
+	}
+	if dbf, _ := env.file(t, name); dbf.Revision != 0 {
+		t.Errorf("expected the record to be unchanged, got %+v", dbf)
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if revs, _ := fs.PendingRevisions(tx, "new"); len(revs) != 1 {
+			t.Errorf("expected the revision to be still pending, got %v", revs)
+		}
+		return nil
+	})
+}
+
+func TestRevisionOfHiddenFile(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	uh := ToHandler(NewUploadHandler(env.dir, env.ts, env.db, env.m, false, "/upload"), env.ts)
+	ch := ToHandler(NewConfirmHandler(env.dir, env.ts, env.db, env.m, search.NewExtractor(env.db, env.dir, 10), nil, "/confirm"), env.ts)
+	name := "/Fisica/a.txt"
+	if rw := env.upload(t, uh, "/upload/Fisica", "a.txt", "second revision", "a@unitn.it"); rw.Code != 200 {
+		t.Fatalf("expected the revision to be uploaded, got %d: %s", rw.Code, rw.Body)
+	}
+	mail, _ := env.mail()
+	link := confirmLinkRe.FindString(mail.Text)
+	err := env.db.Update(func(tx *bolt.Tx) error {
+		_, err := ReviewFile(tx, name, fs.ReviewHide)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rw := env.do(ch, "GET", link, nil); rw.Code != 200 {
+		t.Fatalf("expected the confirmation page, got %d", rw.Code)
+	}
+	if dbf, _ := env.file(t, name); dbf.Revision != 0 {
+		t.Errorf("expected the hidden file not to be revised, got %+v", dbf)
+	}
+	env.db.View(func(tx *bolt.Tx) error {
+		if revs, _ := fs.PendingRevisions(tx, link[len("/confirm/"):]); len(revs) != 1 {
+			t.Errorf("expected the revision to be still pending, got %v", revs)
+		}
+		return nil
+	})
+}
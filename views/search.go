@@ -0,0 +1,103 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/search"
+)
+
+const (
+	maxSearchResults = 100
+	snippetWidth     = 240
+)
+
+// searchResult is a published file matching a search query
+type searchResult struct {
+	Path      string
+	Directory string
+	File      fs.DBFile
+	// Snippet is the portion of the document text matching the query, if any
+	Snippet []search.Fragment
+}
+
+func NewSearchHandler(ts *Templates, db *bolt.DB) *SearchHandler {
+	return &SearchHandler{
+		ts: ts,
+		db: db,
+	}
+}
+
+// SearchHandler looks up published files by name and content
+type SearchHandler struct {
+	ts *Templates
+	db *bolt.DB
+}
+
+// find returns the published files under scope matching query, best matches first
+func (sh *SearchHandler) find(query, scope string) ([]searchResult, error) {
+	results := make([]searchResult, 0)
+	return results, sh.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fs.FilesBucket)
+		terms := search.Tokenize(query)
+		for _, r := range search.Search(tx, terms, scope, search.Names, search.Content) {
+			v := bucket.Get([]byte(r.Path))
+			if v == nil {
+				continue
+			}
+			dbf := fs.DBFile{}
+			if err := json.Unmarshal(v, &dbf); err != nil {
+				return err
+			}
+			if !dbf.Published() {
+				continue
+			}
+			dir := path.Dir(r.Path)
+			if dir != "/" {
+				dir += "/"
+			}
+			results = append(results, searchResult{
+				Path:      r.Path,
+				Directory: dir,
+				File:      dbf,
+				Snippet:   search.Snippet(search.Text(tx, r.Path), terms, snippetWidth),
+			})
+			if len(results) == maxSearchResults {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (sh *SearchHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	query := strings.TrimSpace(req.FormValue("q"))
+	scope := "/"
+	if s := req.FormValue("scope"); s != "" {
+		scope = path.Clean("/" + s)
+		if scope != "/" {
+			scope += "/"
+		}
+	}
+
+	results, err := sh.find(query, scope)
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	sh.ts.Render(rw, "search.html", struct {
+		Query   string
+		Scope   string
+		Results []searchResult
+	}{
+		Query:   query,
+		Scope:   scope,
+		Results: results,
+	})
+	return nil
+}
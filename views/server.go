@@ -1,59 +1,109 @@
 package views
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
+	"html/template"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/comments"
 	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/ratings"
+	"github.com/socialnotes/mirror/stats"
 )
 
-func NewServerHandler(fs fs.Dir, ts *Templates, db *bolt.DB) *ServerHandler {
+func NewServerHandler(fs fs.Dir, ts *Templates, db *bolt.DB, counter *stats.Counter) *ServerHandler {
 	return &ServerHandler{
-		fs: fs,
-		ts: ts,
-		db: db,
+		fs:      fs,
+		ts:      ts,
+		db:      db,
+		counter: counter,
 	}
 }
 
 type ServerHandler struct {
-	fs fs.Dir
-	ts *Templates
-	db *bolt.DB
+	fs      fs.Dir
+	ts      *Templates
+	db      *bolt.DB
+	counter *stats.Counter
+}
+
+// statusRecorder remembers the status code written to a ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
 }
 
 func (sh *ServerHandler) list(rw http.ResponseWriter, req *http.Request, path string) error {
 	dirs, files, err := directoryContent(sh.db, path)
+	if err != nil {
+		return errors.New("rendering list template: " + err.Error())
+	}
 
-	if path != "/" {
-		dirs = append([]string{".."}, dirs...)
+	authorizedFiles := make([]listFile, 0, len(files))
+	err = sh.db.View(func(tx *bolt.Tx) error {
+		for _, f := range files {
+			if !f.Published() {
+				continue
+			}
+			n, err := comments.Count(tx, path+f.Name)
+			if err != nil {
+				return err
+			}
+			authorizedFiles = append(authorizedFiles, listFile{
+				DBFile:    f,
+				Downloads: sh.counter.Downloads(tx, path+f.Name),
+				Rating:    ratings.Get(tx, path+f.Name),
+				Comments:  n,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	dbd, _, err := getDirectory(sh.db, path)
 	if err != nil {
-		return errors.New("rendering list template: " + err.Error())
+		return err
+	}
+	description, err := directoryDescription(sh.fs, path, dbd, authorizedFiles)
+	if err != nil {
+		log.Printf("[warn] rendering the description of %s: %s\n", path, err)
 	}
 
-	authorizedFiles := make([]fs.DBFile, 0, len(files))
-	for _, f := range files {
-		if !f.Authorized {
-			continue
-		}
-		authorizedFiles = append(authorizedFiles, f)
+	opts := parseListOptions(req.URL.Query())
+	page := opts.apply(dirs, authorizedFiles)
+	if path != "/" {
+		page.Directories = append([]string{".."}, page.Directories...)
 	}
 
 	rw.WriteHeader(http.StatusOK)
 	sh.ts.Render(rw, "list.html", struct {
 		Path        string
+		Description template.HTML
+		UploadOpen  bool
 		Directories []string
-		Files       []fs.DBFile
+		Files       []listFile
+		Options     listOptions
+		Page        listPage
 	}{
 		Path:        path,
-		Directories: dirs,
-		Files:       authorizedFiles,
+		Description: description,
+		UploadOpen:  dbd.UploadOpen,
+		Directories: page.Directories,
+		Files:       page.Files,
+		Options:     opts,
+		Page:        page,
 	})
 	return nil
 }
@@ -61,38 +111,32 @@ func (sh *ServerHandler) list(rw http.ResponseWriter, req *http.Request, path st
 func (sh *ServerHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
 	var (
 		path  = req.URL.Path
-		found = true
+		found = false
 		isDir = false
 		file  = fs.DBFile{}
 	)
 
 	err := sh.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(fs.FilesBucket)
-		prefix := []byte(path)
-		c := bucket.Cursor()
-		k, v := c.Seek(prefix)
-		if k == nil || !bytes.HasPrefix(k, prefix) {
-			found = false
-			return nil
+		var err error
+		if strings.HasSuffix(path, "/") {
+			_, isDir, err = fs.GetDirectory(tx, path)
+			return err
 		}
 
 		// it's a file
-		if bytes.Equal(k, prefix) {
+		if v := tx.Bucket(fs.FilesBucket).Get([]byte(path)); v != nil {
+			found = true
 			return json.Unmarshal(v, &file)
 		}
-		isDir = true
-		return nil
+		// or a directory missing the trailing slash
+		_, isDir, err = fs.GetDirectory(tx, path+"/")
+		return err
 	})
 
 	if err != nil {
 		return err
 	}
 
-	if !found {
-		sh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
-		return nil
-	}
-
 	if isDir {
 		if !strings.HasSuffix(path, "/") {
 			http.Redirect(rw, req, path+"/", http.StatusTemporaryRedirect)
@@ -101,7 +145,24 @@ func (sh *ServerHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) er
 		return sh.list(rw, req, path)
 	}
 
-	if !file.Authorized {
+	if !found {
+		sh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	if file.Takedown != nil && file.Authorized {
+		rw.WriteHeader(http.StatusUnavailableForLegalReasons)
+		sh.ts.Render(rw, "takedown.html", struct {
+			Path string
+			File fs.DBFile
+		}{
+			Path: path,
+			File: file,
+		})
+		return nil
+	}
+
+	if !file.Published() {
 		sh.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 		return nil
 	}
@@ -118,6 +179,11 @@ func (sh *ServerHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) er
 		}
 	}
 	defer f.Close()
-	http.ServeContent(rw, req, file.Name, file.ModTime, f)
+	sr := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+	http.ServeContent(sr, req, file.Name, file.ModTime, f)
+	// only count complete downloads: no HEAD, range requests or cache validations
+	if req.Method == "GET" && req.Header.Get("Range") == "" && sr.status == http.StatusOK {
+		sh.counter.Hit(path)
+	}
 	return nil
 }
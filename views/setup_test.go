@@ -0,0 +1,225 @@
+package views
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/internal/dbtest"
+	"github.com/socialnotes/mirror/mailer"
+)
+
+// testMail is an email sent by the handlers under test
+type testMail struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// testEnv holds the archive, the database and the mailer used by handler tests.
+// The archive has the published files /Fisica/a.txt, /Fisica/b.md and /Fisica/sub/c.txt
+// and the unconfirmed /Fisica/hidden.txt, all uploaded by a@unitn.it.
+type testEnv struct {
+	dir fs.Dir
+	ts  *Templates
+	db  *bolt.DB
+	m   *mailer.M
+
+	mails chan testMail
+}
+
+func newTestEnv(t *testing.T) (*testEnv, func()) {
+	t.Helper()
+	db, closeDB := dbtest.Open(t)
+	tmp, err := ioutil.TempDir("", "views")
+	if err != nil {
+		closeDB()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		closeDB()
+		os.RemoveAll(tmp)
+	}
+	ts, err := NewTemplates("../templates", "*.html")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	env := &testEnv{
+		dir:   fs.Dir(tmp),
+		ts:    ts,
+		db:    db,
+		mails: make(chan testMail, 100),
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseMultipartForm(1 << 20)
+		env.mails <- testMail{To: req.FormValue("to"), Subject: req.FormValue("subject"), Text: req.FormValue("text")}
+	}))
+	env.m, _ = mailer.New("example.com", "Test <test@example.com>", "key")
+	env.m.SetEndpoint(s.URL)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		files, err := tx.CreateBucketIfNotExists(fs.FilesBucket)
+		if err != nil {
+			return err
+		}
+		for name, published := range map[string]bool{
+			"/Fisica/a.txt":      true,
+			"/Fisica/b.md":       true,
+			"/Fisica/sub/c.txt":  true,
+			"/Fisica/hidden.txt": false,
+		} {
+			content := []byte("hello world " + name)
+			if err := os.MkdirAll(filepath.Join(tmp, filepath.Dir(name)), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filepath.Join(tmp, name), content, 0644); err != nil {
+				return err
+			}
+			v, _ := json.Marshal(fs.DBFile{
+				Name:       filepath.Base(name),
+				Size:       int64(len(content)),
+				ModTime:    time.Now(),
+				Email:      "a@unitn.it",
+				Token:      "token",
+				Authorized: published,
+			})
+			if err := files.Put([]byte(name), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = PrepareDatabase(db)
+	}
+	if err != nil {
+		s.Close()
+		cleanup()
+		t.Fatal(err)
+	}
+	return env, func() {
+		s.Close()
+		cleanup()
+	}
+}
+
+// do serves a request to h, form is sent as the body of POST requests
+func (env *testEnv) do(h http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == "POST" {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	return rw
+}
+
+// upload posts content as the document name to the upload handler h,
+// dir is the path of the upload page of the destination directory
+func (env *testEnv) upload(t *testing.T, h http.Handler, dir, name, content, email string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("email", email)
+	fw, err := w.CreateFormFile("document", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	w.Close()
+	req := httptest.NewRequest("POST", dir, body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	return rw
+}
+
+// signIn follows the sign in link the admin handler h sends to email, it returns
+// a function serving requests of the session with its CSRF token added to forms
+func (env *testEnv) signIn(t *testing.T, h http.Handler, email string) func(method, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	env.do(h, "POST", "/admin/login", url.Values{"email": {email}})
+	mail, ok := env.mail()
+	i := strings.Index(mail.Text, "/admin/login?token=")
+	if !ok || i < 0 {
+		t.Fatalf("expected the sign in link, got %+v", mail)
+	}
+	link := mail.Text[i:]
+	rw := env.do(h, "GET", link[:strings.IndexByte(link, '\n')], nil)
+	cookies := rw.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected the session cookie, got %d %v", rw.Code, cookies)
+	}
+	var s adminSession
+	env.db.View(func(tx *bolt.Tx) error {
+		return json.Unmarshal(tx.Bucket(adminSessionsBucket).Get([]byte(cookies[0].Value)), &s)
+	})
+
+	return func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == "POST" {
+			form.Set("csrf", s.CSRF)
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		req.AddCookie(cookies[0])
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+}
+
+// mail returns the next email sent, ok is false if none is sent within a second
+func (env *testEnv) mail() (m testMail, ok bool) {
+	select {
+	case m = <-env.mails:
+		return m, true
+	case <-time.After(time.Second):
+		return m, false
+	}
+}
+
+// noMail reports an error if an email is sent within a short time
+func (env *testEnv) noMail(t *testing.T) {
+	t.Helper()
+	select {
+	case m := <-env.mails:
+		t.Errorf("expected no email, got %q to %s", m.Subject, m.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// file returns the record of the file at name
+func (env *testEnv) file(t *testing.T, name string) (dbf fs.DBFile, found bool) {
+	t.Helper()
+	err := env.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(fs.FilesBucket).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &dbf)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbf, found
+}
@@ -0,0 +1,608 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/mailer"
+	"github.com/socialnotes/mirror/takedown"
+)
+
+const (
+	// maxNoticeField is the length in characters of the longest notice field accepted
+	maxNoticeField = 4000
+	// maxNoticePaths is the number of files a single notice can take down
+	maxNoticePaths = 100
+)
+
+// noticeField returns the form value name with normalized newlines,
+// ok is false if it is empty or too long
+func noticeField(req *http.Request, name string) (value string, ok bool) {
+	value = strings.TrimSpace(strings.Replace(req.FormValue(name), "\r\n", "\n", -1))
+	return value, value != "" && utf8.RuneCountInString(value) <= maxNoticeField
+}
+
+// parseNoticePaths extracts the paths of the files from the links in s, one per line.
+// Links to the preview of a file are accepted as well.
+func parseNoticePaths(s string) ([]string, error) {
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || u.Path == "" {
+			return nil, fmt.Errorf("%s is not a link to a file", line)
+		}
+		p := path.Clean("/" + u.Path)
+		if strings.HasPrefix(p, "/preview/") {
+			p = strings.TrimPrefix(p, "/preview")
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("please list the links of the files to take down")
+	}
+	if len(paths) > maxNoticePaths {
+		return nil, fmt.Errorf("a notice can list at most %d files", maxNoticePaths)
+	}
+	return paths, nil
+}
+
+func NewTakedownHandler(ts *Templates, db *bolt.DB, m *mailer.M, admins []string, prefix string) *TakedownHandler {
+	return &TakedownHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		admins: admins,
+		prefix: prefix,
+	}
+}
+
+// TakedownHandler receives the copyright notices asking to take files down.
+// Notices are sent to the admins once the requester confirms them by email,
+// and take their files down once an admin applies them.
+type TakedownHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	admins []string
+	prefix string
+}
+
+// noticePath is the path of the page of notice id, edits of the notice refer to it
+func (th *TakedownHandler) noticePath(id uint64) string {
+	return th.prefix + "/" + strconv.FormatUint(id, 10)
+}
+
+// render shows the notice form, or the notice n if it has an id.
+// sent is the message shown after a submission, if any.
+func (th *TakedownHandler) render(rw http.ResponseWriter, n takedown.Notice, sent string) {
+	rw.WriteHeader(http.StatusOK)
+	th.ts.Render(rw, "notice.html", struct {
+		Notice takedown.Notice
+		Sent   string
+	}{
+		Notice: n,
+		Sent:   sent,
+	})
+}
+
+// submit stores a notice and sends the confirmation email to the requester
+func (th *TakedownHandler) submit(rw http.ResponseWriter, req *http.Request) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		th.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	n := takedown.Notice{
+		Email:     strings.ToLower(ma.Address),
+		GoodFaith: req.FormValue("good_faith") != "",
+		Accurate:  req.FormValue("accurate") != "",
+		CreatedAt: time.Now(),
+		Status:    takedown.Received,
+	}
+	for field, v := range map[string]*string{"name": &n.Name, "address": &n.Address, "phone": &n.Phone, "work": &n.Work} {
+		value, ok := noticeField(req, field)
+		if !ok {
+			th.ts.Error(rw, http.StatusBadRequest, fmt.Sprintf("The %s field is required and must be shorter than %d characters.", field, maxNoticeField))
+			return nil
+		}
+		*v = value
+	}
+	if !n.GoodFaith || !n.Accurate {
+		th.ts.Error(rw, http.StatusBadRequest, "A notice must include both statements required by the Terms Of Service.")
+		return nil
+	}
+	if n.Paths, err = parseNoticePaths(req.FormValue("paths")); err != nil {
+		th.ts.Error(rw, http.StatusBadRequest, "The files are not valid: "+err.Error())
+		return nil
+	}
+
+	var (
+		token   string
+		missing []string
+	)
+	err = th.db.Update(func(tx *bolt.Tx) error {
+		for _, p := range n.Paths {
+			if tx.Bucket(fs.FilesBucket).Get([]byte(p)) == nil {
+				missing = append(missing, p)
+			}
+		}
+		if len(missing) > 0 {
+			return nil
+		}
+		var err error
+		token, err = takedown.AddPending(tx, n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		th.ts.Error(rw, http.StatusBadRequest, "These files do not exist: "+strings.Join(missing, ", "))
+		return nil
+	}
+
+	go func() {
+		link := (&url.URL{Path: th.prefix + "/", RawQuery: "token=" + token}).String()
+		if err := th.m.ConfirmNotice(n.Email, "takedown notice", link); err != nil {
+			log.Printf("[err] sending confirmation email for a takedown notice: %s\n", err)
+		}
+	}()
+
+	th.render(rw, takedown.Notice{}, "You will receive an email with a confirmation link. Your notice will reach the admins once you press on the link.")
+	return nil
+}
+
+// confirmNotice stores the notice waiting for confirmation with token
+func (th *TakedownHandler) confirmNotice(rw http.ResponseWriter, token string) error {
+	var (
+		n     takedown.Notice
+		found bool
+	)
+	err := th.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if n, found, err = takedown.TakePending(tx, token); err != nil || !found {
+			return err
+		}
+		if time.Since(n.CreatedAt) > editExpiry {
+			found = false
+			return nil
+		}
+		n.ID, err = takedown.Add(tx, n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		th.ts.Error(rw, http.StatusNotFound, "The notice does not exist, it has expired or it has already been confirmed.")
+		return nil
+	}
+	log.Printf("[warn] takedown notice %d from %s lists %d files, it needs a review\n", n.ID, n.Email, len(n.Paths))
+	th.render(rw, n, "Thank you, your notice has been sent to the admins.")
+	return nil
+}
+
+// review stores the decision of an admin on notice n and sends the confirmation email
+func (th *TakedownHandler) review(rw http.ResponseWriter, req *http.Request, n takedown.Notice) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		th.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	action := req.FormValue("action")
+	if action != fs.TakedownApply && action != fs.TakedownReject {
+		th.ts.Error(rw, http.StatusBadRequest, "The review action is not valid.")
+		return nil
+	}
+	email := strings.ToLower(ma.Address)
+	sent := "If you are an admin you will receive an email with a confirmation link. Your decision will be applied once you press on the link."
+	// everyone gets the same answer, so that the page does not tell who the admins are
+	if !isAdmin(th.admins, email) {
+		log.Printf("[warn] review of takedown notice %d by %s refused, not an admin\n", n.ID, email)
+		th.render(rw, n, sent)
+		return nil
+	}
+
+	edit := fs.DBEdit{
+		Kind:           fs.EditNotice,
+		Path:           th.noticePath(n.ID),
+		TakedownAction: action,
+		Notice:         n.ID,
+		Email:          email,
+		CreatedAt:      time.Now(),
+	}
+	var token string
+	err = th.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, _, err = storeEdit(tx, th.admins, edit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: edit.Path, RawQuery: "token=" + token}).String()
+		what := fmt.Sprintf("takedown notice %d (%s)", n.ID, action)
+		if err := th.m.ConfirmEdit(email, what, link); err != nil {
+			log.Printf("[err] sending confirmation email for the review of notice %d: %s\n", n.ID, err)
+		}
+	}()
+
+	th.render(rw, n, sent)
+	return nil
+}
+
+// confirmReview applies the decision of an admin on notice id identified by token
+func (th *TakedownHandler) confirmReview(rw http.ResponseWriter, req *http.Request, id uint64, token string) error {
+	status, message := 0, ""
+	var n takedown.Notice
+	// the uploaders of the files taken down, by path
+	uploaders := make(map[string]string)
+	err := th.db.Update(func(tx *bolt.Tx) error {
+		edit, st, msg, err := takeEdit(tx, th.admins, token, th.noticePath(id), fs.EditNotice)
+		if err != nil || st != 0 {
+			status, message = st, msg
+			return err
+		}
+		if edit.Notice != id || !isAdmin(th.admins, edit.Email) {
+			status, message = http.StatusBadRequest, "The edit does not review this notice."
+			return nil
+		}
+		n, _, err = takedown.Get(tx, id)
+		if err != nil {
+			return err
+		}
+		if n.Status != takedown.Received {
+			status, message = http.StatusConflict, "The notice has already been reviewed."
+			return nil
+		}
+
+		n.Status = takedown.Rejected
+		if edit.TakedownAction == fs.TakedownApply {
+			n.Status = takedown.Applied
+			for _, p := range n.Paths {
+				e := newEntry(req, edit.Email, audit.Takedown, p)
+				e.Details = fmt.Sprintf("notice %d", n.ID)
+				err := audit.Change(tx, e, func() error {
+					return updateFile(tx, p, func(dbf *fs.DBFile) error {
+						dbf.Takedown = &fs.Takedown{
+							Reason:    "Copyright notice about " + n.Work,
+							Requester: n.Name,
+							Date:      time.Now(),
+							Notice:    n.ID,
+						}
+						uploaders[p] = dbf.Email
+						return nil
+					})
+				})
+				if err != nil && err != errNoFile {
+					return err
+				}
+			}
+		}
+		n.ReviewedBy = edit.Email
+		n.ReviewedAt = time.Now()
+		log.Printf("[info] %s reviewed takedown notice %d: %s\n", edit.Email, id, n.Status)
+		return takedown.Put(tx, n)
+	})
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		th.ts.Error(rw, status, message)
+		return nil
+	}
+	go func() {
+		for p, email := range uploaders {
+			link := urlPath("/counter-notice" + p)
+			if err := th.m.NotifyTakedown(email, path.Base(p), n.Work, link); err != nil {
+				log.Printf("[err] telling the uploader of %s about its takedown: %s\n", p, err)
+			}
+		}
+	}()
+	th.render(rw, n, "The review has been applied.")
+	return nil
+}
+
+func (th *TakedownHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, th.prefix), "/")
+	if name == "" {
+		switch {
+		case req.Method == "POST":
+			return th.submit(rw, req)
+		case req.Method != "GET":
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return nil
+		case req.FormValue("token") != "":
+			return th.confirmNotice(rw, req.FormValue("token"))
+		}
+		th.render(rw, takedown.Notice{}, "")
+		return nil
+	}
+
+	id, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		th.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+	var (
+		n     takedown.Notice
+		found bool
+	)
+	err = th.db.View(func(tx *bolt.Tx) error {
+		n, found, err = takedown.Get(tx, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		th.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST":
+		return th.review(rw, req, n)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return th.confirmReview(rw, req, id, req.FormValue("token"))
+	}
+	th.render(rw, n, "")
+	return nil
+}
+
+func NewCounterNoticeHandler(ts *Templates, db *bolt.DB, m *mailer.M, admins []string, prefix string) *CounterNoticeHandler {
+	return &CounterNoticeHandler{
+		ts: ts,
+		db: db,
+		m:  m,
+
+		admins: admins,
+		prefix: prefix,
+	}
+}
+
+// CounterNoticeHandler lets the uploader of a file taken down answer the notice,
+// and admins restore the file once they are convinced by the answer
+type CounterNoticeHandler struct {
+	ts *Templates
+	db *bolt.DB
+	m  *mailer.M
+
+	admins []string
+	prefix string
+}
+
+// render shows the counter-notice form of the file at name.
+// sent is the message shown after a submission, if any.
+func (ch *CounterNoticeHandler) render(rw http.ResponseWriter, name string, file fs.DBFile, sent string) {
+	rw.WriteHeader(http.StatusOK)
+	ch.ts.Render(rw, "counter.html", struct {
+		Path string
+		File fs.DBFile
+		Sent string
+	}{
+		Path: name,
+		File: file,
+		Sent: sent,
+	})
+}
+
+// submit stores a counter-notice and sends the confirmation email to the uploader
+func (ch *CounterNoticeHandler) submit(rw http.ResponseWriter, req *http.Request, name string, file fs.DBFile) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		ch.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	cn := takedown.CounterNotice{
+		Path:      name,
+		Email:     strings.ToLower(ma.Address),
+		CreatedAt: time.Now(),
+	}
+	var ok bool
+	cn.Name, ok = noticeField(req, "name")
+	if ok {
+		cn.Statement, ok = noticeField(req, "statement")
+	}
+	if !ok || req.FormValue("good_faith") == "" {
+		ch.ts.Error(rw, http.StatusBadRequest, "A counter-notice must include your name, your statement and the good faith declaration.")
+		return nil
+	}
+	sent := "If you uploaded the file with this address you will receive an email with a confirmation link. Your counter-notice will reach the admins once you press on the link."
+	// everyone gets the same answer, so that the page does not tell who uploaded the file
+	if !strings.EqualFold(cn.Email, file.Email) {
+		log.Printf("[warn] counter-notice for %s by %s refused, not the uploader\n", name, cn.Email)
+		ch.render(rw, name, file, sent)
+		return nil
+	}
+
+	var token string
+	err = ch.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, err = takedown.AddPendingCounter(tx, cn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: ch.prefix + name, RawQuery: "token=" + token}).String()
+		if err := ch.m.ConfirmNotice(cn.Email, "counter-notice for "+path.Base(name), link); err != nil {
+			log.Printf("[err] sending confirmation email for the counter-notice of %s: %s\n", name, err)
+		}
+	}()
+
+	ch.render(rw, name, file, sent)
+	return nil
+}
+
+// restore stores the decision of an admin to restore the file and sends the confirmation email
+func (ch *CounterNoticeHandler) restore(rw http.ResponseWriter, req *http.Request, name string, file fs.DBFile) error {
+	ma, err := mail.ParseAddress(req.FormValue("email"))
+	if err != nil {
+		ch.ts.Error(rw, http.StatusBadRequest, "The email provided was not valid.")
+		return nil
+	}
+	email := strings.ToLower(ma.Address)
+	sent := "If you are an admin you will receive an email with a confirmation link. The file will be restored once you press on the link."
+	if !isAdmin(ch.admins, email) {
+		log.Printf("[warn] restore of %s by %s refused, not an admin\n", name, email)
+		ch.render(rw, name, file, sent)
+		return nil
+	}
+
+	edit := fs.DBEdit{
+		Kind:           fs.EditRestore,
+		Path:           name,
+		TakedownAction: fs.TakedownRestore,
+		Email:          email,
+		CreatedAt:      time.Now(),
+	}
+	var token string
+	err = ch.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, _, err = storeEdit(tx, ch.admins, edit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := (&url.URL{Path: ch.prefix + name, RawQuery: "token=" + token}).String()
+		if err := ch.m.ConfirmEdit(email, "the takedown of "+name+" (restore)", link); err != nil {
+			log.Printf("[err] sending confirmation email for the restore of %s: %s\n", name, err)
+		}
+	}()
+
+	ch.render(rw, name, file, sent)
+	return nil
+}
+
+// confirm stores the counter-notice, or restores the file, identified by token
+func (ch *CounterNoticeHandler) confirm(rw http.ResponseWriter, req *http.Request, name, token string) error {
+	status, message := 0, ""
+	var (
+		dbf      fs.DBFile
+		restored bool
+	)
+	err := ch.db.Update(func(tx *bolt.Tx) error {
+		cn, found, err := takedown.TakePendingCounter(tx, token)
+		if err != nil {
+			return err
+		}
+		if found {
+			switch {
+			case cn.Path != name:
+				status, message = http.StatusBadRequest, "The counter-notice belongs to another file."
+				return nil
+			case time.Since(cn.CreatedAt) > editExpiry:
+				status, message = http.StatusGone, "The counter-notice has expired, please submit it again."
+				return nil
+			}
+			return audit.Change(tx, newEntry(req, cn.Email, audit.Counter, name), func() error {
+				return updateFile(tx, name, func(f *fs.DBFile) error {
+					if f.Takedown == nil {
+						status, message = http.StatusConflict, "The file has already been restored."
+						return nil
+					}
+					f.Takedown.CounterName = cn.Name
+					f.Takedown.CounterStatement = cn.Statement
+					f.Takedown.CounterAt = time.Now()
+					dbf = *f
+					log.Printf("[warn] %s sent a counter-notice for %s, it needs a review\n", cn.Email, name)
+					return nil
+				})
+			})
+		}
+
+		edit, st, msg, err := takeEdit(tx, ch.admins, token, name, fs.EditRestore)
+		if err != nil || st != 0 {
+			status, message = st, msg
+			return err
+		}
+		if !isAdmin(ch.admins, edit.Email) {
+			status, message = http.StatusBadRequest, "Only admins can restore files."
+			return nil
+		}
+		restored = true
+		return audit.Change(tx, newEntry(req, edit.Email, audit.Reinstate, name), func() error {
+			return updateFile(tx, name, func(f *fs.DBFile) error {
+				if f.Takedown != nil {
+					log.Printf("[info] %s restored %s, taken down on %s\n", edit.Email, name, f.Takedown.Date.Format(time.RFC3339))
+				}
+				f.Takedown = nil
+				return nil
+			})
+		})
+	})
+	if err == errNoFile {
+		status, message, err = http.StatusNotFound, "The file does not exist anymore.", nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		ch.ts.Error(rw, status, message)
+		return nil
+	}
+	if restored {
+		http.Redirect(rw, req, urlPath("/preview"+name), http.StatusSeeOther)
+		return nil
+	}
+	ch.render(rw, name, dbf, "Thank you, your counter-notice has been sent to the admins.")
+	return nil
+}
+
+func (ch *CounterNoticeHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	name := path.Clean("/" + strings.TrimPrefix(req.URL.Path, ch.prefix))
+	file, found, err := storedFile(ch.db, name)
+	if err != nil {
+		return err
+	}
+	if !found || file.Takedown == nil {
+		ch.ts.Error(rw, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil
+	}
+
+	switch {
+	case req.Method == "POST" && req.FormValue("action") == fs.TakedownRestore:
+		return ch.restore(rw, req, name, file)
+	case req.Method == "POST":
+		return ch.submit(rw, req, name, file)
+	case req.Method != "GET":
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	case req.FormValue("token") != "":
+		return ch.confirm(rw, req, name, req.FormValue("token"))
+	}
+	ch.render(rw, name, file, "")
+	return nil
+}
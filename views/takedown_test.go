@@ -0,0 +1,86 @@
+package views
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/socialnotes/mirror/fs"
+)
+
+func TestTakedown(t *testing.T) {
+	env, cleanup := newTestEnv(t)
+	defer cleanup()
+	admins := []string{"admin@unitn.it"}
+	th := ToHandler(NewTakedownHandler(env.ts, env.db, env.m, admins, "/takedown"), env.ts)
+	ch := ToHandler(NewCounterNoticeHandler(env.ts, env.db, env.m, admins, "/counter-notice"), env.ts)
+	confirm := func(h http.Handler, target string) {
+		t.Helper()
+		mail, ok := env.mail()
+		if !ok {
+			t.Fatal("expected a confirmation email")
+		}
+		if rw := env.do(h, "GET", target+"?token="+mailToken(t, mail), nil); rw.Code != 200 && rw.Code != 303 {
+			t.Fatalf("expected %s to be confirmed, got %d", target, rw.Code)
+		}
+	}
+
+	rw := env.do(th, "POST", "/takedown/", url.Values{
+		"email":      {"holder@publisher.com"},
+		"name":       {"Holder"},
+		"address":    {"Via Roma 1"},
+		"phone":      {"0461"},
+		"work":       {"My Book"},
+		"paths":      {"https://example.com/preview/Fisica/a.txt"},
+		"good_faith": {"1"},
+		"accurate":   {"1"},
+	})
+	if rw.Code != 200 {
+		t.Fatalf("expected the notice to be accepted, got %d", rw.Code)
+	}
+	confirm(th, "/takedown/")
+
+	// strangers get the same answer as admins, but no email
+	review := func(email string) string {
+		return env.do(th, "POST", "/takedown/1", url.Values{"email": {email}, "action": {fs.TakedownApply}}).Body.String()
+	}
+	stranger := review("x@unitn.it")
+	env.noMail(t)
+	if admin := review("admin@unitn.it"); stranger != admin {
+		t.Errorf("expected the same page for every address:\n%s\n%s", stranger, admin)
+	}
+	confirm(th, "/takedown/1")
+	if dbf, _ := env.file(t, "/Fisica/a.txt"); dbf.Takedown == nil || dbf.Takedown.Notice != 1 {
+		t.Fatalf("expected the file to be taken down, got %+v", dbf)
+	}
+
+	// the uploader is told how to answer
+	mail, ok := env.mail()
+	if !ok || mail.To != "a@unitn.it" || !strings.Contains(mail.Text, "https://example.com/counter-notice/Fisica/a.txt\n") {
+		t.Fatalf("expected the uploader to receive the counter-notice link, got %+v", mail)
+	}
+
+	// everyone gets the same answer, only the uploader receives the confirmation
+	counter := func(email string) string {
+		rw := env.do(ch, "POST", "/counter-notice/Fisica/a.txt", url.Values{
+			"email":      {email},
+			"name":       {"Uploader"},
+			"statement":  {"I wrote these notes"},
+			"good_faith": {"1"},
+		})
+		if rw.Code != 200 {
+			t.Errorf("expected the counter-notice to be accepted, got %d", rw.Code)
+		}
+		return strings.Replace(rw.Body.String(), email, "EMAIL", -1)
+	}
+	stranger = counter("x@unitn.it")
+	env.noMail(t)
+	if uploader := counter("A@unitn.it"); stranger != uploader {
+		t.Errorf("expected the same page for every address:\n%s\n%s", stranger, uploader)
+	}
+	confirm(ch, "/counter-notice/Fisica/a.txt")
+	if dbf, _ := env.file(t, "/Fisica/a.txt"); dbf.Takedown == nil || dbf.Takedown.CounterName != "Uploader" {
+		t.Errorf("expected the counter-notice to be stored, got %+v", dbf.Takedown)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/thumbs"
 )
 
 var (
@@ -14,6 +17,9 @@ var (
 
 	funcs = template.FuncMap{
 		"humanizeBytes": humanizeBytes,
+		"hasThumbnail":  thumbs.Supported,
+		"inc":           func(i int) int { return i + 1 },
+		"documentTypes": func() []string { return fs.DocumentTypes },
 	}
 )
 
@@ -0,0 +1,71 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/fs"
+	"github.com/socialnotes/mirror/stats"
+)
+
+const topFiles = 100
+
+// topFile is a file in the most downloaded ranking
+type topFile struct {
+	Path      string
+	Downloads uint64
+	File      fs.DBFile
+}
+
+func NewTopHandler(ts *Templates, db *bolt.DB) *TopHandler {
+	return &TopHandler{
+		ts: ts,
+		db: db,
+	}
+}
+
+// TopHandler shows the most downloaded files of the whole site
+type TopHandler struct {
+	ts *Templates
+	db *bolt.DB
+}
+
+func (th *TopHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	files := make([]topFile, 0, topFiles)
+	err := th.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fs.FilesBucket)
+		published := make(map[string]fs.DBFile)
+		top := stats.Top(tx, topFiles, func(path string) bool {
+			v := bucket.Get([]byte(path))
+			if v == nil {
+				return false
+			}
+			dbf := fs.DBFile{}
+			if err := json.Unmarshal(v, &dbf); err != nil || !dbf.Published() {
+				return false
+			}
+			published[path] = dbf
+			return true
+		})
+		for _, e := range top {
+			files = append(files, topFile{
+				Path:      e.Path,
+				Downloads: e.Downloads,
+				File:      published[e.Path],
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	th.ts.Render(rw, "top.html", struct {
+		Files []topFile
+	}{
+		Files: files,
+	})
+	return nil
+}
@@ -13,13 +13,16 @@ import (
 	"strings"
 
 	"github.com/boltdb/bolt"
+	"github.com/socialnotes/mirror/audit"
 	"github.com/socialnotes/mirror/fs"
 	"github.com/socialnotes/mirror/mailer"
 	"github.com/satori/go.uuid"
 )
 
 var (
-	errFileExists = errors.New("file exists")
+	errFileExists   = errors.New("file exists")
+	errNoDirectory  = errors.New("no such directory")
+	errUploadClosed = errors.New("directory closed to uploads")
 )
 
 type UploadHandler struct {
@@ -28,17 +31,20 @@ type UploadHandler struct {
 	db *bolt.DB
 	m  *mailer.M
 
-	prefix string
+	// moderation holds the confirmed uploads until a moderator approves them
+	moderation bool
+	prefix     string
 }
 
-func NewUploadHandler(fs fs.Dir, ts *Templates, db *bolt.DB, m *mailer.M, prefix string) *UploadHandler {
+func NewUploadHandler(fs fs.Dir, ts *Templates, db *bolt.DB, m *mailer.M, moderation bool, prefix string) *UploadHandler {
 	return &UploadHandler{
 		fs: fs,
 		ts: ts,
 		db: db,
 		m:  m,
 
-		prefix: prefix,
+		moderation: moderation,
+		prefix:     prefix,
 	}
 }
 
@@ -61,6 +67,8 @@ func (uh *UploadHandler) handleUpload(rw http.ResponseWriter, req *http.Request)
 		info      os.FileInfo
 		directory = path.Clean(strings.TrimPrefix(req.URL.Path, uh.prefix))
 		dbf       fs.DBFile
+		filename  string
+		revision  *fs.DBRevision
 	)
 
 	email, err := checkEmail(req.FormValue("email"))
@@ -70,38 +78,111 @@ func (uh *UploadHandler) handleUpload(rw http.ResponseWriter, req *http.Request)
 		return nil
 	}
 
-	err = uh.db.Update(func(tx *bolt.Tx) error {
+	meta, err := parseMetadata(req)
+	if err != nil {
+		status = http.StatusBadRequest
+		uh.ts.Error(rw, status, "The description of the file was not valid: "+err.Error())
+		return nil
+	}
+
+	err = UpdateFiles(uh.db, uh.fs, func(tx *bolt.Tx, files *Files) error {
+		if err := checkBan(tx, email); err != nil {
+			return err
+		}
 		bucket := tx.Bucket(fs.FilesBucket)
 		if exists := bucket.Get([]byte(directory)) != nil; exists {
 			return errFileExists
 		}
+		dbd, found, err := fs.GetDirectory(tx, strings.TrimSuffix(directory, "/")+"/")
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errNoDirectory
+		}
+		if !dbd.UploadOpen {
+			return errUploadClosed
+		}
+
+		_, fh, err := req.FormFile("document")
+		if err != nil {
+			return err
+		}
+		filename = path.Base(fh.Filename)
+		// the uploader of a file can replace it with a new revision
+		if v := bucket.Get([]byte(path.Join(directory, filename))); v != nil {
+			current := fs.DBFile{}
+			if err := json.Unmarshal(v, &current); err != nil {
+				return err
+			}
+			if !current.Published() || !strings.EqualFold(current.Email, email) {
+				return errFileExists
+			}
+			n, err := fs.NextRevision(tx, path.Join(directory, filename), current)
+			if err != nil {
+				return err
+			}
+			// the record of the file changes once the revision is confirmed
+			e := newEntry(req, email, audit.Upload, path.Join(directory, filename))
+			e.Details = fmt.Sprintf("revision %d", n)
+			return audit.Change(tx, e, func() error {
+				r, err := uh.copyRevision(tx, files, req, path.Join(directory, filename), current, n)
+				revision = &r
+				return err
+			})
+		}
 
-		if info, err = uh.copyFiles(req, directory); err != nil {
+		if info, err = uh.copyFiles(files, req, directory); err != nil {
 			return err
 		}
 		dbf = fs.FromFileInfo(info)
 		dbf.Authorized = false
+		dbf.AwaitingApproval = uh.moderation
 		dbf.Email = email
+		dbf.Metadata = meta
 		dbf.Token = uuid.Must(uuid.NewV4()).String()
 		data, err := json.Marshal(dbf)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(path.Join(directory, info.Name())), data)
+		e := newEntry(req, email, audit.Upload, path.Join(directory, info.Name()))
+		return audit.Change(tx, e, func() error {
+			return bucket.Put([]byte(path.Join(directory, info.Name())), data)
+		})
 	})
 
 	if err != nil {
 		if err == errFileExists {
 			status = http.StatusConflict
-			uh.ts.Error(rw, status, "A file with the same name already exists")
+			uh.ts.Error(rw, status, "A file with the same name already exists, only the email which uploaded it can upload a new revision")
 			return nil
 		}
-		return fmt.Errorf("processing upload for %s: %s", path.Join(directory, info.Name()), err)
+		if err == errNoDirectory {
+			status = http.StatusNotFound
+			uh.ts.Error(rw, status, "The directory does not exist")
+			return nil
+		}
+		if err == errUploadClosed {
+			status = http.StatusForbidden
+			uh.ts.Error(rw, status, "This directory does not accept uploads")
+			return nil
+		}
+		if be, ok := err.(*bannedError); ok {
+			status = http.StatusForbidden
+			log.Printf("[warn] upload to %s refused, %s is banned\n", directory, email)
+			uh.ts.Error(rw, status, be.message())
+			return nil
+		}
+		return fmt.Errorf("processing upload for %s: %s", path.Join(directory, filename), err)
 	}
 
+	token, number := dbf.Token, uint64(0)
+	if revision != nil {
+		token, number = revision.Token, revision.Number
+	}
 	go func() {
-		if err := uh.m.ConfirmUpload(dbf.Email, info.Name(), dbf.Token); err != nil {
-			log.Printf("sending confirmation email for %s: %s\n", path.Join(directory, info.Name()), err)
+		if err := uh.m.ConfirmUpload(email, filename, token); err != nil {
+			log.Printf("sending confirmation email for %s: %s\n", path.Join(directory, filename), err)
 		}
 	}()
 
@@ -110,15 +191,17 @@ func (uh *UploadHandler) handleUpload(rw http.ResponseWriter, req *http.Request)
 		Path     string
 		Filename string
 		Email    string
+		Revision uint64
 	}{
 		Path:     directory,
-		Filename: info.Name(),
+		Filename: filename,
 		Email:    email,
+		Revision: number,
 	})
 	return nil
 }
 
-func (uh *UploadHandler) copyFiles(req *http.Request, dir string) (os.FileInfo, error) {
+func (uh *UploadHandler) copyFiles(files *Files, req *http.Request, dir string) (os.FileInfo, error) {
 	// TODO: create intermediate directories
 	// TODO: add support for multiple upload
 	f, fh, err := req.FormFile("document")
@@ -135,13 +218,45 @@ func (uh *UploadHandler) copyFiles(req *http.Request, dir string) (os.FileInfo,
 		return nil, err
 	}
 
+	files.created(filePath)
+	return uh.writeFile(f, filePath)
+}
+
+// copyRevision stores the uploaded document as revision number of the file at name,
+// which replaces current once confirmed
+func (uh *UploadHandler) copyRevision(tx *bolt.Tx, files *Files, req *http.Request, name string, current fs.DBFile, number uint64) (fs.DBRevision, error) {
+	r := fs.DBRevision{Number: number}
+	f, _, err := req.FormFile("document")
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+	filePath := fs.RevisionPath(name, r.Number)
+	if err := uh.fs.MkdirAll(path.Dir(filePath)); err != nil {
+		return r, err
+	}
+	files.created(filePath)
+	fi, err := uh.writeFile(f, filePath)
+	if err != nil {
+		return r, err
+	}
+	r.Size = fi.Size()
+	r.ModTime = fi.ModTime()
+	r.Email = current.Email
+	r.Token = uuid.Must(uuid.NewV4()).String()
+	r.AwaitingApproval = uh.moderation
+	return r, fs.PutRevision(tx, name, r)
+}
+
+// writeFile copies src to a new file at filePath
+func (uh *UploadHandler) writeFile(src io.Reader, filePath string) (os.FileInfo, error) {
 	fsf, err := uh.fs.Create(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer fsf.Close()
 
-	_, err = io.Copy(fsf, f)
+	_, err = io.Copy(fsf, src)
 	if err != nil {
 		return nil, err
 	}